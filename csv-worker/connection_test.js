@@ -34,13 +34,16 @@ function parseCsv(path) {
   })
 }
 
-function canonicalImportKey({ userId, date, amount, merchant, category, description }) {
+// The key is scoped to the workspace, as is the unique constraint on
+// import_id: the same statement imported by two members of a household is
+// one set of rows, and the same file imported into two workspaces is two.
+function canonicalImportKey({ workspaceId, date, amount, merchant, category, description }) {
   const d = String(date).trim()                 
   const a = Number(amount)                     
   const m = String(merchant ?? '').trim().toLowerCase()
   const c = String(category ?? '').trim().toLowerCase()
   const desc = String(description ?? '').trim()
-  return `${userId}|${d}|${a}|${m}|${c}|${desc}`
+  return `${workspaceId}|${d}|${a}|${m}|${c}|${desc}`
 }
 
 function sha256Hex(s) {
@@ -54,6 +57,74 @@ async function resolveUserId(pool) {
   throw new Error('No users found; create a user first (register endpoint).')
 }
 
+// With REQUIRE_VERIFIED_EMAIL=true, only users who have confirmed their
+// email address may import.
+async function assertCanImport(pool, userId) {
+  if (String(process.env.REQUIRE_VERIFIED_EMAIL).toLowerCase() !== 'true') return
+  const r = await pool.request()
+    .input('id', sql.Int, userId)
+    .query('SELECT email_verified FROM dbo.users WHERE id = @id')
+  if (!r.recordset.length) throw new Error(`No user with id ${userId}`)
+  if (!r.recordset[0].email_verified) {
+    throw new Error(`User ${userId} has not verified their email address; refusing to import.`)
+  }
+}
+
+// Transactions belong to a workspace (household). WORKSPACE_ID picks one;
+// otherwise the user's default is used. The user must be able to edit it.
+async function resolveWorkspaceId(pool, userId) {
+  const r = await pool.request()
+    .input('user_id', sql.Int, userId)
+    .input('workspace_id', sql.Int, process.env.WORKSPACE_ID ? Number(process.env.WORKSPACE_ID) : null)
+    .query(`SELECT m.workspace_id, m.role
+            FROM dbo.workspace_members m
+            JOIN dbo.users u ON u.id = m.user_id
+            WHERE m.user_id = @user_id
+              AND m.workspace_id = COALESCE(@workspace_id, u.default_workspace_id)`)
+  if (!r.recordset.length) throw new Error(`User ${userId} is not a member of that workspace`)
+  const { workspace_id, role } = r.recordset[0]
+  if (role !== 'owner' && role !== 'editor') {
+    throw new Error(`User ${userId} may only view workspace ${workspace_id}; refusing to import.`)
+  }
+  return workspace_id
+}
+
+// Each run is recorded in dbo.import_runs so its outcome is visible through
+// the admin API, and in dbo.audit_log with the workspace it wrote to.
+async function startRun(pool, userId, workspaceId, source, rowsTotal) {
+  const r = await pool.request()
+    .input('user_id', sql.Int, userId)
+    .input('source', sql.NVarChar(260), source)
+    .input('rows_total', sql.Int, rowsTotal)
+    .query(`INSERT INTO dbo.import_runs (user_id, source, rows_total)
+            OUTPUT INSERTED.id
+            VALUES (@user_id, @source, @rows_total)`)
+  return { id: r.recordset[0].id, userId, workspaceId, source }
+}
+
+async function finishRun(pool, run, status, counts, error) {
+  await pool.request()
+    .input('id', sql.BigInt, run.id)
+    .input('status', sql.VarChar(16), status)
+    .input('inserted', sql.Int, counts.inserted)
+    .input('skipped', sql.Int, counts.skipped)
+    .input('failed', sql.Int, counts.failed)
+    .input('error', sql.NVarChar(1000), error ? String(error).slice(0, 1000) : null)
+    .query(`UPDATE dbo.import_runs
+            SET status = @status, inserted = @inserted, skipped = @skipped, failed = @failed,
+                error = @error, finished_at = SYSUTCDATETIME()
+            WHERE id = @id`)
+
+  await pool.request()
+    .input('user_id', sql.Int, run.userId)
+    .input('workspace_id', sql.Int, run.workspaceId)
+    .input('entity_id', sql.NVarChar(64), String(run.id))
+    .input('after_json', sql.NVarChar(sql.MAX), JSON.stringify({ source: run.source, status, ...counts }))
+    .query(`INSERT INTO dbo.audit_log
+              (actor_user_id, user_id, workspace_id, action, entity_type, entity_id, after_json)
+            VALUES (NULL, @user_id, @workspace_id, 'import.run', 'import', @entity_id, @after_json)`)
+}
+
 async function main() {
   const csvPath = process.argv[2] || './sample.csv'
   const rows = await parseCsv(csvPath)
@@ -63,66 +134,99 @@ async function main() {
   try {
     const userId = await resolveUserId(pool)
     console.log(`Using user_id=${userId}`)
-
-    const ps = new sql.PreparedStatement(pool)
-    ps.input('user_id', sql.Int)
-    ps.input('date', sql.Date)
-    ps.input('amount', sql.Decimal(19, 4))
-    ps.input('merchant', sql.NVarChar(100))
-    ps.input('category', sql.NVarChar(80))
-    ps.input('description', sql.NVarChar(1000))
-    ps.input('import_id', sql.VarChar(128))
-
-    await ps.prepare(`
-      INSERT INTO dbo.transactions
-        (user_id, [date], amount, merchant, category, description, import_id)
-      VALUES
-        (@user_id, @date, @amount, @merchant, @category, @description, @import_id)
-    `)
-
-    let inserted = 0, skipped = 0, failed = 0
-
-    for (const raw of rows) {
-      const date = String(raw.date).trim()                
-      const amount = Number(raw.amount)                    
-      const merchant = String(raw.merchant ?? '').trim()
-      let category = String(raw.category ?? '').trim()
-      const description = String(raw.description ?? '').trim()
-
-    if (!category || category.toLowerCase() === 'uncategorized') {
-        const mLower = merchant.toLowerCase()
-        const rule = CATEGORY_RULES.find(r => r.match.some(k => mLower.includes(k)))
-        category = rule ? rule.category : 'Uncategorized'
+    await assertCanImport(pool, userId)
+    const workspaceId = await resolveWorkspaceId(pool, userId)
+    console.log(`Using workspace_id=${workspaceId}`)
+
+    const run = await startRun(pool, userId, workspaceId, csvPath, rows.length)
+    const counts = { inserted: 0, skipped: 0, failed: 0 }
+    try {
+      Object.assign(counts, await importRows(pool, run, rows))
+    } catch (err) {
+      await finishRun(pool, run, 'failed', counts, err?.message || err)
+      throw err
     }
+    await finishRun(pool, run, counts.failed ? 'failed' : 'succeeded', counts,
+      counts.failed ? `${counts.failed} rows failed` : null)
+    console.log(`Done. Inserted: ${counts.inserted}, Skipped (dupes): ${counts.skipped}, Failed: ${counts.failed}`)
+  } finally {
+    await sql.close()
+  }
+}
 
-      const key = canonicalImportKey({ userId, date, amount, merchant, category, description })
-      const import_id = sha256Hex(key)
-
-      try {
-        await ps.execute({
-          user_id: userId,
-          date,
-          amount,
-          merchant,
-          category,
-          description,
-          import_id,
-        })
-        inserted++
-      } catch (err) {
-        if (err && (err.number === 2627 || err.number === 2601)) {
-          skipped++
-        } else {
-          failed++
-          console.error(`Row failed:`, err?.message || err)
-        }
+// Each row is inserted in a transaction with its dbo.audit_log entry, as the
+// auth service does for the edits it makes, so that a row never appears in
+// a workspace without a record of which run brought it in.
+async function importRows(pool, run, rows) {
+  let inserted = 0, skipped = 0, failed = 0
+
+  for (const raw of rows) {
+    const date = String(raw.date).trim()                
+    const amount = Number(raw.amount)                    
+    const merchant = String(raw.merchant ?? '').trim()
+    let category = String(raw.category ?? '').trim()
+    const description = String(raw.description ?? '').trim()
+
+  if (!category || category.toLowerCase() === 'uncategorized') {
+      const mLower = merchant.toLowerCase()
+      const rule = CATEGORY_RULES.find(r => r.match.some(k => mLower.includes(k)))
+      category = rule ? rule.category : 'Uncategorized'
+  }
+
+    const key = canonicalImportKey({ workspaceId: run.workspaceId, date, amount, merchant, category, description })
+    const import_id = sha256Hex(key)
+
+    try {
+      await insertRow(pool, run, { date, amount, merchant, category, description, import_id })
+      inserted++
+    } catch (err) {
+      if (err && (err.number === 2627 || err.number === 2601)) {
+        skipped++
+      } else {
+        failed++
+        console.error(`Row failed:`, err?.message || err)
       }
     }
+  }
 
-    await ps.unprepare()
-    console.log(`Done. Inserted: ${inserted}, Skipped (dupes): ${skipped}, Failed: ${failed}`)
-  } finally {
-    await sql.close()
+  return { inserted, skipped, failed }
+}
+
+async function insertRow(pool, run, row) {
+  const tx = new sql.Transaction(pool)
+  await tx.begin()
+  try {
+    const r = await new sql.Request(tx)
+      .input('user_id', sql.Int, run.userId)
+      .input('workspace_id', sql.Int, run.workspaceId)
+      .input('date', sql.Date, row.date)
+      .input('amount', sql.Decimal(19, 4), row.amount)
+      .input('merchant', sql.NVarChar(100), row.merchant)
+      .input('category', sql.NVarChar(80), row.category)
+      .input('description', sql.NVarChar(1000), row.description)
+      .input('import_id', sql.VarChar(128), row.import_id)
+      .query(`INSERT INTO dbo.transactions
+                (user_id, workspace_id, [date], amount, merchant, category, description, import_id)
+              OUTPUT INSERTED.id
+              VALUES
+                (@user_id, @workspace_id, @date, @amount, @merchant, @category, @description, @import_id)`)
+
+    await new sql.Request(tx)
+      .input('user_id', sql.Int, run.userId)
+      .input('workspace_id', sql.Int, run.workspaceId)
+      .input('entity_id', sql.NVarChar(64), String(r.recordset[0].id))
+      .input('after_json', sql.NVarChar(sql.MAX), JSON.stringify({
+        import_run_id: run.id, date: row.date, amount: row.amount, merchant: row.merchant,
+        category: row.category, description: row.description,
+      }))
+      .query(`INSERT INTO dbo.audit_log
+                (actor_user_id, user_id, workspace_id, action, entity_type, entity_id, after_json)
+              VALUES (NULL, @user_id, @workspace_id, 'transaction.import', 'transaction', @entity_id, @after_json)`)
+
+    await tx.commit()
+  } catch (err) {
+    await tx.rollback().catch(() => {})
+    throw err
   }
 }
 
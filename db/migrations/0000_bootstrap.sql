@@ -1,4 +0,0 @@
-CREATE TABLE schema_migrations (
-    FileName VARCHAR(50) PRIMARY KEY,
-    applied_at DATETIME2(0) DEFAULT SYSUTCDATETIME()
-)
\ No newline at end of file
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"auth-service/config"
+	"auth-service/migrate"
+	"auth-service/migrations"
+	"auth-service/sqldb"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `usage: auth-service migrate [flags] status | up | down [N]
+
+  status   list migrations and whether each has been applied
+  up       apply every pending migration
+  down     revert the last N applied migrations (default 1)
+
+The database is found as for the service: by -database-conn, DB_CONN or
+database.conn in the -config file.`
+
+// runMigrate implements the migrate subcommand and returns the exit code.
+// It reads the configuration like the service but needs only the
+// database, so it can run before the rest of the service is configured.
+func runMigrate(args []string) int {
+	dbConfig, args, err := config.LoadDatabase(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 2
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			fmt.Fprintln(os.Stderr, "migrate down: N must be a positive number")
+			return 2
+		}
+		steps = n
+	case len(args) != 1:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := sqldb.Open(dbConfig.Conn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	runner, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading migrations: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	var names []string
+	switch args[0] {
+	case "status":
+		st, err := runner.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate status: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, s := range st {
+			fmt.Fprintf(w, "%s\t%s\n", s.Name, s)
+		}
+		w.Flush()
+		return 0
+	case "up":
+		names, err = runner.Up(ctx)
+	case "down":
+		names, err = runner.Down(ctx, steps)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	// Report what was done even if a later migration failed.
+	for _, n := range names {
+		fmt.Printf("%s %s\n", args[0], n)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s: %v\n", args[0], err)
+		return 1
+	}
+	if len(names) == 0 {
+		fmt.Println("nothing to do")
+	}
+	return 0
+}
@@ -0,0 +1,287 @@
+// Package config holds the service's settings. Every setting has a
+// default and can be given in a YAML or TOML file, in an environment
+// variable or with a command-line flag; each of these overrides the ones
+// before it. See Load.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	HTTP       HTTP       `key:"http"`
+	Database   Database   `key:"database"`
+	Tokens     Tokens     `key:"tokens"`
+	Cookies    Cookies    `key:"cookies"`
+	Mail       Mail       `key:"mail"`
+	Passwords  Passwords  `key:"passwords"`
+	Login      Login      `key:"login"`
+	TOTP       TOTP       `key:"totp"`
+	WebAuthn   WebAuthn   `key:"webauthn"`
+	OIDC       OIDC       `key:"oidc"`
+	Workspaces Workspaces `key:"workspaces"`
+}
+
+type HTTP struct {
+	Addr string `key:"addr" env:"HTTP_ADDR" default:":8080" help:"address to listen on"`
+	// BaseURL is the public URL of the service, used in links it sends.
+	BaseURL        string        `key:"base_url" env:"APP_BASE_URL" default:"http://localhost:8080" help:"public URL of the service"`
+	RequestTimeout time.Duration `key:"request_timeout" env:"REQUEST_TIMEOUT" default:"3s" help:"deadline for the work of one request"`
+
+	// The timeouts of http.Server. WriteTimeout runs from the end of the
+	// request headers, so it must leave room for RequestTimeout.
+	ReadHeaderTimeout time.Duration `key:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" default:"5s" help:"time allowed to read request headers"`
+	ReadTimeout       time.Duration `key:"read_timeout" env:"HTTP_READ_TIMEOUT" default:"15s" help:"time allowed to read a whole request"`
+	WriteTimeout      time.Duration `key:"write_timeout" env:"HTTP_WRITE_TIMEOUT" default:"30s" help:"time allowed to write a response"`
+	IdleTimeout       time.Duration `key:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" default:"2m" help:"how long an idle keep-alive connection stays open"`
+	MaxHeaderBytes    int           `key:"max_header_bytes" env:"HTTP_MAX_HEADER_BYTES" default:"1048576" help:"largest request header accepted, in bytes"`
+	// ShutdownTimeout bounds the wait for in-flight requests and
+	// background work after SIGTERM.
+	ShutdownTimeout time.Duration `key:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" default:"30s" help:"time allowed to drain on shutdown"`
+
+	// TLS is served when both files are set. They are read again when
+	// they change, so a renewed certificate needs no restart.
+	TLSCertFile string `key:"tls_cert_file" env:"TLS_CERT_FILE" help:"PEM certificate chain to serve HTTPS with"`
+	TLSKeyFile  string `key:"tls_key_file" env:"TLS_KEY_FILE" help:"PEM private key of the certificate"`
+
+	// TrustedProxies are the addresses or CIDR ranges of the reverse
+	// proxies in front of the service. X-Forwarded-For is believed only
+	// from them; with none set the client IP, which keys rate limits and
+	// lockouts and goes into the audit log, is the peer address.
+	TrustedProxies []string `key:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES" help:"proxies whose X-Forwarded-For is trusted"`
+}
+
+type Database struct {
+	// Conn is a DSN as accepted by sqldb.Open.
+	Conn string `key:"conn" env:"DB_CONN" secret:"true" help:"database connection string"`
+}
+
+type Tokens struct {
+	SigningAlg string `key:"signing_alg" env:"JWT_SIGNING_ALG" default:"HS256" help:"HS256, RS256 or EdDSA"`
+	// Secret is the HMAC key for HS256.
+	Secret string `key:"secret" env:"JWT_SECRET" secret:"true" help:"HMAC secret for HS256"`
+	// KeyDir holds the private keys for RS256 and EdDSA.
+	KeyDir      string        `key:"key_dir" env:"JWT_KEY_DIR" help:"directory of signing keys for RS256 and EdDSA"`
+	RotateEvery time.Duration `key:"rotate_every" env:"JWT_ROTATE_EVERY" default:"720h" help:"age at which a new signing key is made; 0 disables rotation"`
+	// ReloadEvery is how often the key directory is read for keys made by
+	// other instances. A new key is published for PublishAhead before it
+	// signs, so that every instance and JWKS consumer knows it by then.
+	ReloadEvery  time.Duration `key:"reload_every" env:"JWT_RELOAD_EVERY" default:"5m" help:"interval at which the key directory is reloaded"`
+	PublishAhead time.Duration `key:"publish_ahead" env:"JWT_PUBLISH_AHEAD" default:"1h" help:"how long a new key is published before it signs"`
+	TTL          time.Duration `key:"ttl" env:"TOKEN_TTL" default:"1h" help:"lifetime of a session token"`
+}
+
+type Cookies struct {
+	Domain   string `key:"domain" env:"COOKIE_DOMAIN" help:"Domain attribute of the session cookies"`
+	Secure   bool   `key:"secure" env:"COOKIE_SECURE" default:"false" help:"send the session cookies over HTTPS only"`
+	SameSite string `key:"same_site" env:"COOKIE_SAMESITE" default:"lax" help:"lax, strict or none"`
+	// CSRFSecret must be the same on every instance; if empty, each
+	// process makes up its own.
+	CSRFSecret     string   `key:"csrf_secret" env:"CSRF_SECRET" secret:"true" help:"key for CSRF tokens, shared by all instances"`
+	TrustedOrigins []string `key:"trusted_origins" env:"CSRF_TRUSTED_ORIGINS" help:"other origins allowed to make cookie requests"`
+}
+
+type Mail struct {
+	From string `key:"from" env:"MAIL_FROM" default:"no-reply@localhost" help:"sender address"`
+	// Mail goes through SMTPAddr when set, and otherwise to Dir or the log.
+	SMTPAddr     string `key:"smtp_addr" env:"MAIL_SMTP_ADDR" help:"SMTP server host:port"`
+	SMTPUser     string `key:"smtp_user" env:"MAIL_SMTP_USER" help:"SMTP user name"`
+	SMTPPassword string `key:"smtp_password" env:"MAIL_SMTP_PASSWORD" secret:"true" help:"SMTP password"`
+	Dir          string `key:"dir" env:"MAIL_DIR" help:"directory to write mail to when there is no SMTP server"`
+}
+
+// Passwords picks the scheme for new hashes; hashes in the other scheme,
+// or with a lower cost, are upgraded on login. The defaults are those of
+// package password.
+type Passwords struct {
+	Hash            string `key:"hash" env:"PASSWORD_HASH" default:"argon2id" help:"argon2id or bcrypt"`
+	Argon2Time      uint32 `key:"argon2_time" env:"ARGON2_TIME" default:"3" help:"argon2id passes"`
+	Argon2MemoryKiB uint32 `key:"argon2_memory_kib" env:"ARGON2_MEMORY_KIB" default:"65536" help:"argon2id memory in KiB"`
+	Argon2Threads   uint8  `key:"argon2_threads" env:"ARGON2_THREADS" default:"4" help:"argon2id parallelism"`
+	BcryptCost      int    `key:"bcrypt_cost" env:"BCRYPT_COST" default:"10" help:"bcrypt cost"`
+	MinLength       int    `key:"min_length" env:"PASSWORD_MIN_LENGTH" default:"8" help:"shortest password accepted"`
+	MinScore        int    `key:"min_score" env:"PASSWORD_MIN_SCORE" default:"2" help:"lowest strength score (0-4) accepted"`
+	// BreachDir is a directory of Pwned Passwords range files.
+	BreachDir string `key:"breach_dir" env:"PASSWORD_BREACH_DIR" help:"directory of breached password range files"`
+	ResetURL  string `key:"reset_url" env:"PASSWORD_RESET_URL" help:"page that completes a password reset (default BASE_URL/password/reset)"`
+}
+
+type Login struct {
+	MaxFailures int           `key:"max_failures" env:"LOGIN_MAX_FAILURES" default:"10" help:"wrong passwords in a row that lock an account"`
+	Lockout     time.Duration `key:"lockout" env:"LOGIN_LOCKOUT" default:"15m" help:"how long an account stays locked"`
+}
+
+type TOTP struct {
+	Issuer string `key:"issuer" env:"TOTP_ISSUER" default:"Finance Dashboard" help:"name shown in authenticator apps and passkey prompts"`
+}
+
+type WebAuthn struct {
+	// Passkeys are disabled unless RPID is set.
+	RPID      string   `key:"rp_id" env:"WEBAUTHN_RP_ID" help:"relying party ID; enables passkeys"`
+	RPOrigins []string `key:"rp_origins" env:"WEBAUTHN_RP_ORIGINS" help:"origins passkeys may be used from"`
+}
+
+// OIDC configures OpenID Connect login. Each name in Names is a provider
+// set up by the oidc.<name>.* keys or the OIDC_<NAME>_* variables.
+type OIDC struct {
+	Names         []string `key:"providers" env:"OIDC_PROVIDERS" help:"names of the OpenID Connect providers"`
+	AfterLoginURL string   `key:"after_login_url" env:"OIDC_AFTER_LOGIN_URL" help:"page to return to after an OpenID Connect login"`
+	Providers     []OIDCProvider
+}
+
+type OIDCProvider struct {
+	Name         string
+	Issuer       string `key:"issuer" env:"ISSUER" help:"issuer URL"`
+	ClientID     string `key:"client_id" env:"CLIENT_ID" help:"client ID"`
+	ClientSecret string `key:"client_secret" env:"CLIENT_SECRET" secret:"true" help:"client secret"`
+	// TrustEmail accepts the provider's email as verified.
+	TrustEmail bool `key:"trust_email" env:"TRUST_EMAIL" default:"false" help:"treat the provider's emails as verified"`
+}
+
+type Workspaces struct {
+	InviteURL string `key:"invite_url" env:"WORKSPACE_INVITE_URL" help:"page that accepts an invitation (default BASE_URL/workspaces/invites/accept)"`
+}
+
+// Validate reports every setting that is missing or out of range.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.HTTP.Addr == "" {
+		bad("http.addr is required")
+	}
+	if c.HTTP.RequestTimeout <= 0 {
+		bad("http.request_timeout must be positive")
+	}
+	for _, d := range []struct {
+		key string
+		v   time.Duration
+	}{
+		{"read_header_timeout", c.HTTP.ReadHeaderTimeout},
+		{"read_timeout", c.HTTP.ReadTimeout},
+		{"write_timeout", c.HTTP.WriteTimeout},
+		{"idle_timeout", c.HTTP.IdleTimeout},
+		{"shutdown_timeout", c.HTTP.ShutdownTimeout},
+	} {
+		if d.v <= 0 {
+			bad("http.%s must be positive", d.key)
+		}
+	}
+	if c.HTTP.WriteTimeout > 0 && c.HTTP.WriteTimeout <= c.HTTP.RequestTimeout {
+		bad("http.write_timeout must be longer than http.request_timeout")
+	}
+	if c.HTTP.MaxHeaderBytes < 4096 {
+		bad("http.max_header_bytes must be at least 4096")
+	}
+	if (c.HTTP.TLSCertFile == "") != (c.HTTP.TLSKeyFile == "") {
+		bad("http.tls_cert_file and http.tls_key_file must be set together")
+	}
+	for _, p := range c.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			bad("http.trusted_proxies: %q is not an IP address or CIDR range", p)
+		}
+	}
+	if c.Database.Conn == "" {
+		bad("database.conn (DB_CONN) is required")
+	}
+
+	switch c.Tokens.SigningAlg {
+	case "HS256":
+		if c.Tokens.Secret == "" {
+			bad("tokens.secret (JWT_SECRET) is required with HS256")
+		}
+	case "RS256", "EdDSA":
+		if c.Tokens.KeyDir == "" {
+			bad("tokens.key_dir (JWT_KEY_DIR) is required with %s", c.Tokens.SigningAlg)
+		}
+	default:
+		bad("tokens.signing_alg must be HS256, RS256 or EdDSA, not %q", c.Tokens.SigningAlg)
+	}
+	if c.Tokens.TTL <= 0 {
+		bad("tokens.ttl must be positive")
+	}
+	if c.Tokens.RotateEvery < 0 {
+		bad("tokens.rotate_every must not be negative")
+	}
+	if c.Tokens.ReloadEvery <= 0 {
+		bad("tokens.reload_every must be positive")
+	}
+	if c.Tokens.PublishAhead < c.Tokens.ReloadEvery {
+		bad("tokens.publish_ahead must be at least tokens.reload_every")
+	}
+	if c.Tokens.RotateEvery > 0 && c.Tokens.PublishAhead >= c.Tokens.RotateEvery {
+		bad("tokens.publish_ahead must be shorter than tokens.rotate_every")
+	}
+
+	switch c.Cookies.SameSite {
+	case "lax", "strict":
+	case "none":
+		if !c.Cookies.Secure {
+			bad("cookies.same_site none requires cookies.secure")
+		}
+	default:
+		bad("cookies.same_site must be lax, strict or none, not %q", c.Cookies.SameSite)
+	}
+
+	p := c.Passwords
+	if p.Hash != "argon2id" && p.Hash != "bcrypt" {
+		bad("passwords.hash must be argon2id or bcrypt, not %q", p.Hash)
+	}
+	if p.Argon2Time < 1 {
+		bad("passwords.argon2_time must be at least 1")
+	}
+	if p.Argon2MemoryKiB < 8*1024 {
+		bad("passwords.argon2_memory_kib must be at least 8192")
+	}
+	if p.Argon2Threads < 1 {
+		bad("passwords.argon2_threads must be at least 1")
+	}
+	// The limits of golang.org/x/crypto/bcrypt.
+	if p.BcryptCost < 4 || p.BcryptCost > 31 {
+		bad("passwords.bcrypt_cost must be between 4 and 31")
+	}
+	if p.MinLength < 1 {
+		bad("passwords.min_length must be at least 1")
+	}
+	if p.MinScore < 0 || p.MinScore > 4 {
+		bad("passwords.min_score must be between 0 and 4")
+	}
+	if p.BreachDir != "" {
+		if fi, err := os.Stat(p.BreachDir); err != nil || !fi.IsDir() {
+			bad("passwords.breach_dir %q is not a directory", p.BreachDir)
+		}
+	}
+
+	if c.Login.MaxFailures < 1 {
+		bad("login.max_failures must be at least 1")
+	}
+	if c.Login.Lockout <= 0 {
+		bad("login.lockout must be positive")
+	}
+
+	for _, op := range c.OIDC.Providers {
+		if op.Issuer == "" || op.ClientID == "" {
+			bad("oidc.%s.issuer and oidc.%s.client_id are required", op.Name, op.Name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withDerived fills in the settings whose defaults depend on others.
+func (c *Config) withDerived() {
+	base := strings.TrimRight(c.HTTP.BaseURL, "/")
+	if c.Passwords.ResetURL == "" {
+		c.Passwords.ResetURL = base + "/password/reset"
+	}
+	if c.Workspaces.InviteURL == "" {
+		c.Workspaces.InviteURL = base + "/workspaces/invites/accept"
+	}
+	c.Cookies.SameSite = strings.ToLower(c.Cookies.SameSite)
+}
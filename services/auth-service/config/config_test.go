@@ -0,0 +1,228 @@
+package config
+
+import (
+	"auth-service/password"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func envOf(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+var minimalEnv = map[string]string{"DB_CONN": "sqlite::memory:", "JWT_SECRET": "s3cret"}
+
+func TestDefaults(t *testing.T) {
+	c, err := Load(nil, envOf(minimalEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HTTP.Addr != ":8080" || c.HTTP.RequestTimeout != 3*time.Second || c.Tokens.TTL != time.Hour ||
+		c.Tokens.SigningAlg != "HS256" || c.Cookies.SameSite != "lax" || c.Cookies.Secure {
+		t.Errorf("defaults = %+v", c)
+	}
+	if h := c.HTTP; h.ReadHeaderTimeout != 5*time.Second || h.WriteTimeout != 30*time.Second || h.IdleTimeout != 2*time.Minute ||
+		h.MaxHeaderBytes != 1<<20 || h.ShutdownTimeout != 30*time.Second || h.TLSCertFile != "" || len(h.TrustedProxies) != 0 {
+		t.Errorf("server defaults = %+v", h)
+	}
+	if c.Passwords.ResetURL != "http://localhost:8080/password/reset" ||
+		c.Workspaces.InviteURL != "http://localhost:8080/workspaces/invites/accept" {
+		t.Errorf("derived URLs = %q, %q", c.Passwords.ResetURL, c.Workspaces.InviteURL)
+	}
+
+	// The defaults repeat those of package password; keep them in step.
+	argon, policy := password.DefaultArgon2id(), password.DefaultPolicy()
+	p := c.Passwords
+	if p.Argon2Time != argon.Time || p.Argon2MemoryKiB != argon.MemoryKiB || p.Argon2Threads != argon.Threads ||
+		p.BcryptCost != bcrypt.DefaultCost || p.MinLength != policy.MinLength || p.MinScore != policy.MinScore {
+		t.Errorf("password defaults = %+v, want %+v, cost %d and %+v", p, argon, bcrypt.DefaultCost, policy)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.yaml")
+	os.WriteFile(path, []byte(`
+http:
+  addr: ":9000"
+  request_timeout: 5s
+tokens:
+  ttl: 30m
+cookies:
+  trusted_origins: [https://a.example, https://b.example]
+login:
+  max_failures: 4
+`), 0o600)
+
+	env := map[string]string{"CONFIG_FILE": path, "TOKEN_TTL": "2h", "LOGIN_MAX_FAILURES": ""}
+	for k, v := range minimalEnv {
+		env[k] = v
+	}
+	c, err := Load([]string{"-http-addr", ":9100"}, envOf(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HTTP.Addr != ":9100" {
+		t.Errorf("flag: addr = %q", c.HTTP.Addr)
+	}
+	if c.Tokens.TTL != 2*time.Hour {
+		t.Errorf("env: ttl = %v", c.Tokens.TTL)
+	}
+	if c.HTTP.RequestTimeout != 5*time.Second || c.Login.MaxFailures != 4 {
+		t.Errorf("file: timeout = %v, max failures = %d", c.HTTP.RequestTimeout, c.Login.MaxFailures)
+	}
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(c.Cookies.TrustedOrigins, want) {
+		t.Errorf("file list: %v", c.Cookies.TrustedOrigins)
+	}
+}
+
+func TestTOMLAndOIDC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.toml")
+	os.WriteFile(path, []byte(`
+[database]
+conn = "sqlite::memory:"
+
+[tokens]
+secret = "from-file"
+
+[oidc]
+providers = ["google", "corp"]
+
+[oidc.google]
+issuer = "https://accounts.google.com"
+client_id = "g-id"
+trust_email = true
+
+[oidc.unused]
+issuer = "https://example.com"
+`), 0o600)
+
+	env := map[string]string{"OIDC_CORP_ISSUER": "https://sso.corp.example", "OIDC_CORP_CLIENT_ID": "c-id", "OIDC_CORP_CLIENT_SECRET": "shh"}
+	c, err := Load([]string{"-config", path}, envOf(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OIDCProvider{
+		{Name: "google", Issuer: "https://accounts.google.com", ClientID: "g-id", TrustEmail: true},
+		{Name: "corp", Issuer: "https://sso.corp.example", ClientID: "c-id", ClientSecret: "shh"},
+	}
+	if !reflect.DeepEqual(c.OIDC.Providers, want) || c.Tokens.Secret != "from-file" {
+		t.Errorf("providers = %+v", c.OIDC.Providers)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		args []string
+		want []string
+	}{
+		{"nothing set", nil, "", nil, []string{"DB_CONN", "JWT_SECRET"}},
+		{"bad values", map[string]string{"REQUEST_TIMEOUT": "soon", "COOKIE_SECURE": "maybe", "LOGIN_MAX_FAILURES": "ten"}, "", nil,
+			[]string{"http.request_timeout: invalid duration", "cookies.secure: invalid boolean", "login.max_failures: invalid number"}},
+		{"out of range", map[string]string{"PASSWORD_MIN_SCORE": "5", "BCRYPT_COST": "3", "TOKEN_TTL": "0s", "JWT_SIGNING_ALG": "none"}, "", nil,
+			[]string{"min_score", "bcrypt_cost", "tokens.ttl", "signing_alg"}},
+		{"server limits", map[string]string{"HTTP_IDLE_TIMEOUT": "0s", "HTTP_WRITE_TIMEOUT": "2s", "HTTP_MAX_HEADER_BYTES": "100", "TLS_CERT_FILE": "tls.crt"}, "", nil,
+			[]string{"http.idle_timeout", "longer than http.request_timeout", "max_header_bytes", "set together"}},
+		{"trusted proxy by name", map[string]string{"HTTP_TRUSTED_PROXIES": "10.0.0.0/8,lb.internal"}, "", nil,
+			[]string{`"lb.internal" is not an IP address`}},
+		{"key timing", map[string]string{"JWT_RELOAD_EVERY": "10m", "JWT_PUBLISH_AHEAD": "5m"}, "", nil,
+			[]string{"publish_ahead must be at least tokens.reload_every"}},
+		{"SameSite none needs Secure", map[string]string{"COOKIE_SAMESITE": "None"}, "", nil, []string{"requires cookies.secure"}},
+		{"typo in the file", nil, "http:\n  adr: \":1\"\n", nil, []string{"unknown setting http.adr"}},
+		{"provider without issuer", map[string]string{"OIDC_PROVIDERS": "corp"}, "", nil, []string{"oidc.corp.issuer"}},
+		{"unknown flag", nil, "", []string{"-port", "1"}, []string{"flag provided but not defined"}},
+		{"stray argument", nil, "", []string{"serve"}, []string{"unexpected argument"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			if tt.name != "nothing set" {
+				for k, v := range minimalEnv {
+					env[k] = v
+				}
+			}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "auth.yaml")
+				os.WriteFile(path, []byte(tt.file), 0o600)
+				env["CONFIG_FILE"] = path
+			}
+			_, err := Load(tt.args, envOf(env))
+			if err == nil {
+				t.Fatal("loaded without error")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q does not mention %q", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDatabase(t *testing.T) {
+	// Only the database is needed: a missing JWT_SECRET is not an error.
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	if err := os.WriteFile(path, []byte("database:\n  conn: \"sqlite:file.db\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, rest, err := LoadDatabase([]string{"-config", path, "down", "2"}, envOf(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Conn != "sqlite:file.db" || len(rest) != 2 || rest[0] != "down" || rest[1] != "2" {
+		t.Errorf("conn = %q, rest = %q", db.Conn, rest)
+	}
+
+	db, _, err = LoadDatabase([]string{"-database-conn", "sqlite::memory:", "up"}, envOf(map[string]string{"CONFIG_FILE": path, "DB_CONN": "sqlite:env.db"}))
+	if err != nil || db.Conn != "sqlite::memory:" {
+		t.Errorf("conn = %+v, %v; want the flag over the environment and file", db, err)
+	}
+
+	if _, _, err := LoadDatabase([]string{"up"}, envOf(nil)); err == nil || !strings.Contains(err.Error(), "DB_CONN") {
+		t.Errorf("err = %v, want DB_CONN required", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	env := map[string]string{"MAIL_SMTP_PASSWORD": "hunter2", "OIDC_PROVIDERS": "corp",
+		"OIDC_CORP_ISSUER": "https://sso.corp.example", "OIDC_CORP_CLIENT_ID": "c-id", "OIDC_CORP_CLIENT_SECRET": "shh"}
+	for k, v := range minimalEnv {
+		env[k] = v
+	}
+	c, err := Load(nil, envOf(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	c.Print(&buf)
+	out := buf.String()
+	for _, secret := range []string{"s3cret", "sqlite::memory:", "hunter2", "shh"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("output contains %q:\n%s", secret, out)
+		}
+	}
+	for _, line := range []string{
+		`http.addr = ":8080"`, "tokens.ttl = 1h0m0s", "tokens.secret = (redacted)",
+		"cookies.csrf_secret = (not set)", "oidc.corp.client_secret = (redacted)", `oidc.corp.client_id = "c-id"`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output lacks %q:\n%s", line, out)
+		}
+	}
+}
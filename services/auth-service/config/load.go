@@ -0,0 +1,327 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from, in increasing order of precedence:
+// the defaults, the file named by -config or CONFIG_FILE (YAML, or TOML
+// if it ends in .toml), environment variables and flags. args are the
+// command-line arguments without the program name; lookupEnv is normally
+// os.LookupEnv. Empty environment variables count as unset.
+//
+// A setting's file key is its section and name, as in http.addr, and its
+// flag is the same with a dash, as in -http-addr. Lists are
+// comma-separated outside the file. The result has been validated.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c, rest, err := load(args, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", rest[0])
+	}
+	c.withDerived()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadDatabase is Load for commands that need only the database, such as
+// migrate. It reads the same sources but checks only the database
+// settings, and returns the arguments that follow the flags.
+func LoadDatabase(args []string, lookupEnv func(string) (string, bool)) (*Database, []string, error) {
+	c, rest, err := load(args, lookupEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.Database.Conn == "" {
+		return nil, nil, errors.New("database.conn (DB_CONN) is required")
+	}
+	return &c.Database, rest, nil
+}
+
+// load sets every setting from its sources, without validating the
+// result, and returns the arguments that follow the flags.
+func load(args []string, lookupEnv func(string) (string, bool)) (*Config, []string, error) {
+	c := &Config{}
+	fields := walk(reflect.ValueOf(c).Elem(), "", "")
+
+	fs := flag.NewFlagSet("auth-service", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML or TOML configuration file (env CONFIG_FILE)")
+	flagValues := map[string]*string{}
+	for _, f := range fields {
+		flagValues[f.key] = fs.String(flagName(f.key), f.def, f.help+" (env "+f.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	env := func(name string) (string, bool) {
+		v, ok := lookupEnv(name)
+		return v, ok && v != ""
+	}
+	path := *configFile
+	if path == "" {
+		path, _ = env("CONFIG_FILE")
+	}
+	file := map[string]string{}
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	used := map[string]bool{}
+	lookup := func(f field) (string, bool) {
+		if f.flag && setFlags[flagName(f.key)] {
+			return *flagValues[f.key], true
+		}
+		if v, ok := env(f.env); ok {
+			return v, true
+		}
+		if v, ok := file[f.key]; ok {
+			used[f.key] = true
+			return v, true
+		}
+		return f.def, f.def != ""
+	}
+	var errs []error
+	set := func(fields []field) {
+		for _, f := range fields {
+			if v, ok := lookup(f); ok {
+				if err := f.set(v); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", f.key, err))
+				}
+			}
+		}
+	}
+	set(fields)
+
+	for _, name := range c.OIDC.Names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		op := OIDCProvider{Name: name}
+		pf := walk(reflect.ValueOf(&op).Elem(), "oidc."+name+".", "OIDC_"+strings.ToUpper(name)+"_")
+		for i := range pf {
+			pf[i].flag = false
+		}
+		set(pf)
+		c.OIDC.Providers = append(c.OIDC.Providers, op)
+	}
+	for key := range file {
+		if !used[key] && !isKnown(fields, key) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %s", path, key))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// field is one setting, found by walking the Config struct.
+type field struct {
+	key, env, def, help string
+	secret              bool
+	// flag is false for settings that have no command-line flag.
+	flag bool
+	v    reflect.Value
+}
+
+// walk lists the tagged fields of the struct v and of the sections in it.
+func walk(v reflect.Value, keyPrefix, envPrefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, ok := sf.Tag.Lookup("key")
+		if !ok {
+			continue
+		}
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			fields = append(fields, walk(v.Field(i), keyPrefix+key+".", envPrefix)...)
+			continue
+		}
+		fields = append(fields, field{
+			key:    keyPrefix + key,
+			env:    envPrefix + sf.Tag.Get("env"),
+			def:    sf.Tag.Get("default"),
+			help:   sf.Tag.Get("help"),
+			secret: sf.Tag.Get("secret") == "true",
+			flag:   true,
+			v:      v.Field(i),
+		})
+	}
+	return fields
+}
+
+func flagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// isKnown reports whether key names a setting, including those of OIDC
+// providers that were not enabled.
+func isKnown(fields []field, key string) bool {
+	for _, f := range fields {
+		if f.key == key {
+			return true
+		}
+	}
+	parts := strings.Split(key, ".")
+	if len(parts) != 3 || parts[0] != "oidc" {
+		return false
+	}
+	for _, f := range walk(reflect.ValueOf(&OIDCProvider{}).Elem(), "", "") {
+		if f.key == parts[2] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f field) set(s string) error {
+	s = strings.TrimSpace(s)
+	switch p := f.v.Addr().Interface().(type) {
+	case *string:
+		*p = s
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		*p = b
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*p = n
+	case *uint8:
+		n, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*p = uint8(n)
+	case *uint32:
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*p = uint32(n)
+	case *time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*p = d
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	default:
+		panic("config: unsupported type " + f.v.Type().String())
+	}
+	return nil
+}
+
+// readFile reads a YAML or TOML file into a map from dotted keys to values
+// in their environment variable form.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(b, &doc)
+	} else {
+		err = yaml.Unmarshal(b, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	out := map[string]string{}
+	if err := flatten(out, "", doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+func flatten(out map[string]string, prefix string, v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if err := flatten(out, prefix+k+".", child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s := fmt.Sprint(item)
+			if strings.Contains(s, ",") {
+				return fmt.Errorf("%s: list items must not contain commas", strings.TrimSuffix(prefix, "."))
+			}
+			items[i] = s
+		}
+		out[strings.TrimSuffix(prefix, ".")] = strings.Join(items, ",")
+		return nil
+	case nil:
+		return nil
+	default:
+		out[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(v)
+		return nil
+	}
+}
+
+// Print writes every setting, one per line, with secrets redacted.
+func (c *Config) Print(w io.Writer) {
+	fields := walk(reflect.ValueOf(c).Elem(), "", "")
+	for i := range c.OIDC.Providers {
+		op := &c.OIDC.Providers[i]
+		fields = append(fields, walk(reflect.ValueOf(op).Elem(), "oidc."+op.Name+".", "")...)
+	}
+	for _, f := range fields {
+		fmt.Fprintf(w, "%s = %s\n", f.key, f.display())
+	}
+}
+
+func (f field) display() string {
+	if f.secret {
+		if f.v.IsZero() {
+			return "(not set)"
+		}
+		return "(redacted)"
+	}
+	switch v := f.v.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"auth-service/mailer"
+	"auth-service/models"
+	"auth-service/store"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accountEnv serves /register, /login and /me on an in-memory store. /me
+// trusts an X-Test-User header in place of the Auth middleware.
+type accountEnv struct {
+	mem      *store.Memory
+	mail     mailbox
+	sessions Sessions
+	router   *gin.Engine
+}
+
+func newAccountEnv(t *testing.T) *accountEnv {
+	t.Helper()
+	e := &accountEnv{mem: store.NewMemory(), mail: make(mailbox, 10), sessions: testSessions(t)}
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(3, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification := NewEmailVerification(nil, e.mem, e.sessions, &mailer.Async{Mailer: e.mail}, "http://auth.test", nil, passwords)
+
+	e.router = gin.New()
+	e.router.POST("/register", NewHandler(e.mem, verification, passwords))
+	e.router.POST("/login", AuthHandler(e.mem, e.sessions, guard, passwords))
+	e.router.GET("/me", func(c *gin.Context) {
+		if v := c.GetHeader("X-Test-User"); v != "" {
+			uid, _ := strconv.ParseInt(v, 10, 64)
+			c.Set("userID", uid)
+		}
+	}, MeHandler(e.mem))
+	return e
+}
+
+// user registers email with strongPassword and returns the new user.
+func (e *accountEnv) user(t *testing.T, email string) models.User {
+	t.Helper()
+	hash, err := testPasswords().hash(strongPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := e.mem.CreateUser(context.Background(), email, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  any
+		code  int
+		error string
+	}{
+		{"malformed JSON", `{"email":`, http.StatusBadRequest, "invalid JSON payload"},
+		{"no email", Request{Password: strongPassword}, http.StatusBadRequest, "email is required"},
+		{"blank email", Request{Email: "   ", Password: strongPassword}, http.StatusBadRequest, "email is required"},
+		{"no password", Request{Email: "a@example.com"}, http.StatusBadRequest, "password is required"},
+		{"short password", Request{Email: "a@example.com", Password: "abc"}, http.StatusBadRequest, "password must be at least 8 characters"},
+		{"taken email, any case", Request{Email: " Taken@Example.com", Password: strongPassword}, http.StatusConflict, "email already exists"},
+		{"ok", Request{Email: "a@example.com", Password: strongPassword}, http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAccountEnv(t)
+			e.user(t, "taken@example.com")
+			w := serve(e.router, "POST", "/register", tt.body, nil)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if tt.error != "" {
+				if got := decode[map[string]any](t, w)["error"]; got != tt.error {
+					t.Errorf("error = %q, want %q", got, tt.error)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterCreatesUser(t *testing.T) {
+	e := newAccountEnv(t)
+	w := serve(e.router, "POST", "/register", Request{Email: "  New@Example.COM ", Password: strongPassword}, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	got := decode[struct {
+		ID            int64
+		Email         string
+		EmailVerified bool `json:"email_verified"`
+	}](t, w)
+	if got.ID == 0 || got.Email != "new@example.com" || got.EmailVerified {
+		t.Errorf("response = %+v", got)
+	}
+	_, hash, _, err := e.mem.UserForLogin(context.Background(), "new@example.com")
+	if err != nil || hash == "" || hash == strongPassword {
+		t.Errorf("stored hash %q, %v", hash, err)
+	}
+
+	select {
+	case m := <-e.mail:
+		if m.To != "new@example.com" || !strings.Contains(m.Text, "http://auth.test/verify?token=") {
+			t.Errorf("verification email = %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no verification email was sent")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(*accountEnv, models.User)
+		body   any
+		code   int
+		reason string // recorded in login_attempts, if any
+	}{
+		{"malformed JSON", nil, `not json`, http.StatusBadRequest, ""},
+		{"no email", nil, Login{Password: strongPassword}, http.StatusBadRequest, ""},
+		{"no password", nil, Login{Email: "ana@example.com"}, http.StatusBadRequest, ""},
+		{"unknown email", nil, Login{Email: "bob@example.com", Password: strongPassword}, http.StatusUnauthorized, models.LoginReasonUnknownEmail},
+		{"wrong password", nil, Login{Email: "ana@example.com", Password: strongPassword + "!"}, http.StatusUnauthorized, models.LoginReasonBadPassword},
+		{"email in another case", nil, Login{Email: " ANA@example.com", Password: strongPassword, ReturnToken: true}, http.StatusOK, loginPassword},
+		{"disabled account", func(e *accountEnv, u models.User) { e.mem.DisableUser(u.ID) },
+			Login{Email: "ana@example.com", Password: strongPassword}, http.StatusForbidden, ""},
+		{"locked account", func(e *accountEnv, u models.User) {
+			e.mem.RegisterLoginFailure(context.Background(), u.ID, 1, time.Hour)
+		}, Login{Email: "ana@example.com", Password: strongPassword}, http.StatusTooManyRequests, models.LoginReasonLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAccountEnv(t)
+			u := e.user(t, "ana@example.com")
+			if tt.setup != nil {
+				tt.setup(e, u)
+			}
+			w := serve(e.router, "POST", "/login", tt.body, nil)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			attempts := e.mem.LoginAttempts()
+			if tt.reason == "" && len(attempts) != 0 {
+				t.Errorf("recorded %+v", attempts)
+			}
+			if tt.reason != "" && (len(attempts) != 1 || attempts[0].Reason != tt.reason || attempts[0].Success != (tt.code == http.StatusOK)) {
+				t.Errorf("recorded %+v, want reason %s", attempts, tt.reason)
+			}
+		})
+	}
+}
+
+func TestLoginIssuesSession(t *testing.T) {
+	e := newAccountEnv(t)
+	u := e.user(t, "ana@example.com")
+
+	w := serve(e.router, "POST", "/login", Login{Email: "ana@example.com", Password: strongPassword, ReturnToken: true}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	body := decode[struct {
+		ID        int64
+		Token     string
+		TokenType string `json:"token_type"`
+		ExpiresIn int    `json:"expires_in"`
+	}](t, w)
+	if body.ID != u.ID || body.TokenType != "Bearer" || body.ExpiresIn != 3600 {
+		t.Errorf("response = %+v", body)
+	}
+	if tok, err := e.sessions.Keys.Parse(body.Token); err != nil || !tok.Valid {
+		t.Errorf("token does not verify: %v", err)
+	}
+
+	w = serve(e.router, "POST", "/login", Login{Email: "ana@example.com", Password: strongPassword}, nil)
+	cookies := map[string]*http.Cookie{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	if w.Code != http.StatusOK || cookies["auth_token"] == nil || !cookies["auth_token"].HttpOnly {
+		t.Errorf("cookie login: %d, cookies %v", w.Code, w.Result().Cookies())
+	}
+	if csrf := decode[map[string]any](t, w)["csrf_token"]; csrf == "" || csrf == nil {
+		t.Error("cookie login returned no CSRF token")
+	}
+}
+
+func TestLoginWithTOTPWithholdsSession(t *testing.T) {
+	e := newAccountEnv(t)
+	u := e.user(t, "ana@example.com")
+	e.mem.SetTOTPEnabled(u.ID, true)
+
+	w := serve(e.router, "POST", "/login", Login{Email: "ana@example.com", Password: strongPassword, ReturnToken: true}, nil)
+	got := decode[map[string]any](t, w)
+	if w.Code != http.StatusOK || got["mfa_required"] != true || got["token"] != nil || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	pre, err := e.sessions.parsePreAuth(got["pre_auth_token"].(string))
+	if err != nil || pre.UserID != u.ID {
+		t.Errorf("pre-auth token = %+v, %v", pre, err)
+	}
+}
+
+func TestLoginLocksAfterRepeatedFailures(t *testing.T) {
+	e := newAccountEnv(t)
+	e.user(t, "ana@example.com")
+	wrong := Login{Email: "ana@example.com", Password: "wrong password"}
+
+	// The guard lets the first three failures through, and the third
+	// reaches MaxFailures and locks the account.
+	for i := 1; i <= 3; i++ {
+		if w := serve(e.router, "POST", "/login", wrong, nil); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: %d", i, w.Code)
+		}
+	}
+	if got := e.mem.LoginAttempts(); got[2].Reason != models.LoginReasonLocked {
+		t.Errorf("third failure recorded as %s", got[2].Reason)
+	}
+	w := serve(e.router, "POST", "/login", Login{Email: "ana@example.com", Password: strongPassword}, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("right password while locked: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestLockedAccountLooksLikeBackoff(t *testing.T) {
+	e := newAccountEnv(t)
+	u := e.user(t, "ana@example.com")
+	e.mem.RegisterLoginFailure(context.Background(), u.ID, 1, time.Hour)
+
+	// After four failures the per-email backoff holds an unknown email
+	// back for a second; a locked account must answer the same way rather
+	// than with the hour its lockout has left.
+	unknown := Login{Email: "bob@example.com", Password: "wrong password"}
+	for i := 1; i <= 4; i++ {
+		serve(e.router, "POST", "/login", unknown, nil)
+	}
+	throttled := serve(e.router, "POST", "/login", unknown, nil)
+	if throttled.Code != http.StatusTooManyRequests {
+		t.Fatalf("unknown email was not throttled: %d", throttled.Code)
+	}
+
+	locked := serve(e.router, "POST", "/login", Login{Email: "ana@example.com", Password: strongPassword}, nil)
+	if locked.Code != throttled.Code || locked.Body.String() != throttled.Body.String() ||
+		locked.Header().Get("Retry-After") != throttled.Header().Get("Retry-After") {
+		t.Errorf("locked: %d %s Retry-After %q; throttled: %d %s Retry-After %q",
+			locked.Code, locked.Body, locked.Header().Get("Retry-After"),
+			throttled.Code, throttled.Body, throttled.Header().Get("Retry-After"))
+	}
+}
+
+func TestMe(t *testing.T) {
+	e := newAccountEnv(t)
+	u := e.user(t, "ana@example.com")
+
+	tests := []struct {
+		name string
+		user string
+		code int
+	}{
+		{"not signed in", "", http.StatusUnauthorized},
+		{"user since deleted", strconv.FormatInt(u.ID+1, 10), http.StatusUnauthorized},
+		{"signed in", strconv.FormatInt(u.ID, 10), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h http.Header
+			if tt.user != "" {
+				h = http.Header{"X-Test-User": {tt.user}}
+			}
+			w := serve(e.router, "GET", "/me", nil, h)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+		})
+	}
+
+	w := serve(e.router, "GET", "/me", nil, http.Header{"X-Test-User": {strconv.FormatInt(u.ID, 10)}})
+	got := decode[map[string]any](t, w)
+	want := map[string]any{
+		"id": float64(u.ID), "email": "ana@example.com", "email_verified": false,
+		"base_currency": "USD", "timezone": "UTC", "week_start": "monday",
+		"display_name": nil, "pending_email": nil,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
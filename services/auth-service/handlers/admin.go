@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	adminPageSize    = 50
+	adminMaxPageSize = 200
+)
+
+// Admin is the support API under /admin. Every route must sit behind
+// middleware.RequireRole(models.RoleAdmin).
+type Admin struct {
+	db          *sqldb.DB
+	revocations *models.RevocationStore
+	audit       *models.AuditLog
+}
+
+func NewAdmin(db *sqldb.DB, revocations *models.RevocationStore, auditLog *models.AuditLog) *Admin {
+	return &Admin{db: db, revocations: revocations, audit: auditLog}
+}
+
+// page reads limit and offset query parameters, writing 400 if they are
+// invalid.
+func page(c *gin.Context) (limit, offset int, ok bool) {
+	limit, offset = adminPageSize, 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > adminMaxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(adminMaxPageSize)})
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// targetUser parses the :id parameter. Administrators may not disable,
+// demote or log out themselves through the API, which is how the last
+// admin would lock everyone out.
+func targetUser(c *gin.Context, allowSelf bool) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, false
+	}
+	if !allowSelf && id == c.GetInt64("userID") {
+		c.JSON(http.StatusConflict, gin.H{"error": "administrators cannot do this to their own account"})
+		return 0, false
+	}
+	return id, true
+}
+
+// ListUsers: GET /admin/users?email=&role=&disabled=&limit=&offset=
+func (a *Admin) ListUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, offset, ok := page(c)
+		if !ok {
+			return
+		}
+		f := models.UserFilter{Email: c.Query("email"), Role: c.Query("role"), Limit: limit, Offset: offset}
+		if f.Role != "" && !slices.Contains(models.ValidRoles, f.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role " + f.Role})
+			return
+		}
+		if v := c.Query("disabled"); v != "" {
+			d, err := strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "disabled must be true or false"})
+				return
+			}
+			f.Disabled = &d
+		}
+
+		ctx := c.Request.Context()
+
+		users, err := models.ListUsers(ctx, a.db, f)
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load users"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"users": users, "limit": limit, "offset": offset})
+	}
+}
+
+func (a *Admin) GetUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := targetUser(c, true)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		u, err := models.GetAdminUser(ctx, a.db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.JSON(http.StatusOK, u)
+	}
+}
+
+// SetDisabled returns the handler for POST /admin/users/:id/disable (true)
+// or /enable (false). Disabling also ends every session the user has.
+func (a *Admin) SetDisabled(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := targetUser(c, !disabled)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		err := models.SetUserDisabled(ctx, a.db, id, disabled)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if disabled {
+			if _, err := a.revocations.InvalidateUser(ctx, id); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+				return
+			}
+		}
+		action := models.AuditUserEnable
+		if disabled {
+			action = models.AuditUserDisable
+		}
+		audit(c, a.audit, models.AuditEntry{
+			UserID:     idRef(id),
+			Action:     action,
+			EntityType: models.AuditEntityUser,
+			EntityID:   auditID(id),
+			After:      gin.H{"disabled": disabled},
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// SetRole: PUT /admin/users/:id/role. The user's tokens are invalidated so
+// the new role applies from their next login rather than their next token.
+func (a *Admin) SetRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := targetUser(c, false)
+		if !ok {
+			return
+		}
+		var req SetRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		if !slices.Contains(models.ValidRoles, req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role " + req.Role})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		before, err := models.GetAdminUser(ctx, a.db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		err = models.SetUserRole(ctx, a.db, id, req.Role)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if _, err := a.revocations.InvalidateUser(ctx, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, a.audit, models.AuditEntry{
+			UserID:     idRef(id),
+			Action:     models.AuditUserRoleChange,
+			EntityType: models.AuditEntityUser,
+			EntityID:   auditID(id),
+			Before:     gin.H{"role": before.Role},
+			After:      gin.H{"role": req.Role},
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Logout ends every session of the user: POST /admin/users/:id/logout.
+// API keys are unaffected.
+func (a *Admin) Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := targetUser(c, false)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		_, err := a.revocations.InvalidateUser(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, a.audit, models.AuditEntry{
+			UserID:     idRef(id),
+			Action:     models.AuditUserForceLogout,
+			EntityType: models.AuditEntityUser,
+			EntityID:   auditID(id),
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ListImports shows CSV import runs: GET /admin/imports?user_id=&status=
+func (a *Admin) ListImports() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, offset, ok := page(c)
+		if !ok {
+			return
+		}
+		f := models.ImportRunFilter{Status: c.Query("status"), Limit: limit, Offset: offset}
+		switch f.Status {
+		case "", models.ImportRunning, models.ImportSucceeded, models.ImportFailed:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status " + f.Status})
+			return
+		}
+		if v := c.Query("user_id"); v != "" {
+			uid, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+				return
+			}
+			f.UserID = uid
+		}
+
+		ctx := c.Request.Context()
+
+		runs, err := models.ListImportRuns(ctx, a.db, f)
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load imports"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"imports": runs, "limit": limit, "offset": offset})
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"auth-service/store"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func queryContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	return c
+}
+
+func TestParseDateParam(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    time.Time
+		has     bool
+		wantErr bool
+	}{
+		{"", time.Time{}, false, false},
+		{"d=", time.Time{}, false, false},
+		{"d=2025-02-28", time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC), true, false},
+		{"d=2024-02-29", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), true, false},
+		{"d=2025-02-29", time.Time{}, true, true},
+		{"d=2025-2-1", time.Time{}, true, true},
+		{"d=01/02/2025", time.Time{}, true, true},
+		{"d=2025-02-01T00:00:00Z", time.Time{}, true, true},
+	}
+	for _, tt := range tests {
+		got, has, err := parseDateParam(queryContext(tt.query), "d")
+		if has != tt.has || (err != nil) != tt.wantErr || (!tt.wantErr && !got.Equal(tt.want)) {
+			t.Errorf("%q: got %v, %v, %v", tt.query, got, has, err)
+		}
+	}
+}
+
+func TestParseYearParam(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		has     bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"year=2025", 2025, true, false},
+		{"year=1970", 1970, true, false},
+		{"year=9999", 9999, true, false},
+		{"year=1969", 0, false, true},
+		{"year=10000", 0, false, true},
+		{"year=-2025", 0, false, true},
+		{"year=20x5", 0, false, true},
+		{"year=2025.0", 0, false, true},
+	}
+	for _, tt := range tests {
+		got, has, err := parseYearParam(queryContext(tt.query), "year")
+		if has != tt.has || (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("%q: got %d, %v, %v", tt.query, got, has, err)
+		}
+	}
+}
+
+func TestParseMonthParam(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    time.Time
+		has     bool
+		wantErr bool
+	}{
+		{"", time.Time{}, false, false},
+		{"month=2025-04", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), true, false},
+		{"month=2025-12", time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC), true, false},
+		{"month=2025-13", time.Time{}, false, true},
+		{"month=2025-4", time.Time{}, false, true},
+		{"month=2025-04-01", time.Time{}, false, true},
+	}
+	for _, tt := range tests {
+		got, has, err := parseMonthParam(queryContext(tt.query), "month")
+		if has != tt.has || (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("%q: got %v, %v, %v", tt.query, got, has, err)
+		}
+	}
+}
+
+func TestYTDRange(t *testing.T) {
+	before := time.Now().UTC()
+	from, to := ytdRange()
+	after := time.Now().UTC()
+	if want := time.Date(to.Year(), time.January, 1, 0, 0, 0, 0, time.UTC); !from.Equal(want) {
+		t.Errorf("from = %v, want %v", from, want)
+	}
+	if to.Before(before) || to.After(after) || to.Location() != time.UTC {
+		t.Errorf("to = %v, want now in UTC", to)
+	}
+}
+
+// analyticsRouter serves the analytics handlers on mem. Requests name
+// their workspace in X-Test-Workspace; without it the handlers see an
+// unauthenticated request.
+func analyticsRouter(mem *store.Memory) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if v := c.GetHeader("X-Test-Workspace"); v != "" {
+			wid, _ := strconv.ParseInt(v, 10, 64)
+			c.Set("workspaceID", wid)
+		}
+	})
+	r.GET("/analytics/summary", AnalyticsSummary(mem))
+	r.GET("/analytics/cashflow", AnalyticsCashflow(mem))
+	r.GET("/analytics/budget", AnalyticsBudgets(mem))
+	return r
+}
+
+var inWorkspace = http.Header{"X-Test-Workspace": {"1"}}
+
+func TestAnalyticsRequireWorkspace(t *testing.T) {
+	r := analyticsRouter(store.NewMemory())
+	for _, path := range []string{"/analytics/summary", "/analytics/cashflow", "/analytics/budget"} {
+		if w := serve(r, "GET", path, nil, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a workspace: %d, want 401", path, w.Code)
+		}
+	}
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestAnalyticsSummary(t *testing.T) {
+	mem := store.NewMemory()
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	jan1 := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	for _, tx := range []store.Transaction{
+		{WorkspaceID: 1, Date: jan1, Amount: 100, Category: "Salary"},
+		{WorkspaceID: 1, Date: today, Amount: -30, Category: "Groceries"},
+		{WorkspaceID: 1, Date: jan1.AddDate(0, 0, -1), Amount: -1000, Category: "Rent"},
+		{WorkspaceID: 1, Date: date("2020-03-10"), Amount: 500, Category: "Salary"},
+		{WorkspaceID: 1, Date: date("2020-03-11"), Amount: -20, Category: ""},
+		{WorkspaceID: 1, Date: date("2020-03-12"), Amount: -5, Category: "Coffee"},
+		{WorkspaceID: 2, Date: date("2020-03-10"), Amount: 999, Category: "Salary"},
+	} {
+		mem.AddTransaction(tx)
+	}
+	r := analyticsRouter(mem)
+	ymd := func(t time.Time) string { return t.Format("2006-01-02") }
+
+	tests := []struct {
+		name     string
+		query    string
+		code     int
+		from, to string
+		totals   store.Totals
+	}{
+		{"year to date by default", "", http.StatusOK, ymd(jan1), ymd(today), store.Totals{Income: 100, Expenses: -30, Net: 70}},
+		{"from alone runs to today", "?from=" + ymd(jan1.AddDate(0, 0, -1)), http.StatusOK, ymd(jan1.AddDate(0, 0, -1)), ymd(today), store.Totals{Income: 100, Expenses: -1030, Net: -930}},
+		{"to alone starts the year of to", "?to=2020-03-11", http.StatusOK, "2020-01-01", "2020-03-11", store.Totals{Income: 500, Expenses: -20, Net: 480}},
+		{"both, to inclusive", "?from=2020-03-11&to=2020-03-12", http.StatusOK, "2020-03-11", "2020-03-12", store.Totals{Expenses: -25, Net: -25}},
+		{"single day", "?from=2020-03-12&to=2020-03-12", http.StatusOK, "2020-03-12", "2020-03-12", store.Totals{Expenses: -5, Net: -5}},
+		{"empty period", "?from=2019-01-01&to=2019-12-31", http.StatusOK, "2019-01-01", "2019-12-31", store.Totals{}},
+		{"from after to", "?from=2020-03-12&to=2020-03-11", http.StatusBadRequest, "", "", store.Totals{}},
+		{"bad from", "?from=2020-3-1", http.StatusBadRequest, "", "", store.Totals{}},
+		{"bad to", "?to=yesterday", http.StatusBadRequest, "", "", store.Totals{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, "GET", "/analytics/summary"+tt.query, nil, inWorkspace)
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			got := decode[SummaryResponse](t, w)
+			if got.Period.From != tt.from || got.Period.To != tt.to || got.Totals != tt.totals {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+
+	w := serve(r, "GET", "/analytics/summary?from=2020-03-01&to=2020-03-31", nil, inWorkspace)
+	got := decode[SummaryResponse](t, w)
+	want := []store.CategoryTotal{{Category: "Salary", Amount: 500}, {Category: "Coffee", Amount: -5}, {Category: "Uncategorized", Amount: -20}}
+	if len(got.ByCategory) != len(want) {
+		t.Fatalf("by_category = %+v, want %+v", got.ByCategory, want)
+	}
+	for i := range want {
+		if got.ByCategory[i] != want[i] {
+			t.Errorf("by_category[%d] = %+v, want %+v", i, got.ByCategory[i], want[i])
+		}
+	}
+}
+
+func TestAnalyticsCashflow(t *testing.T) {
+	mem := store.NewMemory()
+	thisYear := time.Now().UTC().Year()
+	for _, tx := range []store.Transaction{
+		{WorkspaceID: 1, Date: date("2024-01-15"), Amount: 1000},
+		{WorkspaceID: 1, Date: date("2024-01-20"), Amount: -300},
+		{WorkspaceID: 1, Date: date("2024-03-31"), Amount: -50},
+		{WorkspaceID: 1, Date: date("2024-12-31"), Amount: 20},
+		{WorkspaceID: 1, Date: date("2025-01-01"), Amount: -999},
+		{WorkspaceID: 1, Date: time.Date(thisYear, time.February, 1, 0, 0, 0, 0, time.UTC), Amount: 7},
+	} {
+		mem.AddTransaction(tx)
+	}
+	r := analyticsRouter(mem)
+
+	w := serve(r, "GET", "/analytics/cashflow?year=2024", nil, inWorkspace)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	got := decode[CashflowResponse](t, w)
+	if got.Year != 2024 || len(got.Months) != 12 {
+		t.Fatalf("got %+v", got)
+	}
+	want := map[int]CashflowMonth{
+		0:  {"2024-01", 1000, -300, 700},
+		2:  {"2024-03", 0, -50, -50},
+		11: {"2024-12", 20, 0, 20},
+	}
+	for i, m := range got.Months {
+		w, ok := want[i]
+		if !ok {
+			w = CashflowMonth{Month: "2024-" + twoDigits(i+1)}
+		}
+		if m != w {
+			t.Errorf("months[%d] = %+v, want %+v", i, m, w)
+		}
+	}
+
+	w = serve(r, "GET", "/analytics/cashflow", nil, inWorkspace)
+	if got := decode[CashflowResponse](t, w); got.Year != thisYear || got.Months[1].Income != 7 {
+		t.Errorf("default year: %+v", got)
+	}
+
+	for _, q := range []string{"year=1969", "year=10000", "year=abc", "year=24"} {
+		if w := serve(r, "GET", "/analytics/cashflow?"+q, nil, inWorkspace); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", q, w.Code)
+		}
+	}
+}
+
+func twoDigits(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+func TestAnalyticsBudgets(t *testing.T) {
+	mem := store.NewMemory()
+	mem.SetBudget(1, "Groceries", date("2025-01-01"), 300)
+	mem.SetBudget(1, "Groceries", date("2025-03-01"), 250)
+	mem.SetBudget(1, "Coffee", date("2025-02-01"), 40)
+	mem.SetBudget(1, "Rent", date("2025-06-01"), 1200)
+	mem.SetBudget(2, "Coffee", date("2025-01-01"), 9999)
+	mem.AddAlias("Cafe", "Coffee")
+	for _, tx := range []store.Transaction{
+		{WorkspaceID: 1, Date: date("2025-04-02"), Amount: -280, Category: "groceries"},
+		{WorkspaceID: 1, Date: date("2025-04-03"), Amount: -15, Category: "Cafe"},
+		{WorkspaceID: 1, Date: date("2025-04-04"), Amount: -10, Category: "Coffee"},
+		{WorkspaceID: 1, Date: date("2025-04-05"), Amount: -12, Category: "Pet food"},
+		{WorkspaceID: 1, Date: date("2025-04-06"), Amount: 3000, Category: "Salary"},
+		{WorkspaceID: 1, Date: date("2025-05-01"), Amount: -70, Category: "Groceries"},
+	} {
+		mem.AddTransaction(tx)
+	}
+	r := analyticsRouter(mem)
+
+	tests := []struct {
+		name  string
+		month string
+		items []BudgetItem
+	}{
+		{"limits carry forward to later months", "2025-04", []BudgetItem{
+			{Category: "Coffee", Limit: 40, Spent: 25, Remaining: 15},
+			{Category: "Groceries", Limit: 250, Spent: 280, Over: 30},
+			{Category: "Uncategorized", Spent: 12, Over: 12},
+		}},
+		{"a change applies from its month", "2025-02", []BudgetItem{
+			{Category: "Coffee", Limit: 40, Remaining: 40},
+			{Category: "Groceries", Limit: 300, Remaining: 300},
+		}},
+		{"no budget yet", "2024-12", []BudgetItem{}},
+		{"a later budget is in force", "2025-06", []BudgetItem{
+			{Category: "Coffee", Limit: 40, Remaining: 40},
+			{Category: "Groceries", Limit: 250, Remaining: 250},
+			{Category: "Rent", Limit: 1200, Remaining: 1200},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, "GET", "/analytics/budget?month="+tt.month, nil, inWorkspace)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			got := decode[BudgetResponse](t, w)
+			sort.Slice(got.Items, func(i, j int) bool { return got.Items[i].Category < got.Items[j].Category })
+			if got.Month != tt.month || len(got.Items) != len(tt.items) {
+				t.Fatalf("got %+v, want items %+v", got, tt.items)
+			}
+			var limit, spent, remaining, over float64
+			for i, want := range tt.items {
+				if got.Items[i] != want {
+					t.Errorf("items[%d] = %+v, want %+v", i, got.Items[i], want)
+				}
+				limit += want.Limit
+				spent += want.Spent
+				remaining += want.Remaining
+				over += want.Over
+			}
+			if got.Totals.Limit != limit || got.Totals.Spent != spent || got.Totals.Remaining != remaining || got.Totals.Over != over {
+				t.Errorf("totals = %+v", got.Totals)
+			}
+		})
+	}
+
+	w := serve(r, "GET", "/analytics/budget", nil, inWorkspace)
+	if got := decode[BudgetResponse](t, w); got.Month != time.Now().UTC().Format("2006-01") {
+		t.Errorf("default month = %q", got.Month)
+	}
+	for _, q := range []string{"2025-13", "2025-4", "April", "2025-04-01"} {
+		if w := serve(r, "GET", "/analytics/budget?month="+q, nil, inWorkspace); w.Code != http.StatusBadRequest {
+			t.Errorf("month=%s: %d, want 400", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is optional; zero means the key never expires.
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// CreateAPIKeyHandler issues a new API key. The plaintext key is only ever
+// returned in this response.
+func CreateAPIKeyHandler(db *sqldb.DB, auditLog *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		var req CreateAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if len(req.Name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must be at most 100 characters"})
+			return
+		}
+		if len(req.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one scope is required"})
+			return
+		}
+		scopes := make([]string, 0, len(req.Scopes))
+		for _, s := range req.Scopes {
+			if !slices.Contains(models.ValidScopes, s) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + s})
+				return
+			}
+			if !slices.Contains(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+		if req.ExpiresInDays < 0 || req.ExpiresInDays > 3650 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 0 and 3650"})
+			return
+		}
+		var expiresAt *time.Time
+		if req.ExpiresInDays > 0 {
+			t := time.Now().UTC().AddDate(0, 0, req.ExpiresInDays).Truncate(time.Second)
+			expiresAt = &t
+		}
+
+		key, prefix, err := models.GenerateAPIKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		k, err := models.InsertAPIKey(ctx, db, uid, req.Name, prefix, models.HashAPIKey(key), scopes, expiresAt)
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		// The entry holds the key's metadata, never the key itself.
+		audit(c, auditLog, models.AuditEntry{
+			UserID:     idRef(uid),
+			Action:     models.AuditAPIKeyCreate,
+			EntityType: models.AuditEntityAPIKey,
+			EntityID:   auditID(k.ID),
+			After:      k,
+		})
+
+		c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": k})
+	}
+}
+
+func ListAPIKeysHandler(db *sqldb.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		ctx := c.Request.Context()
+
+		keys, err := models.ListAPIKeys(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load API keys"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+	}
+}
+
+func RevokeAPIKeyHandler(db *sqldb.DB, auditLog *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key id"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		err = models.RevokeAPIKey(ctx, db, uid, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, auditLog, models.AuditEntry{
+			UserID:     idRef(uid),
+			Action:     models.AuditAPIKeyRevoke,
+			EntityType: models.AuditEntityAPIKey,
+			EntityID:   auditID(id),
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// audit records e once a change has been made, filling in who made it and
+// from where. A failure to write the log is logged rather than returned:
+// the change has already happened and the caller should hear so.
+func audit(c *gin.Context, auditLog *models.AuditLog, e models.AuditEntry) {
+	if auditLog == nil {
+		return
+	}
+	actor := auditActor(c)
+	if e.ActorUserID == nil {
+		e.ActorUserID = actor.UserID
+	}
+	e.ActorAPIKeyID = actor.APIKeyID
+	if e.WorkspaceID == nil {
+		if wid, ok := c.Get("workspaceID"); ok {
+			id := wid.(int64)
+			e.WorkspaceID = &id
+		}
+	}
+	e.RequestID, e.IP = actor.RequestID, actor.IP
+
+	// Write the entry even if the client has already gone away.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 3*time.Second)
+	defer cancel()
+
+	if err := auditLog.Record(ctx, e); err != nil {
+		log.Printf("audit %s %s %s (request %s): %v", e.Action, e.EntityType, e.EntityID, e.RequestID, err)
+	}
+}
+
+// auditActor is who is making the request, for the model functions that
+// record a change in the transaction that makes it.
+func auditActor(c *gin.Context) models.AuditActor {
+	a := models.AuditActor{RequestID: c.GetString("requestID"), IP: c.ClientIP()}
+	if uid, ok := c.Get("userID"); ok {
+		id := uid.(int64)
+		a.UserID = &id
+	}
+	if kid, ok := c.Get("apiKeyID"); ok {
+		id := kid.(int64)
+		a.APIKeyID = &id
+	}
+	return a
+}
+
+// auditUser records a change a user made to their own account, such as
+// their sign-in methods, with after describing it if there is more to say
+// than the action.
+func auditUser(c *gin.Context, auditLog *models.AuditLog, uid int64, action string, after any) {
+	audit(c, auditLog, models.AuditEntry{
+		ActorUserID: idRef(uid),
+		UserID:      idRef(uid),
+		Action:      action,
+		EntityType:  models.AuditEntityUser,
+		EntityID:    auditID(uid),
+		After:       after,
+	})
+}
+
+// auditFilter reads the filters shared by GET /audit and GET /admin/audit,
+// writing 400 if any is invalid. from and to accept RFC 3339 times or
+// dates; a date for to includes the whole day.
+func auditFilter(c *gin.Context) (models.AuditFilter, bool) {
+	limit, offset, ok := page(c)
+	if !ok {
+		return models.AuditFilter{}, false
+	}
+	f := models.AuditFilter{
+		Action:     c.Query("action"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		RequestID:  c.Query("request_id"),
+		Limit:      limit,
+		Offset:     offset,
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+		days int
+	}{{"from", &f.From, 0}, {"to", &f.To, 1}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			*p.dst = t.UTC()
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": p.name + " must be a date (2006-01-02) or an RFC 3339 time"})
+			return models.AuditFilter{}, false
+		}
+		*p.dst = t.AddDate(0, 0, p.days)
+	}
+	return f, true
+}
+
+func listAudit(c *gin.Context, auditLog *models.AuditLog, f models.AuditFilter) {
+	ctx := c.Request.Context()
+
+	entries, err := auditLog.List(ctx, f)
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "limit": f.Limit, "offset": f.Offset})
+}
+
+// ListAuditHandler shows a member what happened in the current workspace
+// and to their own account:
+// GET /audit?action=&entity_type=&entity_id=&request_id=&from=&to=&limit=&offset=
+// It must run after middleware.Workspace.
+func ListAuditHandler(auditLog *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, ok := auditFilter(c)
+		if !ok {
+			return
+		}
+		f.VisibleTo = &models.AuditScope{UserID: c.GetInt64("userID"), WorkspaceID: c.GetInt64("workspaceID")}
+		listAudit(c, auditLog, f)
+	}
+}
+
+// Audit shows the whole log to administrators. On top of the filters of
+// GET /audit it takes actor_user_id, user_id and workspace_id.
+func (a *Admin) Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, ok := auditFilter(c)
+		if !ok {
+			return
+		}
+		for _, p := range []struct {
+			name string
+			dst  *int64
+		}{{"actor_user_id", &f.ActorUserID}, {"user_id", &f.UserID}, {"workspace_id", &f.WorkspaceID}} {
+			v := c.Query(p.name)
+			if v == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + p.name})
+				return
+			}
+			*p.dst = id
+		}
+		listAudit(c, a.audit, f)
+	}
+}
+
+// auditID formats an entity ID for the log.
+func auditID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// idRef returns a pointer to id, for the nullable user and workspace
+// columns of an audit entry.
+func idRef(id int64) *int64 {
+	return &id
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"auth-service/handlers/middleware"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CookieConfig controls the attributes of the auth_token and csrf_token
+// cookies.
+type CookieConfig struct {
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	// CSRFSecret keys the per-session CSRF token; see middleware.CSRFToken.
+	CSRFSecret []byte
+}
+
+// ParseSameSite maps "lax", "strict" or "none" to the http.SameSite mode.
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown SameSite mode %q", s)
+	}
+}
+
+// setSessionCookies sets the HttpOnly auth cookie and the JS-readable CSRF
+// cookie for the session identified by tokenID, and returns the CSRF token.
+func (cc CookieConfig) setSessionCookies(c *gin.Context, token, tokenID string, maxAge int) string {
+	csrf := middleware.CSRFToken(cc.CSRFSecret, tokenID)
+	http.SetCookie(c.Writer, cc.cookie("auth_token", token, maxAge, true))
+	http.SetCookie(c.Writer, cc.cookie(middleware.CSRFCookieName, csrf, maxAge, false))
+	return csrf
+}
+
+func (cc CookieConfig) clearSessionCookies(c *gin.Context) {
+	http.SetCookie(c.Writer, cc.cookie("auth_token", "", -1, true))
+	http.SetCookie(c.Writer, cc.cookie(middleware.CSRFCookieName, "", -1, false))
+}
+
+func (cc CookieConfig) cookie(name, value string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   cc.Domain,
+		MaxAge:   maxAge,
+		Secure:   cc.Secure,
+		HttpOnly: httpOnly,
+		SameSite: cc.SameSite,
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/password"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Passwords is how passwords are hashed and which new ones are accepted.
+type Passwords struct {
+	Hasher password.Hasher
+	Policy password.Policy
+}
+
+// matches checks pw against a stored hash and says whether the hash should
+// be upgraded. Passwords used to be trimmed before hashing, so an account
+// created with surrounding spaces has the trimmed form stored; that form
+// is tried second.
+func (p Passwords) matches(hash, pw string) (ok, rehash bool) {
+	if hash == models.NoPasswordHash {
+		return false, false
+	}
+	ok, rehash, err := p.Hasher.Verify(hash, pw)
+	if err != nil {
+		log.Printf("verify password: %v", err)
+		return false, false
+	}
+	if ok {
+		return true, rehash
+	}
+	trimmed := strings.TrimSpace(pw)
+	if trimmed == pw || trimmed == "" {
+		return false, false
+	}
+	ok, _, _ = p.Hasher.Verify(hash, trimmed)
+	// Never rehash here: the caller would store the password as typed,
+	// spaces and all, and the password without them would stop working.
+	return ok, false
+}
+
+func (p Passwords) hash(pw string) (string, error) {
+	return p.Hasher.Hash(pw)
+}
+
+// checkNew applies the policy to a password being set, writing the error
+// response itself when it is refused.
+func (p Passwords) checkNew(c *gin.Context, pw, email string) bool {
+	problems, err := p.Policy.Check(pw, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return false
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problems[0].Message, "problems": problems})
+		return false
+	}
+	return true
+}
@@ -1,29 +1,94 @@
 package handlers
 
 import (
+	"auth-service/health"
 	"context"
-	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// HealthzHandler is the liveness probe. It checks nothing beyond the
+// process answering HTTP, so that an outage of the database does not get
+// every instance restarted.
 func HealthzHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	}
 }
 
-func HealthDBHandler(db *sql.DB) gin.HandlerFunc {
+// ReadyCheck is one dependency that must work for the instance to take
+// traffic. Check returns nil when it does, and *health.Degraded for a
+// problem that is worth noting but does not stop it.
+type ReadyCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type ReadyzResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]ReadyCheckResult `json:"checks"`
+}
+
+type ReadyCheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// ReadyzHandler is the readiness probe. It runs the checks at once, each
+// within timeout, and answers 200 if all of them pass and 503 if not, with
+// the outcome and latency of each. A degraded check passes but makes the
+// overall status "degraded". The probe is public, so errors, which can
+// name hosts or carry driver messages, are logged rather than returned.
+func ReadyzHandler(checks []ReadyCheck, timeout time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(c.Request.Context(), 1*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
 		defer cancel()
-		if db.PingContext(ctx) != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded"})
-			c.Abort()
-			return
+
+		results := make([]ReadyCheckResult, len(checks))
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				start := time.Now()
+				err := check.Check(ctx)
+				results[i] = ReadyCheckResult{
+					Status:    "ok",
+					LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				}
+				var degraded *health.Degraded
+				switch {
+				case errors.As(err, &degraded):
+					results[i].Status = "degraded"
+				case err != nil:
+					results[i].Status = "fail"
+				}
+				if err != nil {
+					log.Printf("readyz: %s: %v", check.Name, err)
+				}
+			}()
 		}
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		wg.Wait()
+
+		resp := ReadyzResponse{Status: "ok", Checks: map[string]ReadyCheckResult{}}
+		status := http.StatusOK
+		for i, check := range checks {
+			resp.Checks[check.Name] = results[i]
+			switch results[i].Status {
+			case "fail":
+				resp.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			case "degraded":
+				if status == http.StatusOK {
+					resp.Status = "degraded"
+				}
+			}
+		}
+		c.JSON(status, resp)
 	}
 }
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"auth-service/health"
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHealthz(t *testing.T) {
+	r := gin.New()
+	r.GET("/healthz", HealthzHandler())
+	if w := serve(r, "GET", "/healthz", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("status = %d", w.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+	flaky := func(context.Context) error { return &health.Degraded{Err: errors.New("reload failed")} }
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name     string
+		checks   []ReadyCheck
+		code     int
+		status   string
+		failed   map[string]string
+		degraded map[string]string
+	}{
+		{"all pass", []ReadyCheck{{"database", ok}, {"migrations", ok}}, http.StatusOK, "ok", nil, nil},
+		{"no checks", nil, http.StatusOK, "ok", nil, nil},
+		{"one fails", []ReadyCheck{{"database", down}, {"migrations", ok}}, http.StatusServiceUnavailable, "unavailable",
+			map[string]string{"database": "connection refused"}, nil},
+		{"one hangs", []ReadyCheck{{"database", ok}, {"worker.keys", hang}}, http.StatusServiceUnavailable, "unavailable",
+			map[string]string{"worker.keys": context.DeadlineExceeded.Error()}, nil},
+		{"one degraded", []ReadyCheck{{"database", ok}, {"worker.tls_cert", flaky}}, http.StatusOK, "degraded",
+			nil, map[string]string{"worker.tls_cert": "reload failed"}},
+		{"degraded and failed", []ReadyCheck{{"database", down}, {"worker.tls_cert", flaky}}, http.StatusServiceUnavailable, "unavailable",
+			map[string]string{"database": "connection refused"}, map[string]string{"worker.tls_cert": "reload failed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+
+			r := gin.New()
+			r.GET("/readyz", ReadyzHandler(tt.checks, 50*time.Millisecond))
+			w := serve(r, "GET", "/readyz", nil, nil)
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d", w.Code, tt.code)
+			}
+			got := decode[ReadyzResponse](t, w)
+			if got.Status != tt.status {
+				t.Errorf("status = %q, want %q", got.Status, tt.status)
+			}
+			if len(got.Checks) != len(tt.checks) {
+				t.Fatalf("checks = %+v", got.Checks)
+			}
+			for _, check := range tt.checks {
+				res := got.Checks[check.Name]
+				wantErr, failed := tt.failed[check.Name]
+				wantWarn, degraded := tt.degraded[check.Name]
+				switch {
+				case failed && res.Status != "fail":
+					t.Errorf("%s = %+v, want failure", check.Name, res)
+				case degraded && res.Status != "degraded":
+					t.Errorf("%s = %+v, want degraded", check.Name, res)
+				case !failed && !degraded && res.Status != "ok":
+					t.Errorf("%s = %+v, want ok", check.Name, res)
+				}
+				// Errors go to the log, not to whoever asks.
+				for _, msg := range []string{wantErr, wantWarn} {
+					if msg == "" {
+						continue
+					}
+					if strings.Contains(w.Body.String(), msg) {
+						t.Errorf("response shows %q: %s", msg, w.Body)
+					}
+					if !strings.Contains(logged.String(), check.Name+": "+msg) {
+						t.Errorf("log does not have %q: %s", msg, &logged)
+					}
+				}
+			}
+			if res, ok := got.Checks["worker.keys"]; ok && res.LatencyMS < 50 {
+				t.Errorf("hung check latency = %vms, want the timeout", res.LatencyMS)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"auth-service/keys"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSHandler publishes the public token verification keys. Verifiers
+// should cache the document and refetch it when they see an unknown kid.
+func JWKSHandler(ks *keys.KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, ks.JWKS())
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ledger edits a workspace's transactions and budgets. Its routes run
+// behind middleware.Workspace and require the editor role. Every change is
+// recorded in the audit log by the model function that makes it, in the
+// same database transaction.
+type Ledger struct {
+	db *sqldb.DB
+}
+
+func NewLedger(db *sqldb.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+// TransactionRequest edits a transaction; fields left out are kept.
+type TransactionRequest struct {
+	Date        *string  `json:"date"`
+	Amount      *float64 `json:"amount"`
+	Merchant    *string  `json:"merchant"`
+	Category    *string  `json:"category"`
+	Description *string  `json:"description"`
+}
+
+// change validates req against the transactions table, writing 400 if it
+// does not fit.
+func (req TransactionRequest) change(c *gin.Context) (models.TransactionChange, bool) {
+	ch := models.TransactionChange{Amount: req.Amount, Description: req.Description}
+	if req.Date != nil {
+		d, err := time.Parse(time.DateOnly, *req.Date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+			return models.TransactionChange{}, false
+		}
+		ch.Date = &d
+	}
+	for _, f := range []struct {
+		name string
+		v    *string
+		max  int
+		dst  **string
+	}{{"merchant", req.Merchant, 100, &ch.Merchant}, {"category", req.Category, 80, &ch.Category}} {
+		if f.v == nil {
+			continue
+		}
+		s := strings.TrimSpace(*f.v)
+		if s == "" || len(s) > f.max {
+			c.JSON(http.StatusBadRequest, gin.H{"error": f.name + " must be 1 to " + strconv.Itoa(f.max) + " characters"})
+			return models.TransactionChange{}, false
+		}
+		*f.dst = &s
+	}
+	if req.Description != nil && len(*req.Description) > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "description must be at most 1000 characters"})
+		return models.TransactionChange{}, false
+	}
+	return ch, true
+}
+
+func transactionID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// UpdateTransaction: PATCH /workspaces/:workspaceID/transactions/:id
+func (l *Ledger) UpdateTransaction() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := transactionID(c)
+		if !ok {
+			return
+		}
+		var req TransactionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		ch, ok := req.change(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		t, err := models.UpdateTransaction(ctx, l.db, c.GetInt64("workspaceID"), id, ch, auditActor(c))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	}
+}
+
+// DeleteTransaction: DELETE /workspaces/:workspaceID/transactions/:id
+func (l *Ledger) DeleteTransaction() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := transactionID(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		err := models.DeleteTransaction(ctx, l.db, c.GetInt64("workspaceID"), id, auditActor(c))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+type BudgetRequest struct {
+	Limit *float64 `json:"limit"`
+}
+
+// budgetMonth parses the :month parameter, YYYY-MM.
+func budgetMonth(c *gin.Context) (time.Time, bool) {
+	m, err := time.Parse("2006-01", c.Param("month"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be YYYY-MM"})
+		return time.Time{}, false
+	}
+	return m, true
+}
+
+// SetBudget: PUT /workspaces/:workspaceID/budgets/:month/:category sets
+// the limit from that month on. The category may be given by an alias.
+func (l *Ledger) SetBudget() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		month, ok := budgetMonth(c)
+		if !ok {
+			return
+		}
+		var req BudgetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		if req.Limit == nil || *req.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative amount"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		b, err := models.SetBudget(ctx, l.db, c.GetInt64("workspaceID"), c.GetInt64("userID"),
+			c.Param("category"), month, *req.Limit, auditActor(c))
+		if errors.Is(err, models.ErrNoCategory) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.JSON(http.StatusOK, b)
+	}
+}
+
+// DeleteBudget: DELETE /workspaces/:workspaceID/budgets/:month/:category.
+// The limit set for an earlier month, if any, applies again.
+func (l *Ledger) DeleteBudget() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		month, ok := budgetMonth(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		err := models.DeleteBudget(ctx, l.db, c.GetInt64("workspaceID"), c.Param("category"), month, auditActor(c))
+		if errors.Is(err, models.ErrNoCategory) || errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "budget not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+type CategoryMergeRequest struct {
+	From string `json:"from"`
+	Into string `json:"into"`
+}
+
+// MergeCategories: POST /admin/categories/merge. Categories are shared by
+// every workspace, so only administrators may merge them.
+func (a *Admin) MergeCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CategoryMergeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		m, err := models.MergeCategories(ctx, a.db, req.From, req.Into, auditActor(c))
+		if errors.Is(err, models.ErrNoCategory) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+			return
+		}
+		if errors.Is(err, models.ErrSameCategory) || errors.Is(err, models.ErrMergeUncategorized) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.JSON(http.StatusOK, m)
+	}
+}
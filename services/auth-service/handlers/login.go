@@ -2,23 +2,26 @@ package handlers
 
 import (
 	"auth-service/models"
+	"auth-service/store"
 	"context"
 	"database/sql"
 	"errors"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type Login struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// ReturnToken asks for the token in the response body instead of a
+	// cookie, for CLI scripts, mobile apps and other non-browser clients.
+	ReturnToken bool `json:"return_token"`
 }
 
-func AuthHandler(db *sql.DB, jwtSecret string) gin.HandlerFunc {
+func AuthHandler(users store.UserStore, sessions Sessions, guard *LoginGuard, passwords Passwords) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var l Login
 		if err := c.ShouldBindJSON(&l); err != nil {
@@ -26,7 +29,6 @@ func AuthHandler(db *sql.DB, jwtSecret string) gin.HandlerFunc {
 		return
 	}
 		l.Email = strings.ToLower(strings.TrimSpace(l.Email))
-		l.Password = strings.TrimSpace(l.Password)
 
 		if l.Email == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
@@ -37,12 +39,27 @@ func AuthHandler(db *sql.DB, jwtSecret string) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
 			return
 		}
-		ctx, cancel := context.WithTimeout(c.Request.Context(), 3*time.Second)
-		defer cancel()
+		ip := c.ClientIP()
+		if wait := guard.throttled(ip, l.Email); wait > 0 {
+			guard.record(c.Request.Context(), users, models.LoginAttempt{
+				Email: l.Email, IP: ip, UserAgent: c.Request.UserAgent(), Reason: models.LoginReasonThrottled,
+			})
+			setRetryAfter(c, wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+			return
+		}
 
-		u, hashedPassword, err := models.GetUserByEmail(ctx, db, l.Email)
+		ctx := c.Request.Context()
+
+		u, hashedPassword, lockedUntil, err := users.UserForLogin(ctx, l.Email)
 
 		if errors.Is(err, sql.ErrNoRows) {
+			// Burn the same hashing time as a wrong password so that response
+			// times do not reveal which emails have accounts.
+			passwords.matches(guard.dummyHash, l.Password)
+			guard.fail(ctx, users, models.LoginAttempt{
+				Email: l.Email, IP: ip, UserAgent: c.Request.UserAgent(), Reason: models.LoginReasonUnknownEmail,
+			})
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
@@ -54,44 +71,73 @@ func AuthHandler(db *sql.DB, jwtSecret string) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			return
 		}
+		if time.Until(lockedUntil) > 0 {
+			// Spend the usual hashing time and answer as the per-email
+			// backoff does, so that a lockout does not reveal that the
+			// email has an account.
+			passwords.matches(guard.dummyHash, l.Password)
+			wait := guard.refuseLocked(ctx, users, models.LoginAttempt{
+				Email: l.Email, UserID: u.ID, IP: ip, UserAgent: c.Request.UserAgent(),
+			})
+			setRetryAfter(c, wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+			return
+		}
 
-		Err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(l.Password))
-
-		if Err != nil {
+		var ok, rehash bool
+		if hashedPassword == models.NoPasswordHash {
+			// Signed up through an external provider and never set a
+			// password: spend the usual time, then fail like a wrong one.
+			passwords.matches(guard.dummyHash, l.Password)
+		} else {
+			ok, rehash = passwords.matches(hashedPassword, l.Password)
+		}
+		if !ok {
+			guard.fail(ctx, users, models.LoginAttempt{
+				Email: l.Email, UserID: u.ID, IP: ip, UserAgent: c.Request.UserAgent(), Reason: models.LoginReasonBadPassword,
+			})
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 			return
 		}
-		now := time.Now().Unix()
-		exp := time.Now().Add(1*time.Hour).Unix()
-
-		claims := jwt.MapClaims {
-			"sub": u.ID,
-			"email": u.Email,
-			"iat": now,
-			"exp": exp, 
+		if refuseDisabled(c, u) {
+			return
 		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signed, err := token.SignedString([]byte(jwtSecret))
-
+		if rehash {
+			// The hash is in an older scheme or with weaker parameters than
+			// we use now; this is the only moment we have the password to
+			// upgrade it. Failing to is not a reason to refuse the login.
+			if newHash, err := passwords.hash(l.Password); err != nil {
+				log.Printf("rehash password for user %d: %v", u.ID, err)
+			} else if err := users.SetPasswordHash(ctx, u.ID, newHash); err != nil {
+				log.Printf("store rehashed password for user %d: %v", u.ID, err)
+			}
+		}
+		totpEnabled, err := users.TOTPEnabled(ctx, u.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			return
 		}
+		if totpEnabled {
+			// The password was right but the session is withheld until the
+			// second factor is presented to /login/2fa. The account's
+			// failures are kept until then, so that each new pre-auth token
+			// does not bring a fresh round of guesses at the code.
+			preAuthToken, _, err := sessions.sign(u, tokenTypeMFA, preAuthTTL)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"mfa_required": true,
+				"pre_auth_token": preAuthToken,
+				"expires_in": int(preAuthTTL / time.Second),
+			})
+			return
+		}
 
-		c.SetCookie(
-			"auth_token",
-			signed,
-			3600,
-			"/",
-			"",
-			//  secure
-			false, 
-			// httpOnly
-			true,
-		)
-
-		c.JSON(http.StatusOK, gin.H{"id": u.ID, "email": u.Email})
+		guard.succeed(ctx, users, models.LoginAttempt{
+			Email: l.Email, UserID: u.ID, IP: ip, UserAgent: c.Request.UserAgent(), Reason: loginPassword,
+		})
+		sessions.start(c, u, loginPassword, l.ReturnToken)
 		}
 	}
-
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/password"
+	"auth-service/store"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// backoff tracks recent failures per key and says how long the next attempt
+// must wait. The first free failures cost nothing; after that each failure
+// doubles the wait, starting at base and capped at max.
+type backoff struct {
+	free int
+	base time.Duration
+	max  time.Duration
+
+	mu       sync.Mutex
+	failures map[string]failureRun
+	lastGC   time.Time
+}
+
+type failureRun struct {
+	n    int
+	last time.Time
+}
+
+func newBackoff(free int, base, max time.Duration) *backoff {
+	return &backoff{free: free, base: base, max: max, failures: map[string]failureRun{}}
+}
+
+func (b *backoff) delay(n int) time.Duration {
+	if n <= b.free {
+		return 0
+	}
+	exp := n - b.free - 1
+	if exp > 30 {
+		return b.max
+	}
+	d := b.base << uint(exp)
+	if d > b.max || d <= 0 {
+		return b.max
+	}
+	return d
+}
+
+// wait returns how long key must wait before its next attempt.
+func (b *backoff) wait(key string, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r, ok := b.failures[key]
+	if !ok {
+		return 0
+	}
+	if w := r.last.Add(b.delay(r.n)).Sub(now); w > 0 {
+		return w
+	}
+	return 0
+}
+
+func (b *backoff) fail(key string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A run is forgotten once it has been quiet for twice the longest
+	// delay, so keys do not accumulate forever.
+	forgetAfter := 2 * b.max
+	if now.Sub(b.lastGC) > time.Minute {
+		for k, r := range b.failures {
+			if now.Sub(r.last) > forgetAfter {
+				delete(b.failures, k)
+			}
+		}
+		b.lastGC = now
+	}
+	r := b.failures[key]
+	if now.Sub(r.last) > forgetAfter {
+		r.n = 0
+	}
+	r.n++
+	r.last = now
+	b.failures[key] = r
+}
+
+func (b *backoff) reset(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, key)
+}
+
+// LoginGuard protects the password login against guessing. Clients are
+// slowed down per IP and per email with exponential backoff held in memory,
+// and after MaxFailures consecutive wrong passwords the account itself is
+// locked in the database for LockoutFor, which holds across instances.
+// Every attempt is recorded in login_attempts.
+type LoginGuard struct {
+	MaxFailures int
+	LockoutFor  time.Duration
+
+	byIP    *backoff
+	byEmail *backoff
+
+	// dummyHash is compared against when the email is unknown, so that the
+	// response takes as long as for a wrong password. It is made with the
+	// preferred hasher, which is what most stored hashes end up in.
+	dummyHash string
+}
+
+func NewLoginGuard(maxFailures int, lockoutFor time.Duration, hasher password.Hasher) (*LoginGuard, error) {
+	dummy, err := hasher.Hash("not a real password")
+	if err != nil {
+		return nil, err
+	}
+	return &LoginGuard{
+		MaxFailures: maxFailures,
+		LockoutFor:  lockoutFor,
+		// A shared NAT or office can legitimately produce a few failures,
+		// so IPs get more slack than a single account.
+		byIP:      newBackoff(20, time.Second, 15*time.Minute),
+		byEmail:   newBackoff(3, time.Second, 15*time.Minute),
+		dummyHash: dummy,
+	}, nil
+}
+
+// throttled returns how long the client must wait before trying this email
+// again from this IP; zero means go ahead.
+func (g *LoginGuard) throttled(ip, email string) time.Duration {
+	now := time.Now()
+	w := g.byIP.wait(ip, now)
+	if e := g.byEmail.wait(email, now); e > w {
+		w = e
+	}
+	return w
+}
+
+// fail records a failed attempt. uid is zero for unknown emails; the same
+// queries run either way so that the two cases cost the same.
+func (g *LoginGuard) fail(ctx context.Context, users store.UserStore, a models.LoginAttempt) {
+	now := time.Now()
+	g.byIP.fail(a.IP, now)
+	g.byEmail.fail(a.Email, now)
+
+	lockedUntil, err := users.RegisterLoginFailure(ctx, a.UserID, g.MaxFailures, g.LockoutFor)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("login guard: count failure for user %d: %v", a.UserID, err)
+	}
+	if !lockedUntil.IsZero() {
+		a.Reason = models.LoginReasonLocked
+	}
+	g.record(ctx, users, a)
+}
+
+// refuseLocked records an attempt on a locked account. It counts towards
+// the backoff like a failure but leaves the lockout as it is, and returns
+// how long the client must now wait, so that the answer looks like the
+// backoff holding back any other email.
+func (g *LoginGuard) refuseLocked(ctx context.Context, users store.UserStore, a models.LoginAttempt) time.Duration {
+	now := time.Now()
+	g.byIP.fail(a.IP, now)
+	g.byEmail.fail(a.Email, now)
+	a.Reason = models.LoginReasonLocked
+	g.record(ctx, users, a)
+	return g.throttled(a.IP, a.Email)
+}
+
+// succeed records a successful login and clears the failure history for
+// the account. The IP's history is kept: logging into one's own account
+// must not reset guessing elsewhere.
+func (g *LoginGuard) succeed(ctx context.Context, users store.UserStore, a models.LoginAttempt) {
+	g.byEmail.reset(a.Email)
+	if err := users.ResetLoginFailures(ctx, a.UserID); err != nil {
+		log.Printf("login guard: reset failures for user %d: %v", a.UserID, err)
+	}
+	a.Success = true
+	g.record(ctx, users, a)
+}
+
+// record writes an audit row. A failed write is logged rather than failing
+// the request.
+func (g *LoginGuard) record(ctx context.Context, users store.UserStore, a models.LoginAttempt) {
+	if err := users.RecordLoginAttempt(ctx, a); err != nil {
+		log.Printf("login guard: record attempt: %v", err)
+	}
+}
+
+func setRetryAfter(c *gin.Context, d time.Duration) {
+	secs := int(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(secs))
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LogoutHandler revokes the token the request was made with and clears the
+// cookie. It must run behind middleware.Auth.
+func LogoutHandler(revocations *models.RevocationStore, cookies CookieConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+		jti := c.GetString("tokenID")
+		exp := c.GetTime("tokenExpiresAt")
+
+		ctx := c.Request.Context()
+
+		if err := revocations.Revoke(ctx, jti, uid, exp); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		cookies.clearSessionCookies(c)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// LogoutAllHandler invalidates every token issued to the user so far, on
+// every device, and clears the cookie for this one.
+func LogoutAllHandler(revocations *models.RevocationStore, cookies CookieConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		ctx := c.Request.Context()
+
+		if _, err := revocations.InvalidateUser(ctx, uid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		cookies.clearSessionCookies(c)
+		c.Status(http.StatusNoContent)
+	}
+}
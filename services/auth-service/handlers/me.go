@@ -1,27 +1,57 @@
 package handlers
 
 import (
+    "auth-service/models"
+    "auth-service/store"
+    "context"
+    "database/sql"
+    "errors"
     "net/http"
+    "strings"
     "github.com/gin-gonic/gin"
 )
 
-func MeHandler () gin.HandlerFunc {
+// MeHandler returns the signed-in user's live record rather than the
+// token's claims, which go stale after a profile or email change.
+func MeHandler (users store.UserStore) gin.HandlerFunc {
 	return func( c*gin.Context) {
 		userID, exists := c.Get("userID")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
 			return
 		}
-		email, exists := c.Get("email")
-		if !exists {
+
+		ctx := c.Request.Context()
+
+		p, err := users.Profile(ctx, userID.(int64))
+		if errors.Is(err, sql.ErrNoRows) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
 			return
 		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"id": userID,
-			"email": email,
-		})
+		c.JSON(http.StatusOK, profileResponse(p))
 				
 	}
-}
\ No newline at end of file
+}
+
+func profileResponse(p models.Profile) gin.H {
+	return gin.H{
+		"id": p.ID,
+		"email": p.Email,
+		"email_verified": p.EmailVerified,
+		"pending_email": p.PendingEmail,
+		"display_name": p.DisplayName,
+		"base_currency": p.BaseCurrency,
+		"timezone": p.Timezone,
+		"week_start": strings.ToLower(p.WeekStart.String()),
+		"created_at": p.CreatedAt,
+	}
+}
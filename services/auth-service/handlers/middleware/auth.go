@@ -1,34 +1,38 @@
 package middleware
 
 import (
-	"fmt"
+	"auth-service/keys"
+	"auth-service/models"
+	"database/sql"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func Auth(jwtSecret string) gin.HandlerFunc {
+func Auth(ks *keys.KeySet, revocations *models.RevocationStore, apiKeys *models.APIKeyStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("auth_token")
-		if err != nil {
+		tokenString, method, ok := tokenFromRequest(c)
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
+		if method == AuthMethodBearer && strings.HasPrefix(tokenString, models.APIKeyPrefix) {
+			authAPIKey(c, apiKeys, tokenString)
 			return
 		}
 
 
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if t.Method != jwt.SigningMethodHS256 {
-				return nil, fmt.Errorf("bad alg")
-			}
-			return []byte(jwtSecret), nil
-		})
+		token, err := ks.Parse(tokenString)
 		if err != nil  || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
 			c.Abort();
 			return
 		}
-		 
+
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
@@ -36,6 +40,14 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
+		// Typed tokens (e.g. the pre-auth token between password and second
+		// factor) are for one specific endpoint and never act as a session.
+		if _, hasTyp := claims["typ"]; hasTyp {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
+
 		idf, ok := claims["sub"].(float64)
 		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
@@ -48,11 +60,123 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 			c.Abort();
 			return
 		}
+		jti, ok := claims["jti"].(string)
+		if !ok || jti == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
+		exp, err := claims.GetExpirationTime()
+		if err != nil || exp == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
 		id := int64(idf)
+		// Tokens only name a role other than the default.
+		role, _ := claims["role"].(string)
+		if role == "" {
+			role = models.RoleUser
+		}
+
+		ctx := c.Request.Context()
+
+		revoked, err := revocations.IsRevoked(ctx, jti, exp.Time)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			c.Abort();
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
+
+		// A password change or "log out everywhere" moves the user's cutoff
+		// forward; anything issued up to it is no longer honoured. Both have
+		// one-second resolution, so a token from the same second is refused.
+		validAfter, err := revocations.ValidAfter(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			c.Abort();
+			return
+		}
+		if !iat.Time.After(validAfter) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+			c.Abort();
+			return
+		}
 
 		c.Set("userID", id)
 		c.Set("email", email)
+		c.Set("tokenID", jti)
+		c.Set("tokenExpiresAt", exp.Time)
+		c.Set("authMethod", method)
+		c.Set("role", role)
 		c.Next()
 
 	}
-}
\ No newline at end of file
+}
+
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodBearer = "bearer"
+	AuthMethodAPIKey = "api_key"
+)
+
+// authAPIKey authenticates a personal API key. Unlike sessions, the request
+// is limited to the key's scopes; see RequireScope.
+func authAPIKey(c *gin.Context, apiKeys *models.APIKeyStore, key string) {
+	ctx := c.Request.Context()
+
+	k, email, err := apiKeys.Verify(ctx, key)
+	if errors.Is(err, models.ErrInvalidAPIKey) || errors.Is(err, models.ErrAPIKeyExpired) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized access"})
+		c.Abort();
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		c.Abort();
+		return
+	}
+
+	c.Set("userID", k.UserID)
+	c.Set("email", email)
+	c.Set("apiKeyID", k.ID)
+	c.Set("scopes", k.Scopes)
+	c.Set("authMethod", AuthMethodAPIKey)
+	c.Next()
+}
+
+// tokenFromRequest prefers an Authorization: Bearer header and falls back to
+// the auth_token cookie. A malformed Authorization header is an error rather
+// than a reason to try the cookie, so a client never silently authenticates
+// as someone other than it asked to.
+func tokenFromRequest(c *gin.Context) (string, string, bool) {
+	if h := c.GetHeader("Authorization"); h != "" {
+		scheme, tok, found := strings.Cut(h, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return "", "", false
+		}
+		tok = strings.TrimSpace(tok)
+		return tok, AuthMethodBearer, tok != ""
+	}
+	tok, err := c.Cookie("auth_token")
+	if err != nil || tok == "" {
+		return "", "", false
+	}
+	return tok, AuthMethodCookie, true
+}
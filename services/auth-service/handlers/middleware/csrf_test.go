@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testCSRFSecret = []byte("test-csrf-secret")
+
+// csrfRouter mounts CSRF behind a stand-in for Auth that marks the request
+// as authenticated by the given method with token ID "tid-1".
+func csrfRouter(method string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	fakeAuth := func(c *gin.Context) {
+		c.Set("authMethod", method)
+		c.Set("tokenID", "tid-1")
+		c.Next()
+	}
+	csrf := CSRF(testCSRFSecret, []string{"https://app.example.com"})
+	r.POST("/mutate", fakeAuth, csrf, func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/read", fakeAuth, csrf, func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func TestCSRF(t *testing.T) {
+	valid := CSRFToken(testCSRFSecret, "tid-1")
+
+	tests := []struct {
+		name    string
+		auth    string
+		method  string
+		path    string
+		headers map[string]string
+		want    int
+	}{
+		{
+			name:   "cross-site POST without token",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "cross-site POST replaying a valid token",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{"Origin": "https://evil.example", CSRFHeaderName: valid},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "cross-site POST from an opaque origin",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{"Origin": "null", CSRFHeaderName: valid},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "cross-site POST identified only by Referer",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{"Referer": "https://evil.example/page", CSRFHeaderName: valid},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "POST with no origin and no token",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "POST with a token for another session",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{CSRFHeaderName: CSRFToken(testCSRFSecret, "tid-2")},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "POST with a token under another secret",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{CSRFHeaderName: CSRFToken([]byte("other"), "tid-1")},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "same-origin POST with token",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{"Origin": "http://example.com", CSRFHeaderName: valid},
+			want:    http.StatusOK,
+		},
+		{
+			name:   "trusted-origin POST with token",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{"Origin": "https://app.example.com", CSRFHeaderName: valid},
+			want:    http.StatusOK,
+		},
+		{
+			name:   "POST with token and no origin",
+			auth:   AuthMethodCookie,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{CSRFHeaderName: valid},
+			want:    http.StatusOK,
+		},
+		{
+			name:   "cross-site GET is not checked",
+			auth:   AuthMethodCookie,
+			method: http.MethodGet, path: "/read",
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusOK,
+		},
+		{
+			name:   "bearer POST is exempt",
+			auth:   AuthMethodBearer,
+			method: http.MethodPost, path: "/mutate",
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			csrfRouter(tt.auth).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"auth-service/ratelimit"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit limits requests per client with a token bucket named name, so
+// that each route's limit is counted separately. Clients are identified by
+// user ID when an earlier Auth has run and by IP otherwise. Responses carry
+// the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and
+// Retry-After when refused. If the store fails the request is let through:
+// an outage of the limiter should not take the service down with it.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := name + ":ip:" + c.ClientIP()
+		if uid, ok := c.Get("userID"); ok {
+			key = name + ":user:" + strconv.FormatInt(uid.(int64), 10)
+		}
+
+		res, err := store.Take(c.Request.Context(), key, limit, time.Now())
+		if err != nil {
+			log.Printf("rate limit %s: %v", name, err)
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+		if !res.Allowed {
+			h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"auth-service/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitIgnoresForwardedForFromUntrustedPeers(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		want    int // status of the second request
+	}{
+		// With no trusted proxies a client cannot get a fresh bucket by
+		// making up a new X-Forwarded-For for each request.
+		{"no trusted proxies", nil, http.StatusTooManyRequests},
+		{"peer is a trusted proxy", []string{"192.0.2.0/24"}, http.StatusOK},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			if err := r.SetTrustedProxies(tt.proxies); err != nil {
+				t.Fatal(err)
+			}
+			limit := ratelimit.Limit{Requests: 1, Per: time.Minute}
+			r.GET("/", RateLimit(ratelimit.NewMemoryStore(), "test", limit), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			var codes []int
+			for _, forwarded := range []string{"203.0.113.1", "203.0.113.2"} {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = "192.0.2.10:40000"
+				req.Header.Set("X-Forwarded-For", forwarded)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				codes = append(codes, w.Code)
+			}
+			if codes[0] != http.StatusOK || codes[1] != tt.want {
+				t.Errorf("statuses = %v, want [200 %d]", codes, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"none sent", "", false},
+		{"proxy ID", "2f1c-9a7e_01.edge:3", true},
+		{"too long", strings.Repeat("a", maxRequestIDLen+1), false},
+		{"unsafe characters", "abc\r\nSet-Cookie: x", false},
+		{"spaces", "a b", false},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			var seen string
+			r.GET("/", RequestID(), func(c *gin.Context) {
+				seen = c.GetString("requestID")
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			got := w.Header().Get(RequestIDHeader)
+			if got == "" || got != seen {
+				t.Fatalf("header %q, context %q; want the same non-empty ID", got, seen)
+			}
+			if tt.keep != (got == tt.header) {
+				t.Errorf("ID = %q, sent %q, want kept = %v", got, tt.header, tt.keep)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole admits sessions whose token carries one of roles. API keys
+// carry no role and are always refused, so a leaked key cannot reach
+// administrative endpoints. It must run after Auth.
+//
+// The role is read from the token, so a change takes effect once the
+// user's existing tokens are invalidated; the admin API does that whenever
+// it changes a role.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") == AuthMethodAPIKey || !slices.Contains(roles, c.GetString("role")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
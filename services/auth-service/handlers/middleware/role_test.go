@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		role   string
+		want   int
+	}{
+		{"admin session", AuthMethodCookie, "admin", http.StatusOK},
+		{"admin bearer", AuthMethodBearer, "admin", http.StatusOK},
+		{"user session", AuthMethodCookie, "user", http.StatusForbidden},
+		{"no role", AuthMethodCookie, "", http.StatusForbidden},
+		{"API key of an admin", AuthMethodAPIKey, "admin", http.StatusForbidden},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			fakeAuth := func(c *gin.Context) {
+				c.Set("authMethod", tt.method)
+				if tt.role != "" {
+					c.Set("role", tt.role)
+				}
+				c.Next()
+			}
+			r.GET("/admin", fakeAuth, RequireRole("admin"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope limits API-key requests to keys granted scope. Sessions
+// (cookie or bearer JWT) act with the user's full authority and always
+// pass. It must run after Auth.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") != AuthMethodAPIKey {
+			c.Next()
+			return
+		}
+		if !slices.Contains(c.GetStringSlice("scopes"), scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// SessionOnly rejects API-key requests, for endpoints such as key management
+// that a leaked key must not be able to reach. It must run after Auth.
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") == AuthMethodAPIKey {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not available to API keys"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
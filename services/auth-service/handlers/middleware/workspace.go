@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkspaceHeader selects the workspace a request acts on when the path
+// does not name one.
+const WorkspaceHeader = "X-Workspace-ID"
+
+// Workspace resolves the workspace a request acts on and the caller's role
+// in it, and sets them as "workspaceID" and "workspaceRole". The workspace
+// is taken from the :workspaceID path parameter, then the X-Workspace-ID
+// header, then the user's default. Workspaces the caller is not a member
+// of are reported as not found. It must run after Auth.
+func Workspace(db *sqldb.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetInt64("userID")
+
+		raw := c.Param("workspaceID")
+		if raw == "" {
+			raw = c.GetHeader(WorkspaceHeader)
+		}
+
+		ctx := c.Request.Context()
+
+		var wid int64
+		var role string
+		var err error
+		if raw == "" {
+			wid, role, err = models.GetDefaultWorkspace(ctx, db, uid)
+			if errors.Is(err, models.ErrNoWorkspace) {
+				c.JSON(http.StatusConflict, gin.H{"error": "no default workspace; choose one with " + WorkspaceHeader})
+				c.Abort()
+				return
+			}
+		} else {
+			wid, err = strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+				c.Abort()
+				return
+			}
+			role, err = models.GetWorkspaceRole(ctx, db, wid, uid)
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "workspace not found"})
+				c.Abort()
+				return
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			c.Abort()
+			return
+		}
+
+		c.Set("workspaceID", wid)
+		c.Set("workspaceRole", role)
+		c.Next()
+	}
+}
+
+// RequireWorkspaceRole admits members whose role in the current workspace
+// is at least role. It must run after Workspace.
+func RequireWorkspaceRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.WorkspaceRoleAtLeast(c.GetString("workspaceRole"), role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "requires the " + role + " role in this workspace"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
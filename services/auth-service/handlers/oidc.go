@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"auth-service/sso"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ssoFlowTTL bounds how long the user may spend at the provider.
+	ssoFlowTTL = 10 * time.Minute
+	// ssoStateCookie binds a flow to the browser that started it, so that
+	// nobody can complete their own login in someone else's browser.
+	ssoStateCookie = "sso_state"
+)
+
+type ssoFlow struct {
+	sso.Flow
+	provider string
+	userID   int64 // user linking a new identity; zero for logins
+	expires  time.Time
+}
+
+// ssoFlows holds flows between the redirect to the provider and the
+// callback, keyed by state.
+type ssoFlows struct {
+	mu      sync.Mutex
+	pending map[string]ssoFlow
+}
+
+func (fs *ssoFlows) put(f ssoFlow) {
+	now := time.Now()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for k, v := range fs.pending {
+		if now.After(v.expires) {
+			delete(fs.pending, k)
+		}
+	}
+	f.expires = now.Add(ssoFlowTTL)
+	fs.pending[f.State] = f
+}
+
+// take returns and forgets a flow, so each state is used once.
+func (fs *ssoFlows) take(state string) (ssoFlow, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.pending[state]
+	delete(fs.pending, state)
+	if !ok || time.Now().After(f.expires) {
+		return ssoFlow{}, false
+	}
+	return f, true
+}
+
+// SSO serves "Sign in with ..." through OpenID Connect providers. Like
+// passkey logins these do not ask for a TOTP code: the provider is trusted
+// to have authenticated the user, including any second factor it requires.
+type SSO struct {
+	db           *sqldb.DB
+	sessions     Sessions
+	verification *EmailVerification
+	providers    map[string]*sso.Provider
+	flows        *ssoFlows
+	// afterLogin, if set, is where the browser is sent once signed in;
+	// otherwise the callback answers with the usual login JSON.
+	afterLogin string
+}
+
+func NewSSO(db *sqldb.DB, sessions Sessions, verification *EmailVerification, providers []*sso.Provider, afterLogin string) *SSO {
+	byName := make(map[string]*sso.Provider, len(providers))
+	for _, p := range providers {
+		byName[p.Name] = p
+	}
+	return &SSO{
+		db:           db,
+		sessions:     sessions,
+		verification: verification,
+		providers:    byName,
+		flows:        &ssoFlows{pending: map[string]ssoFlow{}},
+		afterLogin:   afterLogin,
+	}
+}
+
+// begin starts a flow with the named provider and returns the URL to send
+// the browser to.
+func (s *SSO) begin(c *gin.Context, userID int64) (string, bool) {
+	p, ok := s.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return "", false
+	}
+	f := sso.NewFlow()
+	s.flows.put(ssoFlow{Flow: f, provider: p.Name, userID: userID})
+
+	cookie := s.sessions.Cookies.cookie(ssoStateCookie, f.State, int(ssoFlowTTL/time.Second), true)
+	// The callback is a top-level navigation from the provider's site,
+	// which Strict cookies would not survive.
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(c.Writer, cookie)
+	return p.AuthCodeURL(f), true
+}
+
+// Login redirects the browser to the provider: GET /login/oidc/:provider.
+func (s *SSO) Login() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if to, ok := s.begin(c, 0); ok {
+			c.Redirect(http.StatusFound, to)
+		}
+	}
+}
+
+// Link starts linking a provider to the signed-in user. It answers with
+// the URL to open rather than redirecting, since it is called from script.
+func (s *SSO) Link() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		if to, ok := s.begin(c, idVal.(int64)); ok {
+			c.JSON(http.StatusOK, gin.H{"authorization_url": to})
+		}
+	}
+}
+
+// Callback completes a login or link: GET /login/oidc/:provider/callback.
+func (s *SSO) Callback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := c.Query("state")
+		cookieState, _ := c.Cookie(ssoStateCookie)
+		http.SetCookie(c.Writer, s.sessions.Cookies.cookie(ssoStateCookie, "", -1, true))
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired sign-in attempt"})
+			return
+		}
+		f, ok := s.flows.take(state)
+		if !ok || f.provider != c.Param("provider") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired sign-in attempt"})
+			return
+		}
+		if e := c.Query("error"); e != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in was not completed: " + e})
+			return
+		}
+		p := s.providers[f.provider]
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		id, err := p.Exchange(ctx, c.Query("code"), f.Flow)
+		if err != nil {
+			log.Printf("sso %s: %v", p.Name, err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in failed"})
+			return
+		}
+
+		if f.userID != 0 {
+			s.link(c, ctx, f.userID, id)
+			return
+		}
+		s.login(c, ctx, p, id)
+	}
+}
+
+func (s *SSO) link(c *gin.Context, ctx context.Context, uid int64, id sso.Identity) {
+	err := models.LinkIdentity(ctx, s.db, uid, id.Provider, id.Subject, id.Email)
+	if errors.Is(err, models.ErrIdentityLinked) {
+		c.JSON(http.StatusConflict, gin.H{"error": "this account is already linked"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	auditUser(c, s.sessions.Audit, uid, models.AuditUserIdentityLink, gin.H{"provider": id.Provider})
+	if s.afterLogin != "" {
+		c.Redirect(http.StatusFound, s.afterLogin)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"linked": id.Provider})
+}
+
+// login finds the user for the identity: one already linked, an existing
+// account with the same verified address at a trusted provider, or else a
+// new account.
+func (s *SSO) login(c *gin.Context, ctx context.Context, p *sso.Provider, id sso.Identity) {
+	u, err := models.GetUserByIdentity(ctx, s.db, id.Provider, id.Subject)
+	linked := false
+	if errors.Is(err, sql.ErrNoRows) {
+		u, linked, err = s.firstLogin(ctx, p, id)
+	}
+	if errors.Is(err, errNoEmail) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the provider did not share an email address"})
+		return
+	}
+	if errors.Is(err, models.ErrEmailExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "an account with this email already exists; sign in and link " + p.Name + " from your profile"})
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	if linked {
+		auditUser(c, s.sessions.Audit, u.ID, models.AuditUserIdentityLink, gin.H{"provider": id.Provider})
+	}
+
+	if s.afterLogin == "" {
+		s.sessions.start(c, u, loginOIDC, false)
+		return
+	}
+	if refuseDisabled(c, u) {
+		return
+	}
+	signed, jti, err := s.sessions.sign(u, "", s.sessions.TTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	s.sessions.recordLogin(c, u, loginOIDC)
+	s.sessions.Cookies.setSessionCookies(c, signed, jti, int(s.sessions.TTL/time.Second))
+	c.Redirect(http.StatusFound, s.afterLogin)
+}
+
+var errNoEmail = errors.New("identity has no email")
+
+// firstLogin finds or makes the account for an identity seen for the first
+// time. It reports whether the identity was linked to an existing account.
+func (s *SSO) firstLogin(ctx context.Context, p *sso.Provider, id sso.Identity) (models.User, bool, error) {
+	if id.Email == "" {
+		return models.User{}, false, errNoEmail
+	}
+	u, _, err := models.GetUserByEmail(ctx, s.db, id.Email)
+	if err == nil {
+		if !p.TrustEmail || !id.EmailVerified {
+			return models.User{}, false, models.ErrEmailExists
+		}
+		if err := models.LinkIdentity(ctx, s.db, u.ID, id.Provider, id.Subject, id.Email); err != nil {
+			return models.User{}, false, err
+		}
+		return u, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, false, err
+	}
+	// The address counts as verified only if the provider is trusted to
+	// vouch for it; otherwise it is confirmed by mail as after a sign-up.
+	verified := p.TrustEmail && id.EmailVerified
+	u, err = models.CreateUserWithIdentity(ctx, s.db, id.Email, verified, id.Name, id.Provider, id.Subject)
+	if err != nil || verified {
+		return u, false, err
+	}
+	if _, err := s.verification.sendAsync(ctx, u); err != nil {
+		log.Printf("queue verification email for user %d: %v", u.ID, err)
+	}
+	return u, false, nil
+}
+
+func (s *SSO) ListIdentities() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		ids, err := models.ListIdentities(ctx, s.db, idVal.(int64))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load identities"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"identities": ids})
+	}
+}
+
+func (s *SSO) Unlink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid identity id"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		uid := idVal.(int64)
+		err = models.UnlinkIdentity(ctx, s.db, uid, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+			return
+		}
+		if errors.Is(err, models.ErrLastLoginMethod) {
+			c.JSON(http.StatusConflict, gin.H{"error": "set a password or add a passkey before unlinking your only sign-in method"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		auditUser(c, s.sessions.Audit, uid, models.AuditUserIdentityUnlink, gin.H{"identity_id": id})
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ceremonyTTL bounds how long a begun registration or login may take.
+const ceremonyTTL = 5 * time.Minute
+
+// ceremonies holds WebAuthn session data between the begin and finish steps
+// of a ceremony, keyed by an opaque id handed to the client.
+type ceremonies struct {
+	mu      sync.Mutex
+	pending map[string]ceremony
+}
+
+type ceremony struct {
+	data    webauthn.SessionData
+	userID  int64 // registering user; zero for logins
+	expires time.Time
+}
+
+func newCeremonies() *ceremonies {
+	return &ceremonies{pending: map[string]ceremony{}}
+}
+
+func (cs *ceremonies) put(data webauthn.SessionData, userID int64) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for k, v := range cs.pending {
+		if now.After(v.expires) {
+			delete(cs.pending, k)
+		}
+	}
+	cs.pending[id] = ceremony{data: data, userID: userID, expires: now.Add(ceremonyTTL)}
+	return id, nil
+}
+
+// take returns and forgets a ceremony, so each challenge is used once.
+func (cs *ceremonies) take(id string) (ceremony, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c, ok := cs.pending[id]
+	delete(cs.pending, id)
+	if !ok || time.Now().After(c.expires) {
+		return ceremony{}, false
+	}
+	return c, true
+}
+
+// Passkeys serves WebAuthn registration and login. Passkey logins are an
+// alternative to the password path and do not ask for a TOTP code: a
+// passkey with user verification is already two factors.
+type Passkeys struct {
+	db         *sqldb.DB
+	wa         *webauthn.WebAuthn
+	sessions   Sessions
+	ceremonies *ceremonies
+}
+
+func NewPasskeys(db *sqldb.DB, wa *webauthn.WebAuthn, sessions Sessions) *Passkeys {
+	return &Passkeys{db: db, wa: wa, sessions: sessions, ceremonies: newCeremonies()}
+}
+
+// RegisterBegin returns credential creation options for the signed-in user.
+func (p *Passkeys) RegisterBegin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		ctx := c.Request.Context()
+
+		user, err := models.GetPasskeyUser(ctx, p.db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		options, data, err := p.wa.BeginRegistration(user,
+			webauthn.WithExclusions(webauthn.Credentials(user.Credentials).CredentialDescriptors()))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		ceremonyID, err := p.ceremonies.put(*data, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ceremony_id": ceremonyID, "options": options})
+	}
+}
+
+// RegisterFinish verifies the authenticator's attestation and stores the
+// new credential. The body is the PublicKeyCredential as produced by
+// navigator.credentials.create; ceremony_id and an optional name are
+// passed as query parameters.
+func (p *Passkeys) RegisterFinish() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		cer, ok := p.ceremonies.take(c.Query("ceremony_id"))
+		if !ok || cer.userID != uid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown or expired ceremony"})
+			return
+		}
+		name := strings.TrimSpace(c.Query("name"))
+		if name == "" {
+			name = "Passkey"
+		}
+		if len(name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must be at most 100 characters"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		user, err := models.GetPasskeyUser(ctx, p.db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		cred, err := p.wa.FinishRegistration(user, cer.data, c.Request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "passkey registration failed"})
+			return
+		}
+
+		pk, err := models.InsertWebAuthnCredential(ctx, p.db, uid, name, cred)
+		if errors.Is(err, models.ErrPasskeyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "passkey already registered"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		auditUser(c, p.sessions.Audit, uid, models.AuditUserPasskeyAdd, gin.H{"passkey_id": pk.ID, "name": pk.Name})
+		c.JSON(http.StatusCreated, pk)
+	}
+}
+
+// LoginBegin starts a discoverable-credential login: the browser offers
+// whichever passkeys it holds for this site, so no email is needed.
+func (p *Passkeys) LoginBegin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, data, err := p.wa.BeginDiscoverableLogin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		ceremonyID, err := p.ceremonies.put(*data, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ceremony_id": ceremonyID, "options": options})
+	}
+}
+
+// LoginFinish verifies the assertion and starts a session. The body is the
+// PublicKeyCredential from navigator.credentials.get; ceremony_id and
+// return_token are query parameters.
+func (p *Passkeys) LoginFinish() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cer, ok := p.ceremonies.take(c.Query("ceremony_id"))
+		if !ok || cer.userID != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown or expired ceremony"})
+			return
+		}
+		returnToken, _ := strconv.ParseBool(c.Query("return_token"))
+
+		ctx := c.Request.Context()
+
+		lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+			return models.GetPasskeyUserByHandle(ctx, p.db, userHandle)
+		}
+		user, cred, err := p.wa.FinishPasskeyLogin(lookup, cer.data, c.Request)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		if cred.Authenticator.CloneWarning {
+			// The signature counter went backwards: the authenticator may
+			// have been cloned. Refuse rather than guess.
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		if err := models.UpdateWebAuthnCredentialUse(ctx, p.db, cred); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		p.sessions.start(c, user.(*models.PasskeyUser).User, loginPasskey, returnToken)
+	}
+}
+
+func (p *Passkeys) List() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		ctx := c.Request.Context()
+
+		pks, err := models.ListPasskeys(ctx, p.db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load passkeys"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"passkeys": pks})
+	}
+}
+
+func (p *Passkeys) Delete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid passkey id"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		err = models.DeletePasskey(ctx, p.db, uid, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+			return
+		}
+		if errors.Is(err, models.ErrLastLoginMethod) {
+			c.JSON(http.StatusConflict, gin.H{"error": "set a password or add another passkey before deleting your only sign-in method"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		auditUser(c, p.sessions.Audit, uid, models.AuditUserPasskeyDelete, gin.H{"passkey_id": id})
+		c.Status(http.StatusNoContent)
+	}
+}
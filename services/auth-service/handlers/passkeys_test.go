@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"auth-service/handlers/middleware"
+	"auth-service/mailer"
+	"auth-service/models"
+	"auth-service/store"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "auth.test"
+	testOrigin = "https://auth.test"
+)
+
+var b64 = base64.RawURLEncoding
+
+// authenticator is a software passkey: a P-256 key that answers
+// registrations with "none" attestation and signs logins, always with the
+// user present and verified.
+type authenticator struct {
+	t         *testing.T
+	key       *ecdsa.PrivateKey
+	id        []byte
+	handle    string // the user handle, as base64url from the options
+	signCount uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &authenticator{t: t, key: key, id: id}
+}
+
+// ceremonyOptions is the body of a begin response.
+type ceremonyOptions struct {
+	CeremonyID string `json:"ceremony_id"`
+	Options    struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	} `json:"options"`
+}
+
+func (a *authenticator) clientData(typ, challenge string) []byte {
+	b, err := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": testOrigin})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return b
+}
+
+// authData is the authenticator data, with the credential and its public
+// key when attested is set.
+func (a *authenticator) authData(attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		pub, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+			PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+			Curve:         1, // P-256
+			XCoord:        a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+			YCoord:        a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+		})
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, pub...)
+	}
+	return data
+}
+
+// create answers a registration as navigator.credentials.create would.
+func (a *authenticator) create(opts ceremonyOptions) string {
+	a.handle = opts.Options.PublicKey.User.ID
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(true),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return a.credential(map[string]string{
+		"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", opts.Options.PublicKey.Challenge)),
+		"attestationObject": b64.EncodeToString(attestation),
+	})
+}
+
+// get answers a login as navigator.credentials.get would, signing with
+// key, which is normally the authenticator's own.
+func (a *authenticator) get(opts ceremonyOptions, key *ecdsa.PrivateKey) string {
+	a.signCount++
+	authData := a.authData(false)
+	clientData := a.clientData("webauthn.get", opts.Options.PublicKey.Challenge)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return a.credential(map[string]string{
+		"clientDataJSON":    b64.EncodeToString(clientData),
+		"authenticatorData": b64.EncodeToString(authData),
+		"signature":         b64.EncodeToString(sig),
+		"userHandle":        a.handle,
+	})
+}
+
+func (a *authenticator) credential(response map[string]string) string {
+	b, err := json.Marshal(map[string]any{
+		"id":       b64.EncodeToString(a.id),
+		"rawId":    b64.EncodeToString(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestPasskeysSQLite registers passkeys with a software authenticator,
+// logs in with them and deletes them, and tries each step with a missing,
+// foreign or forged piece.
+func TestPasskeysSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	sessions := testSessions(t)
+	sessions.Audit = models.NewAuditLog(db)
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: make(mailbox, 10)}, "http://auth.test", revocations, passwords)
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "Test",
+		RPOrigins:     []string{testOrigin},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkeys := NewPasskeys(db, wa, sessions)
+
+	r := gin.New()
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	r.GET("/me", authMW, MeHandler(stores))
+	r.POST("/login/passkey/begin", passkeys.LoginBegin())
+	r.POST("/login/passkey/finish", passkeys.LoginFinish())
+	pg := r.Group("/passkeys", authMW)
+	pg.POST("/register/begin", passkeys.RegisterBegin())
+	pg.POST("/register/finish", passkeys.RegisterFinish())
+	pg.GET("", passkeys.List())
+	pg.DELETE("/:id", passkeys.Delete())
+
+	for _, email := range []string{"pat@example.com", "quin@example.com"} {
+		if w := serve(r, "POST", "/register", Request{Email: email, Password: strongPassword}, nil); w.Code != http.StatusCreated {
+			t.Fatalf("register %s: %d %s", email, w.Code, w.Body)
+		}
+	}
+	pat, quin := bearer(login(t, r, "pat@example.com")), bearer(login(t, r, "quin@example.com"))
+	u, _, err := models.GetUserByEmail(ctx, db, "pat@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	begin := func(path string, header http.Header) ceremonyOptions {
+		t.Helper()
+		w := serve(r, "POST", path, nil, header)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", path, w.Code, w.Body)
+		}
+		return decode[ceremonyOptions](t, w)
+	}
+	finish := func(path string, opts ceremonyOptions, body string, header http.Header) *httptest.ResponseRecorder {
+		return serve(r, "POST", path+"?ceremony_id="+opts.CeremonyID+"&return_token=true", body, header)
+	}
+
+	// Registration.
+	phone := newAuthenticator(t)
+	if w := finish("/passkeys/register/finish", ceremonyOptions{}, phone.create(ceremonyOptions{}), pat); w.Code != http.StatusBadRequest {
+		t.Errorf("register without a ceremony: %d, want 400", w.Code)
+	}
+	opts := begin("/passkeys/register/begin", quin)
+	if w := finish("/passkeys/register/finish", opts, phone.create(opts), pat); w.Code != http.StatusBadRequest {
+		t.Errorf("register with another user's ceremony: %d, want 400", w.Code)
+	}
+	opts = begin("/passkeys/register/begin", pat)
+	forged := opts
+	forged.Options.PublicKey.Challenge = b64.EncodeToString([]byte("another challenge"))
+	if w := finish("/passkeys/register/finish", opts, phone.create(forged), pat); w.Code != http.StatusBadRequest {
+		t.Errorf("register answering another challenge: %d, want 400", w.Code)
+	}
+	opts = begin("/passkeys/register/begin", pat)
+	w := finish("/passkeys/register/finish", opts, phone.create(opts), pat)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	phoneID := decode[models.Passkey](t, w).ID
+	if w := finish("/passkeys/register/finish", opts, phone.create(opts), pat); w.Code != http.StatusBadRequest {
+		t.Errorf("register reusing a ceremony: %d, want 400", w.Code)
+	}
+
+	// Login.
+	if w := finish("/login/passkey/finish", ceremonyOptions{}, "{}", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("login without a ceremony: %d, want 400", w.Code)
+	}
+	opts = begin("/passkeys/register/begin", pat)
+	if w := finish("/login/passkey/finish", opts, phone.get(opts, phone.key), nil); w.Code != http.StatusBadRequest {
+		t.Errorf("login with a registration ceremony: %d, want 400", w.Code)
+	}
+	opts = begin("/login/passkey/begin", nil)
+	if w := finish("/login/passkey/finish", opts, phone.get(opts, newAuthenticator(t).key), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("login signed by another key: %d, want 401", w.Code)
+	}
+	opts = begin("/login/passkey/begin", nil)
+	w = finish("/login/passkey/finish", opts, phone.get(opts, phone.key), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	if got := serve(r, "GET", "/me", nil, bearer(decode[struct{ Token string }](t, w).Token)); got.Code != http.StatusOK {
+		t.Errorf("session from a passkey login: %d", got.Code)
+	}
+	phone.signCount--
+	opts = begin("/login/passkey/begin", nil)
+	if w := finish("/login/passkey/finish", opts, phone.get(opts, phone.key), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("login repeating the signature counter: %d, want 401", w.Code)
+	}
+
+	// Deletion.
+	laptop := newAuthenticator(t)
+	opts = begin("/passkeys/register/begin", pat)
+	w = finish("/passkeys/register/finish", opts, laptop.create(opts), pat)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register a second passkey: %d %s", w.Code, w.Body)
+	}
+	laptopID := decode[models.Passkey](t, w).ID
+	if _, err := db.ExecContext(ctx, `UPDATE users SET hashed_password = ? WHERE id = ?`, models.NoPasswordHash, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	deletes := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"bad id", "/passkeys/x", pat, http.StatusBadRequest},
+		{"another user's passkey", "/passkeys/" + strconv.FormatInt(phoneID, 10), quin, http.StatusNotFound},
+		{"one of two passkeys", "/passkeys/" + strconv.FormatInt(phoneID, 10), pat, http.StatusNoContent},
+		{"the only sign-in method", "/passkeys/" + strconv.FormatInt(laptopID, 10), pat, http.StatusConflict},
+	}
+	for _, tt := range deletes {
+		if w := serve(r, "DELETE", tt.path, nil, tt.header); w.Code != tt.want {
+			t.Errorf("delete %s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+	if pks, err := models.ListPasskeys(ctx, db, u.ID); err != nil || len(pks) != 1 || pks[0].ID != laptopID {
+		t.Errorf("passkeys left = %+v, %v", pks, err)
+	}
+
+	var audited int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(1) FROM audit_log WHERE user_id = ? AND action IN (?, ?)`,
+		u.ID, models.AuditUserPasskeyAdd, models.AuditUserPasskeyDelete).Scan(&audited); err != nil || audited != 3 {
+		t.Errorf("passkey audit entries = %d, %v; want 3", audited, err)
+	}
+}
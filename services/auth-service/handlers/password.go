@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"auth-service/mailer"
+	"auth-service/models"
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	resetTokenTTL = time.Hour
+	// resetInterval is the least time between two reset emails to the same
+	// user, so /password/forgot cannot be used to flood someone's inbox.
+	resetInterval = time.Minute
+)
+
+// PasswordReset lets a user who has lost their password set a new one
+// through a link sent to their email address.
+type PasswordReset struct {
+	db          *sqldb.DB
+	mailer      *mailer.Async
+	pageURL     string
+	revocations *models.RevocationStore
+	passwords   Passwords
+	audit       *models.AuditLog
+}
+
+// NewPasswordReset links to pageURL, the public URL of the page that asks
+// for the new password and posts it with the token to /password/reset.
+func NewPasswordReset(db *sqldb.DB, m *mailer.Async, pageURL string, revocations *models.RevocationStore, passwords Passwords, auditLog *models.AuditLog) *PasswordReset {
+	return &PasswordReset{
+		db:          db,
+		mailer:      m,
+		pageURL:     pageURL,
+		revocations: revocations,
+		passwords:   passwords,
+		audit:       auditLog,
+	}
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// Forgot mails a reset link if the email belongs to an account. It answers
+// 202 whether or not it does, and whether or not a mail went out, so the
+// endpoint reveals nothing about which addresses are registered.
+func (p *PasswordReset) Forgot() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ForgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+		if req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		if err := p.issue(ctx, req.Email); err != nil {
+			log.Printf("password reset: %v", err)
+		}
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// issue stores a reset token for the account with this email, if any, and
+// mails the link in the background.
+func (p *PasswordReset) issue(ctx context.Context, email string) error {
+	u, _, err := models.GetUserByEmail(ctx, p.db, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, hash, err := models.GenerateResetToken()
+	if err != nil {
+		return err
+	}
+	stored, err := models.InsertPasswordResetToken(ctx, p.db, u.ID, hash, time.Now().Add(resetTokenTTL), resetInterval)
+	if err != nil || !stored {
+		return err
+	}
+	link := p.pageURL + "?token=" + url.QueryEscape(token)
+
+	p.mailer.Go(mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Text: "Someone asked to reset the password for your account. To choose a new password, open this link:\n\n" + link +
+			"\n\nThe link expires in one hour and works once. If you did not ask for this, ignore this email; your password has not changed.\n",
+	}, func(err error) {
+		log.Printf("send password reset email to user %d: %v", u.ID, err)
+	})
+	return nil
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Reset sets a new password from a reset token and signs the user out
+// everywhere: whoever held the old password must not keep a session.
+func (p *PasswordReset) Reset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		if req.Token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		tokenHash := models.HashResetToken(req.Token)
+		u, err := models.GetPasswordResetUser(ctx, p.db, tokenHash)
+		if errors.Is(err, models.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if !p.passwords.checkNew(c, req.Password, u.Email) {
+			return
+		}
+
+		hashed, err := p.passwords.hash(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error when securing password"})
+			return
+		}
+
+		uid, cutoff, err := models.ResetPassword(ctx, p.db, tokenHash, hashed)
+		if errors.Is(err, models.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		p.revocations.Invalidated(uid, cutoff)
+
+		// The token stands in for a session: whoever held it acted as the
+		// user.
+		auditUser(c, p.audit, uid, models.AuditUserPasswordReset, nil)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"auth-service/handlers/middleware"
+	"auth-service/models"
+	"auth-service/sqldb"
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	// Embed the zone database so timezone validation does not depend on
+	// the host having one, which slim container images often lack.
+	_ "time/tzdata"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type ProfilePatch struct {
+	DisplayName  *string `json:"display_name"`
+	BaseCurrency *string `json:"base_currency"`
+	Timezone     *string `json:"timezone"`
+	WeekStart    *string `json:"week_start"`
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// UpdateProfileHandler applies the fields present in the body and returns
+// the updated profile. The email address is changed through /me/email.
+func UpdateProfileHandler(db *sqldb.DB, auditLog *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		var req ProfilePatch
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+
+		var u models.ProfileUpdate
+		if req.DisplayName != nil {
+			name := strings.TrimSpace(*req.DisplayName)
+			if utf8.RuneCountInString(name) > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "display_name must be at most 100 characters"})
+				return
+			}
+			u.DisplayName = &name
+		}
+		if req.BaseCurrency != nil {
+			cur := strings.ToUpper(strings.TrimSpace(*req.BaseCurrency))
+			if !currencyCode.MatchString(cur) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "base_currency must be a three-letter ISO 4217 code"})
+				return
+			}
+			u.BaseCurrency = &cur
+		}
+		if req.Timezone != nil {
+			tz := strings.TrimSpace(*req.Timezone)
+			if _, err := time.LoadLocation(tz); err != nil || tz == "" || tz == "Local" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA zone such as Europe/Berlin"})
+				return
+			}
+			u.Timezone = &tz
+		}
+		if req.WeekStart != nil {
+			d, ok := parseWeekday(strings.TrimSpace(*req.WeekStart))
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "week_start must be a day name such as monday"})
+				return
+			}
+			u.WeekStart = &d
+		}
+
+		ctx := c.Request.Context()
+
+		before, err := models.GetProfile(ctx, db, uid)
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		p, err := models.UpdateProfile(ctx, db, uid, u)
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, auditLog, models.AuditEntry{
+			UserID:     idRef(uid),
+			Action:     models.AuditUserProfileUpdate,
+			EntityType: models.AuditEntityUser,
+			EntityID:   auditID(uid),
+			Before:     profileResponse(before),
+			After:      profileResponse(p),
+		})
+		c.JSON(http.StatusOK, profileResponse(p))
+	}
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePasswordHandler sets a new password for the signed-in user and ends
+// every other session. The caller gets a fresh session in the same form as
+// their current one: cookies for browsers, a token for bearer clients.
+func ChangePasswordHandler(db *sqldb.DB, sessions Sessions, revocations *models.RevocationStore, passwords Passwords) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		var req ChangePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		if req.CurrentPassword == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "current_password is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		u, hash, err := models.GetUserByID(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if ok, _ := passwords.matches(hash, req.CurrentPassword); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		if !passwords.checkNew(c, req.NewPassword, u.Email) {
+			return
+		}
+
+		hashed, err := passwords.hash(req.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error when securing password"})
+			return
+		}
+		cutoff, err := models.ChangePassword(ctx, db, uid, hashed)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		revocations.Invalidated(uid, cutoff)
+
+		audit(c, sessions.Audit, models.AuditEntry{
+			UserID:     idRef(uid),
+			Action:     models.AuditUserPasswordChange,
+			EntityType: models.AuditEntityUser,
+			EntityID:   auditID(uid),
+		})
+		sessions.replace(c, u, cutoff, c.GetString("authMethod") == middleware.AuthMethodBearer)
+	}
+}
@@ -2,15 +2,13 @@ package handlers
 
 import (
 	"auth-service/models"
+	"auth-service/store"
 	"context"
-	"database/sql"
 	"errors"
 	"net/http"
 	"strings"
-	"time"
 	"log"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
 
@@ -20,7 +18,7 @@ type Request struct {
 }
 
 
-func NewHandler (db *sql.DB) gin.HandlerFunc {
+func NewHandler (users store.UserStore, verification *EmailVerification, passwords Passwords) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req Request
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -28,7 +26,6 @@ func NewHandler (db *sql.DB) gin.HandlerFunc {
 		return
 	}
 	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
-	req.Password = strings.TrimSpace(req.Password)
 
 	if req.Email == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
@@ -38,21 +35,19 @@ func NewHandler (db *sql.DB) gin.HandlerFunc {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
 		return
 	}
-	if len(req.Password) < 8{
-		c.JSON(http.StatusBadRequest, gin.H{"error": "password must be at least 8 characters"})
+	if !passwords.checkNew(c, req.Password, req.Email) {
 		return
 	}
-	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashed, err := passwords.hash(req.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error when securing password"})
 		return
 	}
 	req.Password = ""
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 3*time.Second)
-	defer cancel()
+	ctx := c.Request.Context()
 
-	u, err := models.InsertUser(ctx, db, req.Email, string(hashed))
+	u, err := users.CreateUser(ctx, req.Email, hashed)
 	log.Printf("handler err: %T | %v", err, err)
 	log.Printf("is duplicate? %v", errors.Is(err, models.ErrEmailExists))
 
@@ -73,7 +68,13 @@ func NewHandler (db *sql.DB) gin.HandlerFunc {
 
 
 
-	c.JSON(http.StatusCreated, gin.H{"id": u.ID, "email": u.Email, "created_at": u.CreatedAt })
+	// The account exists either way; if the email cannot be queued the user
+	// can ask for another from /verify/resend.
+	if _, err := verification.sendAsync(ctx, u); err != nil {
+		log.Printf("queue verification email for user %d: %v", u.ID, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": u.ID, "email": u.Email, "created_at": u.CreatedAt, "email_verified": false })
 	}
 
 	}
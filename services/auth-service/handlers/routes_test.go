@@ -0,0 +1,1211 @@
+package handlers
+
+import (
+	"auth-service/handlers/middleware"
+	"auth-service/keys"
+	"auth-service/mailer"
+	"auth-service/migrate"
+	"auth-service/migrations"
+	"auth-service/models"
+	"auth-service/password"
+	"auth-service/sqldb"
+	"auth-service/sso"
+	"auth-service/store"
+	"auth-service/totp"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// strongPassword passes password.DefaultPolicy.
+const strongPassword = "correct horse battery staple"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testPasswords hashes at the lowest bcrypt cost so that tests stay fast.
+func testPasswords() Passwords {
+	return Passwords{
+		Hasher: password.Hasher{Preferred: password.Bcrypt{Cost: bcrypt.MinCost}},
+		Policy: password.DefaultPolicy(),
+	}
+}
+
+func testSessions(t *testing.T) Sessions {
+	t.Helper()
+	ks, err := keys.New(keys.Config{Alg: keys.AlgHS256, Secret: []byte("test secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Sessions{Keys: ks, Cookies: CookieConfig{CSRFSecret: []byte("csrf secret")}, TTL: time.Hour}
+}
+
+// mailbox is a mailer.Mailer that hands every message to the test.
+type mailbox chan mailer.Message
+
+func (m mailbox) Send(ctx context.Context, msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+// serve sends a request to r. A non-nil body is encoded as JSON, unless it
+// is already a string, which is sent as is.
+func serve(r http.Handler, method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	switch b := body.(type) {
+	case nil:
+	case string:
+		buf.WriteString(b)
+	default:
+		json.NewEncoder(&buf).Encode(b)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decode unmarshals the response body into a value of type T.
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return v
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// testDB returns a migrated in-memory SQLite database.
+func testDB(t *testing.T) *sqldb.DB {
+	t.Helper()
+	db, err := sqldb.Open("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	runner, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// login signs in with strongPassword and returns the bearer token.
+func login(t *testing.T, r http.Handler, email string) string {
+	t.Helper()
+	w := serve(r, "POST", "/login", Login{Email: email, Password: strongPassword, ReturnToken: true}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	return decode[struct{ Token string }](t, w).Token
+}
+
+// TestRoutesSQLite wires the handlers as main does, with the real Auth and
+// Workspace middleware, on a migrated in-memory SQLite database.
+func TestRoutesSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	sessions := testSessions(t)
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := make(mailbox, 10)
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: mail}, "http://auth.test", revocations, passwords)
+
+	r := gin.New()
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	r.GET("/me", authMW, MeHandler(stores))
+	ag := r.Group("/analytics", authMW, middleware.Workspace(db))
+	ag.GET("/summary", AnalyticsSummary(stores))
+	ag.GET("/cashflow", AnalyticsCashflow(stores))
+	ag.GET("/budget", AnalyticsBudgets(stores))
+
+	if w := serve(r, "POST", "/register", Request{Email: "Dana@Example.com ", Password: strongPassword}, nil); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	// Passwords used to be trimmed, so a stray space still logs in, but
+	// must not replace the stored hash with the spaced form.
+	spaced := Login{Email: "dana@example.com", Password: " " + strongPassword + " ", ReturnToken: true}
+	if w := serve(r, "POST", "/login", spaced, nil); w.Code != http.StatusOK {
+		t.Errorf("login with surrounding spaces: %d %s", w.Code, w.Body)
+	}
+	token := login(t, r, "dana@example.com")
+
+	unauthorized := []struct {
+		name   string
+		header http.Header
+	}{
+		{"no token", nil},
+		{"malformed header", http.Header{"Authorization": {"Token " + token}}},
+		{"bad signature", bearer(token[:len(token)-2] + "xx")},
+		{"garbage", bearer("not-a-jwt")},
+	}
+	for _, path := range []string{"/me", "/analytics/summary", "/analytics/cashflow", "/analytics/budget"} {
+		for _, tt := range unauthorized {
+			if w := serve(r, "GET", path, nil, tt.header); w.Code != http.StatusUnauthorized {
+				t.Errorf("%s with %s: %d, want 401", path, tt.name, w.Code)
+			}
+		}
+	}
+
+	w := serve(r, "GET", "/me", nil, bearer(token))
+	if me := decode[map[string]any](t, w); w.Code != http.StatusOK || me["email"] != "dana@example.com" {
+		t.Errorf("/me: %d %s", w.Code, w.Body)
+	}
+
+	u, _, err := models.GetUserByEmail(ctx, db, "dana@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wid, _, err := models.GetDefaultWorkspace(ctx, db, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tx := range []struct {
+		date     string
+		amount   float64
+		category string
+	}{
+		{"2025-02-01", 2000, "Salary"},
+		{"2025-02-03", -60, "Groceries"},
+		{"2025-02-10", -25, "Mystery"},
+	} {
+		d, _ := time.Parse("2006-01-02", tx.date)
+		if _, err := db.ExecContext(ctx, `
+		INSERT INTO transactions (user_id, workspace_id, date, amount, merchant, category, import_id)
+		VALUES (?, ?, ?, ?, 'Shop', ?, ?)`, u.ID, wid, d, tx.amount, tx.category, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, `
+	INSERT INTO budgets (user_id, workspace_id, category_id, month, monthly_limit)
+	SELECT ?, ?, id, ?, 100 FROM categories WHERE name = 'Groceries'`, u.ID, wid, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(r, "GET", "/analytics/summary?from=2025-02-01&to=2025-02-28", nil, bearer(token))
+	if got := decode[SummaryResponse](t, w); w.Code != http.StatusOK || got.Totals != (store.Totals{Income: 2000, Expenses: -85, Net: 1915}) {
+		t.Errorf("summary: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "GET", "/analytics/cashflow?year=2025", nil, bearer(token))
+	if got := decode[CashflowResponse](t, w); w.Code != http.StatusOK || got.Months[1].Net != 1915 {
+		t.Errorf("cashflow: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "GET", "/analytics/budget?month=2025-02", nil, bearer(token))
+	if got := decode[BudgetResponse](t, w); w.Code != http.StatusOK || got.Totals.Limit != 100 || got.Totals.Spent != 85 || got.Totals.Over != 25 {
+		t.Errorf("budget: %d %s", w.Code, w.Body)
+	}
+
+	other := bearer(token)
+	other.Set(middleware.WorkspaceHeader, strconv.FormatInt(wid+100, 10))
+	if w := serve(r, "GET", "/analytics/summary", nil, other); w.Code != http.StatusNotFound {
+		t.Errorf("summary of someone else's workspace: %d, want 404", w.Code)
+	}
+}
+
+// TestSessionCutoffSQLite covers the ways a session ends: logout revokes
+// the one token, while /logout/all and a password change cut off every
+// token issued so far, including those issued in the same second.
+func TestSessionCutoffSQLite(t *testing.T) {
+	db := testDB(t)
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	sessions := testSessions(t)
+	sessions.Revocations = revocations
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := make(mailbox, 10)
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: mail}, "http://auth.test", revocations, passwords)
+	reset := NewPasswordReset(db, &mailer.Async{Mailer: mail}, "http://auth.test/reset", revocations, passwords, models.NewAuditLog(db))
+
+	r := gin.New()
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	r.GET("/me", authMW, MeHandler(stores))
+	r.POST("/logout", authMW, LogoutHandler(revocations, sessions.Cookies))
+	r.POST("/logout/all", authMW, LogoutAllHandler(revocations, sessions.Cookies))
+	r.POST("/me/password", authMW, ChangePasswordHandler(db, sessions, revocations, passwords))
+	r.POST("/password/forgot", reset.Forgot())
+	r.POST("/password/reset", reset.Reset())
+
+	if w := serve(r, "POST", "/register", Request{Email: "eve@example.com", Password: strongPassword}, nil); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	me := func(token string) int {
+		return serve(r, "GET", "/me", nil, bearer(token)).Code
+	}
+
+	loggedOut, kept := login(t, r, "eve@example.com"), login(t, r, "eve@example.com")
+	if w := serve(r, "POST", "/logout", nil, bearer(loggedOut)); w.Code != http.StatusNoContent {
+		t.Fatalf("logout: %d %s", w.Code, w.Body)
+	}
+	if got := me(loggedOut); got != http.StatusUnauthorized {
+		t.Errorf("token after logout: %d, want 401", got)
+	}
+	if got := me(kept); got != http.StatusOK {
+		t.Errorf("other token after logout: %d, want 200", got)
+	}
+
+	changer, other := login(t, r, "eve@example.com"), login(t, r, "eve@example.com")
+	newPassword := strongPassword + " again"
+	w := serve(r, "POST", "/me/password", ChangePasswordRequest{CurrentPassword: strongPassword, NewPassword: newPassword}, bearer(changer))
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password: %d %s", w.Code, w.Body)
+	}
+	replacement := decode[struct{ Token string }](t, w).Token
+	for name, token := range map[string]string{"changer": changer, "other": other, "kept": kept} {
+		if got := me(token); got != http.StatusUnauthorized {
+			t.Errorf("%s token after password change: %d, want 401", name, got)
+		}
+	}
+	if got := me(replacement); got != http.StatusOK {
+		t.Errorf("replacement token: %d, want 200", got)
+	}
+
+	if w := serve(r, "POST", "/logout/all", nil, bearer(replacement)); w.Code != http.StatusNoContent {
+		t.Fatalf("logout all: %d %s", w.Code, w.Body)
+	}
+	if got := me(replacement); got != http.StatusUnauthorized {
+		t.Errorf("token after logout all: %d, want 401", got)
+	}
+
+	// Logging in again straight away, within the cutoff's second, works.
+	w = serve(r, "POST", "/login", Login{Email: "eve@example.com", Password: newPassword, ReturnToken: true}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login after logout all: %d %s", w.Code, w.Body)
+	}
+	beforeReset := decode[struct{ Token string }](t, w).Token
+	if got := me(beforeReset); got != http.StatusOK {
+		t.Errorf("token from a login after logout all: %d, want 200", got)
+	}
+
+	// A reset by email cuts off every session too, and is audited.
+	if w := serve(r, "POST", "/password/forgot", ForgotPasswordRequest{Email: "eve@example.com"}, nil); w.Code != http.StatusAccepted {
+		t.Fatalf("forgot: %d %s", w.Code, w.Body)
+	}
+	// The verification email from sign-up may come first.
+	var link string
+	for link == "" {
+		select {
+		case m := <-mail:
+			if m.Subject == "Reset your password" {
+				link = m.Text[strings.Index(m.Text, "?token=")+len("?token="):]
+				link = link[:strings.IndexByte(link, '\n')]
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reset email was sent")
+		}
+	}
+	resetToken, err := url.QueryUnescape(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, "POST", "/password/reset", ResetPasswordRequest{Token: resetToken, Password: strongPassword}, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("reset: %d %s", w.Code, w.Body)
+	}
+	if got := me(beforeReset); got != http.StatusUnauthorized {
+		t.Errorf("token after password reset: %d, want 401", got)
+	}
+	if got := me(login(t, r, "eve@example.com")); got != http.StatusOK {
+		t.Errorf("token after logging in with the reset password: %d, want 200", got)
+	}
+	var audited int
+	if err := db.QueryRowContext(context.Background(), `SELECT COUNT(1) FROM audit_log WHERE action = ? AND actor_user_id = user_id`,
+		models.AuditUserPasswordReset).Scan(&audited); err != nil || audited != 1 {
+		t.Errorf("password reset audit entries = %d, %v; want 1", audited, err)
+	}
+}
+
+// TestAPIKeysSQLite creates API keys through the handlers and uses them
+// through the real Auth middleware.
+func TestAPIKeysSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	sessions := testSessions(t)
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: make(mailbox, 10)}, "http://auth.test", revocations, passwords)
+	auditLog := models.NewAuditLog(db)
+
+	r := gin.New()
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	kg := r.Group("/api-keys", authMW, middleware.SessionOnly())
+	kg.POST("", CreateAPIKeyHandler(db, auditLog))
+	kg.GET("", ListAPIKeysHandler(db))
+	kg.DELETE("/:id", RevokeAPIKeyHandler(db, auditLog))
+	r.GET("/analytics/summary", authMW, middleware.RequireScope(models.ScopeReadAnalytics), middleware.Workspace(db), AnalyticsSummary(stores))
+	r.GET("/admin/ping", authMW, middleware.RequireRole(models.RoleAdmin), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	if w := serve(r, "POST", "/register", Request{Email: "kim@example.com", Password: strongPassword}, nil); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	u, _, err := models.GetUserByEmail(ctx, db, "kim@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SetUserRole(ctx, db, u.ID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	session := bearer(login(t, r, "kim@example.com"))
+
+	type created struct {
+		Key    string
+		APIKey models.APIKey `json:"api_key"`
+	}
+	create := func(scopes ...string) created {
+		t.Helper()
+		w := serve(r, "POST", "/api-keys", CreateAPIKeyRequest{Name: "script", Scopes: scopes}, session)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create key: %d %s", w.Code, w.Body)
+		}
+		return decode[created](t, w)
+	}
+	reader := create(models.ScopeReadAnalytics)
+	writer := create(models.ScopeWriteTransactions)
+	revoked := create(models.ScopeReadAnalytics)
+	if w := serve(r, "DELETE", "/api-keys/"+strconv.FormatInt(revoked.APIKey.ID, 10), nil, session); w.Code != http.StatusNoContent {
+		t.Fatalf("revoke key: %d %s", w.Code, w.Body)
+	}
+	expiredKey, prefix, err := models.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if _, err := models.InsertAPIKey(ctx, db, u.ID, "old", prefix, models.HashAPIKey(expiredKey), []string{models.ScopeReadAnalytics}, &past); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"key with the scope", "GET", "/analytics/summary", bearer(reader.Key), http.StatusOK},
+		{"key without the scope", "GET", "/analytics/summary", bearer(writer.Key), http.StatusForbidden},
+		{"revoked key", "GET", "/analytics/summary", bearer(revoked.Key), http.StatusUnauthorized},
+		{"expired key", "GET", "/analytics/summary", bearer(expiredKey), http.StatusUnauthorized},
+		{"unknown key", "GET", "/analytics/summary", bearer(reader.Key + "x"), http.StatusUnauthorized},
+		{"key on a session-only route", "GET", "/api-keys", bearer(reader.Key), http.StatusForbidden},
+		{"key of an admin on an admin route", "GET", "/admin/ping", bearer(reader.Key), http.StatusForbidden},
+		{"session of an admin on an admin route", "GET", "/admin/ping", session, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if w := serve(r, tt.method, tt.path, nil, tt.header); w.Code != tt.want {
+			t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+
+	if err := models.SetUserDisabled(ctx, db, u.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, "GET", "/analytics/summary", nil, bearer(reader.Key)); w.Code != http.StatusUnauthorized {
+		t.Errorf("key of a disabled user: %d, want 401", w.Code)
+	}
+}
+
+// TestSecondFactorLockoutSQLite checks that wrong TOTP codes lock the
+// account like wrong passwords, however many pre-auth tokens they are
+// spread over.
+func TestSecondFactorLockoutSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	sessions := testSessions(t)
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(3, time.Hour, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: make(mailbox, 10)}, "http://auth.test", revocations, passwords)
+
+	r := gin.New()
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	r.POST("/login/2fa", LoginTOTPHandler(db, stores, sessions, revocations, guard))
+
+	if w := serve(r, "POST", "/register", Request{Email: "lee@example.com", Password: strongPassword}, nil); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	u, _, err := models.GetUserByEmail(ctx, db, "lee@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.SetPendingTOTPSecret(ctx, db, u.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.EnableTOTP(ctx, db, u.ID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	preAuth := func() string {
+		t.Helper()
+		w := serve(r, "POST", "/login", Login{Email: "lee@example.com", Password: strongPassword}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("password step: %d %s", w.Code, w.Body)
+		}
+		return decode[struct {
+			PreAuthToken string `json:"pre_auth_token"`
+		}](t, w).PreAuthToken
+	}
+	secondFactor := func(token, code string) int {
+		return serve(r, "POST", "/login/2fa", SecondFactorLogin{PreAuthToken: token, Code: code, ReturnToken: true}, nil).Code
+	}
+	rightCode := func() string {
+		code, err := totp.CodeAt(secret, totp.Counter(time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	failures := func() int {
+		var n int
+		if err := db.QueryRowContext(ctx, `SELECT failed_login_count FROM users WHERE id = ?`, u.ID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// A right code after a wrong one logs in and clears the failures.
+	token := preAuth()
+	if got := secondFactor(token, "abcdef"); got != http.StatusUnauthorized {
+		t.Fatalf("wrong code: %d", got)
+	}
+	if failures() != 1 {
+		t.Errorf("failures after a wrong code = %d, want 1", failures())
+	}
+	if got := secondFactor(token, rightCode()); got != http.StatusOK {
+		t.Fatalf("right code: %d", got)
+	}
+	if failures() != 0 {
+		t.Errorf("failures after logging in = %d, want 0", failures())
+	}
+
+	// A right password alone does not clear them.
+	token = preAuth()
+	for i := 0; i < 2; i++ {
+		if got := secondFactor(token, "abcdef"); got != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: %d", i+1, got)
+		}
+	}
+	token = preAuth()
+	if failures() != 2 {
+		t.Errorf("failures after a new password step = %d, want 2", failures())
+	}
+	if got := secondFactor(token, "abcdef"); got != http.StatusUnauthorized {
+		t.Fatalf("third wrong code: %d", got)
+	}
+
+	if got := secondFactor(token, rightCode()); got != http.StatusTooManyRequests {
+		t.Errorf("right code on a locked account: %d, want 429", got)
+	}
+	if w := serve(r, "POST", "/login", Login{Email: "lee@example.com", Password: strongPassword}, nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("password on a locked account: %d, want 429", w.Code)
+	}
+	// The fourth wrong code is the one that locked the account.
+	var recorded int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(1) FROM login_attempts WHERE user_id = ? AND reason = ?`,
+		u.ID, models.LoginReasonBadSecondFactor).Scan(&recorded); err != nil || recorded != 3 {
+		t.Errorf("recorded second-factor failures = %d, %v; want 3", recorded, err)
+	}
+}
+
+// TestSSOFirstLoginSQLite checks that a new account made from an external
+// identity takes the address as verified only from a trusted provider
+// that says it is, and otherwise mails a verification link.
+func TestSSOFirstLoginSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	sessions := testSessions(t)
+	mail := make(mailbox, 10)
+	verification := NewEmailVerification(db, store.NewSQL(db), sessions, &mailer.Async{Mailer: mail}, "http://auth.test", models.NewRevocationStore(db), testPasswords())
+	s := NewSSO(db, sessions, verification, nil, "")
+
+	tests := []struct {
+		name          string
+		trustEmail    bool
+		emailVerified bool
+		wantVerified  bool
+	}{
+		{"trusted provider, verified address", true, true, true},
+		{"untrusted provider, verified address", false, true, false},
+		{"trusted provider, unverified address", true, false, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := "sso" + strconv.Itoa(i) + "@example.com"
+			p := &sso.Provider{Config: sso.Config{Name: "corp", TrustEmail: tt.trustEmail}}
+			id := sso.Identity{Provider: "corp", Subject: "sub-" + strconv.Itoa(i), Email: email, EmailVerified: tt.emailVerified}
+			u, _, err := s.firstLogin(ctx, p, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var verified bool
+			if err := db.QueryRowContext(ctx, `SELECT email_verified FROM users WHERE id = ?`, u.ID).Scan(&verified); err != nil {
+				t.Fatal(err)
+			}
+			if verified != tt.wantVerified {
+				t.Errorf("email_verified = %v, want %v", verified, tt.wantVerified)
+			}
+
+			select {
+			case m := <-mail:
+				if tt.wantVerified || m.To != email || !strings.Contains(m.Text, "http://auth.test/verify?token=") {
+					t.Errorf("sent %+v", m)
+				}
+			case <-time.After(time.Second):
+				if !tt.wantVerified {
+					t.Error("no verification email was sent")
+				}
+			}
+		})
+	}
+}
+
+// TestAccountChangesAuditedSQLite makes changes to a user's sign-in
+// methods and address through the handlers and checks each is audited.
+func TestAccountChangesAuditedSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	auditLog := models.NewAuditLog(db)
+	sessions := testSessions(t)
+	sessions.Audit = auditLog
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := make(mailbox, 10)
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: mail}, "http://auth.test", revocations, passwords)
+	oidc := NewSSO(db, sessions, verification, nil, "")
+
+	r := gin.New()
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	r.GET("/verify", verification.Verify())
+	r.POST("/me/email", authMW, verification.ChangeEmail())
+	r.POST("/me/totp/enroll", authMW, TOTPEnrollHandler(db, "test"))
+	r.POST("/me/totp/confirm", authMW, TOTPConfirmHandler(db, auditLog))
+	r.POST("/me/totp/disable", authMW, TOTPDisableHandler(db, passwords, auditLog))
+	r.POST("/me/recovery-codes", authMW, RecoveryCodesRegenerateHandler(db, passwords, auditLog))
+	r.DELETE("/me/identities/:id", authMW, oidc.Unlink())
+
+	if w := serve(r, "POST", "/register", Request{Email: "fay@example.com", Password: strongPassword}, nil); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	<-mail // the sign-up's verification link
+	token := login(t, r, "fay@example.com")
+	u, _, err := models.GetUserByEmail(ctx, db, "fay@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(r, "POST", "/me/totp/enroll", nil, bearer(token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("enroll: %d %s", w.Code, w.Body)
+	}
+	secret := decode[struct{ Secret string }](t, w).Secret
+	// Each step must use a later time step than the one before, and all
+	// are accepted within the skew around now.
+	code := func(skew int64) string {
+		c, err := totp.CodeAt(secret, totp.Counter(time.Now())+skew)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	steps := []struct {
+		path string
+		body any
+	}{
+		{"/me/totp/confirm", TOTPCodeRequest{Code: code(-1)}},
+		{"/me/recovery-codes", TOTPReauthRequest{Password: strongPassword, Code: code(0)}},
+		{"/me/totp/disable", TOTPReauthRequest{Password: strongPassword, Code: code(1)}},
+	}
+	for _, s := range steps {
+		if w := serve(r, "POST", s.path, s.body, bearer(token)); w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", s.path, w.Code, w.Body)
+		}
+	}
+
+	if err := models.LinkIdentity(ctx, db, u.ID, "corp", "fay-at-corp", "fay@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := models.ListIdentities(ctx, db, u.ID)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("identities = %v, %v", ids, err)
+	}
+	if w := serve(r, "DELETE", "/me/identities/"+strconv.FormatInt(ids[0].ID, 10), nil, bearer(token)); w.Code != http.StatusNoContent {
+		t.Fatalf("unlink: %d %s", w.Code, w.Body)
+	}
+
+	// Let the change send a link although sign-up sent one moments ago.
+	if _, err := db.ExecContext(ctx, `UPDATE users SET verification_sent_at = NULL WHERE id = ?`, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, "POST", "/me/email", ChangeEmailRequest{Email: "fay@new.example", Password: strongPassword}, bearer(token)); w.Code != http.StatusAccepted {
+		t.Fatalf("change email: %d %s", w.Code, w.Body)
+	}
+	var link string
+	for i := 0; i < 2 && link == ""; i++ {
+		select {
+		case m := <-mail:
+			if m.To == "fay@new.example" {
+				link = m.Text[strings.Index(m.Text, "/verify?"):]
+				link = link[:strings.IndexByte(link, '\n')]
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no verification email was sent to the new address")
+		}
+	}
+	if w := serve(r, "GET", link, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+
+	entries, err := auditLog.List(ctx, models.AuditFilter{UserID: u.ID, Action: "user.", Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Action == models.AuditUserLogin {
+			continue
+		}
+		if e.ActorUserID == nil || *e.ActorUserID != u.ID {
+			t.Errorf("%s recorded with actor %v", e.Action, e.ActorUserID)
+		}
+		got = append(got, e.Action)
+	}
+	want := []string{
+		models.AuditUserTOTPEnable,
+		models.AuditUserRecoveryCodesRegenerate,
+		models.AuditUserTOTPDisable,
+		models.AuditUserIdentityUnlink,
+		models.AuditUserEmailChange,
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("audited %v, want %v", got, want)
+	}
+}
+
+// TestVerificationLinkSpansRotationSQLite opens a verification link the
+// day after the key that signed it was retired, with sessions much
+// shorter than the link.
+func TestVerificationLinkSpansRotationSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	ks, err := keys.New(keys.Config{
+		Alg:          keys.AlgEdDSA,
+		Dir:          t.TempDir(),
+		RotateEvery:  time.Minute,
+		RetainFor:    KeyRetention(time.Hour),
+		PublishAhead: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := testSessions(t)
+	sessions.Keys = ks
+	mail := make(mailbox, 10)
+	verification := NewEmailVerification(db, store.NewSQL(db), sessions, &mailer.Async{Mailer: mail}, "http://auth.test", models.NewRevocationStore(db), testPasswords())
+	r := gin.New()
+	r.GET("/verify", verification.Verify())
+
+	u, err := models.InsertUser(ctx, db, "gus@example.com", models.NoPasswordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verification.send(u); err != nil {
+		t.Fatal(err)
+	}
+	var link string
+	select {
+	case m := <-mail:
+		link = m.Text[strings.Index(m.Text, "/verify?"):]
+		link = link[:strings.IndexByte(link, '\n')]
+	case <-time.After(5 * time.Second):
+		t.Fatal("no verification email was sent")
+	}
+
+	// The key that signed the link retires when its successor starts
+	// signing, and is pruned as if 23 hours had passed since.
+	created := ks.Keys()[0].CreatedAt
+	for _, at := range []time.Time{created.Add(time.Minute), created.Add(2*time.Minute + 23*time.Hour)} {
+		if _, err := ks.Rotate(at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := serve(r, "GET", link, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("verify: %d %s", w.Code, w.Body)
+	}
+}
+
+// TestWorkspacesSQLite runs the workspace routes as main wires them: an
+// owner invites a member, the roles decide who may change the workspace,
+// and outsiders cannot see it.
+func TestWorkspacesSQLite(t *testing.T) {
+	db := testDB(t)
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	sessions := testSessions(t)
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := make(mailbox, 10)
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: mail}, "http://auth.test", revocations, passwords)
+	workspaces := NewWorkspaces(db, &mailer.Async{Mailer: mail}, "http://app.test/invites/accept", models.NewAuditLog(db))
+
+	r := gin.New()
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	ownerOnly := middleware.RequireWorkspaceRole(models.WorkspaceOwner)
+	wg := r.Group("/workspaces", authMW)
+	wg.GET("", workspaces.List())
+	wg.POST("", workspaces.Create())
+	wg.POST("/invites/accept", workspaces.Accept())
+	wsg := wg.Group("/:workspaceID", middleware.Workspace(db))
+	wsg.PATCH("", ownerOnly, workspaces.Rename())
+	wsg.GET("/members", workspaces.Members())
+	wsg.PUT("/members/:userID", ownerOnly, workspaces.SetMemberRole())
+	wsg.DELETE("/members/:userID", workspaces.RemoveMember())
+	wsg.GET("/invites", ownerOnly, workspaces.Invites())
+	wsg.POST("/invites", ownerOnly, workspaces.Invite())
+
+	users := map[string]models.User{}
+	tokens := map[string]http.Header{}
+	for _, name := range []string{"olga", "max", "nia"} {
+		email := name + "@example.com"
+		if w := serve(r, "POST", "/register", Request{Email: email, Password: strongPassword}, nil); w.Code != http.StatusCreated {
+			t.Fatalf("register %s: %d %s", name, w.Code, w.Body)
+		}
+		u, _, err := models.GetUserByEmail(context.Background(), db, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = u
+		tokens[name] = bearer(login(t, r, email))
+	}
+
+	w := serve(r, "POST", "/workspaces", WorkspaceRequest{Name: "Household"}, tokens["olga"])
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	base := "/workspaces/" + strconv.FormatInt(decode[models.Workspace](t, w).ID, 10)
+	member := func(name string) string { return base + "/members/" + strconv.FormatInt(users[name].ID, 10) }
+
+	if w := serve(r, "POST", base+"/invites", InviteRequest{Email: "max@example.com", Role: models.WorkspaceViewer}, tokens["olga"]); w.Code != http.StatusCreated {
+		t.Fatalf("invite: %d %s", w.Code, w.Body)
+	}
+	var invite string
+	for invite == "" {
+		select {
+		case m := <-mail:
+			if m.Subject == "You have been invited to share finances" {
+				invite = m.Text[strings.Index(m.Text, "?token=")+len("?token="):]
+				invite = invite[:strings.IndexByte(invite, '\n')]
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no invitation was sent")
+		}
+	}
+	if invite, err = url.QueryUnescape(invite); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, "POST", "/workspaces/invites/accept", AcceptInviteRequest{Token: invite}, tokens["nia"]); w.Code != http.StatusForbidden {
+		t.Errorf("accepting someone else's invitation: %d, want 403", w.Code)
+	}
+	w = serve(r, "POST", "/workspaces/invites/accept", AcceptInviteRequest{Token: invite}, tokens["max"])
+	if got := decode[models.Workspace](t, w); w.Code != http.StatusOK || got.Role != models.WorkspaceViewer {
+		t.Fatalf("accept: %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name   string
+		as     string
+		method string
+		path   string
+		body   any
+		want   int
+	}{
+		{"viewer lists members", "max", "GET", base + "/members", nil, http.StatusOK},
+		{"viewer renames", "max", "PATCH", base, WorkspaceRequest{Name: "Mine"}, http.StatusForbidden},
+		{"viewer invites", "max", "POST", base + "/invites", InviteRequest{Email: "nia@example.com"}, http.StatusForbidden},
+		{"viewer lists invitations", "max", "GET", base + "/invites", nil, http.StatusForbidden},
+		{"viewer promotes themselves", "max", "PUT", member("max"), MemberRoleRequest{Role: models.WorkspaceOwner}, http.StatusForbidden},
+		{"viewer removes the owner", "max", "DELETE", member("olga"), nil, http.StatusForbidden},
+		{"outsider lists members", "nia", "GET", base + "/members", nil, http.StatusNotFound},
+		{"outsider renames", "nia", "PATCH", base, WorkspaceRequest{Name: "Mine"}, http.StatusNotFound},
+		{"outsider removes a member", "nia", "DELETE", member("max"), nil, http.StatusNotFound},
+		{"owner sets an unknown role", "olga", "PUT", member("max"), MemberRoleRequest{Role: "admin"}, http.StatusBadRequest},
+		{"owner changes a non-member", "olga", "PUT", member("nia"), MemberRoleRequest{Role: models.WorkspaceEditor}, http.StatusNotFound},
+		{"only owner steps down", "olga", "PUT", member("olga"), MemberRoleRequest{Role: models.WorkspaceViewer}, http.StatusConflict},
+		{"only owner leaves", "olga", "DELETE", member("olga"), nil, http.StatusConflict},
+		{"owner makes the viewer an editor", "olga", "PUT", member("max"), MemberRoleRequest{Role: models.WorkspaceEditor}, http.StatusNoContent},
+		{"editor renames", "max", "PATCH", base, WorkspaceRequest{Name: "Mine"}, http.StatusForbidden},
+		{"owner renames", "olga", "PATCH", base, WorkspaceRequest{Name: "Home"}, http.StatusNoContent},
+		{"editor leaves", "max", "DELETE", member("max"), nil, http.StatusNoContent},
+		{"former member lists members", "max", "GET", base + "/members", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(r, tt.method, tt.path, tt.body, tokens[tt.as]); w.Code != tt.want {
+			t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+// TestAdminSQLite runs the admin API behind RequireRole as main does: a
+// plain user is refused, disabling a user ends their sessions, and an
+// administrator cannot lock themselves out.
+func TestAdminSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	auditLog := models.NewAuditLog(db)
+	sessions := testSessions(t)
+	sessions.Revocations = revocations
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: make(mailbox, 10)}, "http://auth.test", revocations, passwords)
+	admin := NewAdmin(db, revocations, auditLog)
+
+	r := gin.New()
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	r.GET("/me", authMW, MeHandler(stores))
+	adm := r.Group("/admin", authMW, middleware.RequireRole(models.RoleAdmin))
+	adm.GET("/users", admin.ListUsers())
+	adm.GET("/users/:id", admin.GetUser())
+	adm.POST("/users/:id/disable", admin.SetDisabled(true))
+	adm.POST("/users/:id/enable", admin.SetDisabled(false))
+	adm.PUT("/users/:id/role", admin.SetRole())
+	adm.POST("/users/:id/logout", admin.Logout())
+	adm.GET("/imports", admin.ListImports())
+	adm.GET("/audit", admin.Audit())
+
+	ids := map[string]string{}
+	for _, name := range []string{"ada", "bo", "cy"} {
+		email := name + "@example.com"
+		if w := serve(r, "POST", "/register", Request{Email: email, Password: strongPassword}, nil); w.Code != http.StatusCreated {
+			t.Fatalf("register %s: %d %s", name, w.Code, w.Body)
+		}
+		u, _, err := models.GetUserByEmail(ctx, db, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = strconv.FormatInt(u.ID, 10)
+	}
+	adaID, _ := strconv.ParseInt(ids["ada"], 10, 64)
+	if err := models.SetUserRole(ctx, db, adaID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	ada := bearer(login(t, r, "ada@example.com"))
+	bo := bearer(login(t, r, "bo@example.com"))
+	cy := bearer(login(t, r, "cy@example.com"))
+	if _, err := db.ExecContext(ctx, `INSERT INTO import_runs (user_id, source, rows_total) VALUES (?, 'march.csv', 3)`, ids["bo"]); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		header http.Header
+		want   int
+	}{
+		{"no session", "GET", "/admin/users", nil, nil, http.StatusUnauthorized},
+		{"plain user lists users", "GET", "/admin/users", nil, bo, http.StatusForbidden},
+		{"plain user disables a user", "POST", "/admin/users/" + ids["cy"] + "/disable", nil, bo, http.StatusForbidden},
+		{"plain user promotes themselves", "PUT", "/admin/users/" + ids["bo"] + "/role", SetRoleRequest{Role: models.RoleAdmin}, bo, http.StatusForbidden},
+		{"plain user reads the audit log", "GET", "/admin/audit", nil, bo, http.StatusForbidden},
+		{"admin lists users", "GET", "/admin/users?email=example.com", nil, ada, http.StatusOK},
+		{"admin lists users by an unknown role", "GET", "/admin/users?role=root", nil, ada, http.StatusBadRequest},
+		{"admin lists too many users", "GET", "/admin/users?limit=1000", nil, ada, http.StatusBadRequest},
+		{"admin reads a user", "GET", "/admin/users/" + ids["bo"], nil, ada, http.StatusOK},
+		{"admin reads a missing user", "GET", "/admin/users/999999", nil, ada, http.StatusNotFound},
+		{"admin disables themselves", "POST", "/admin/users/" + ids["ada"] + "/disable", nil, ada, http.StatusConflict},
+		{"admin demotes themselves", "PUT", "/admin/users/" + ids["ada"] + "/role", SetRoleRequest{Role: models.RoleUser}, ada, http.StatusConflict},
+		{"admin logs themselves out", "POST", "/admin/users/" + ids["ada"] + "/logout", nil, ada, http.StatusConflict},
+		{"admin sets an unknown role", "PUT", "/admin/users/" + ids["cy"] + "/role", SetRoleRequest{Role: "root"}, ada, http.StatusBadRequest},
+		{"admin disables a missing user", "POST", "/admin/users/999999/disable", nil, ada, http.StatusNotFound},
+		{"admin lists imports by an unknown status", "GET", "/admin/imports?status=lost", nil, ada, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := serve(r, tt.method, tt.path, tt.body, tt.header); w.Code != tt.want {
+			t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+	if got := serve(r, "GET", "/me", nil, ada).Code; got != http.StatusOK {
+		t.Errorf("admin session after the refused changes to themselves: %d, want 200", got)
+	}
+
+	// Disabling ends the user's sessions and refuses new ones until they
+	// are enabled again.
+	if w := serve(r, "POST", "/admin/users/"+ids["bo"]+"/disable", nil, ada); w.Code != http.StatusNoContent {
+		t.Fatalf("disable: %d %s", w.Code, w.Body)
+	}
+	if got := serve(r, "GET", "/me", nil, bo).Code; got != http.StatusUnauthorized {
+		t.Errorf("session of a disabled user: %d, want 401", got)
+	}
+	if w := serve(r, "POST", "/login", Login{Email: "bo@example.com", Password: strongPassword}, nil); w.Code != http.StatusForbidden {
+		t.Errorf("login of a disabled user: %d, want 403", w.Code)
+	}
+	if w := serve(r, "POST", "/admin/users/"+ids["bo"]+"/enable", nil, ada); w.Code != http.StatusNoContent {
+		t.Fatalf("enable: %d %s", w.Code, w.Body)
+	}
+	if got := serve(r, "GET", "/me", nil, bearer(login(t, r, "bo@example.com"))).Code; got != http.StatusOK {
+		t.Errorf("session after being enabled: %d, want 200", got)
+	}
+
+	// A new role applies from the next login; a forced logout ends it.
+	if w := serve(r, "PUT", "/admin/users/"+ids["cy"]+"/role", SetRoleRequest{Role: models.RoleAdmin}, ada); w.Code != http.StatusNoContent {
+		t.Fatalf("set role: %d %s", w.Code, w.Body)
+	}
+	if got := serve(r, "GET", "/me", nil, cy).Code; got != http.StatusUnauthorized {
+		t.Errorf("session from before a role change: %d, want 401", got)
+	}
+	cy = bearer(login(t, r, "cy@example.com"))
+	if got := serve(r, "GET", "/admin/users", nil, cy).Code; got != http.StatusOK {
+		t.Errorf("promoted user lists users: %d, want 200", got)
+	}
+	if w := serve(r, "POST", "/admin/users/"+ids["cy"]+"/logout", nil, ada); w.Code != http.StatusNoContent {
+		t.Fatalf("force logout: %d %s", w.Code, w.Body)
+	}
+	if got := serve(r, "GET", "/me", nil, cy).Code; got != http.StatusUnauthorized {
+		t.Errorf("session after a forced logout: %d, want 401", got)
+	}
+
+	w := serve(r, "GET", "/admin/imports?user_id="+ids["bo"], nil, ada)
+	if got := decode[struct{ Imports []models.ImportRun }](t, w); w.Code != http.StatusOK || len(got.Imports) != 1 || got.Imports[0].Source != "march.csv" {
+		t.Errorf("imports: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "GET", "/admin/audit?actor_user_id="+ids["ada"]+"&entity_type="+models.AuditEntityUser, nil, ada)
+	var actions []string
+	for _, e := range decode[struct{ Entries []models.AuditEntry }](t, w).Entries {
+		actions = append(actions, e.Action)
+	}
+	want := []string{models.AuditUserForceLogout, models.AuditUserRoleChange, models.AuditUserEnable, models.AuditUserDisable}
+	if w.Code != http.StatusOK || strings.Join(actions, " ") != strings.Join(want, " ") {
+		t.Errorf("audit: %d %v, want %v", w.Code, actions, want)
+	}
+}
+
+// TestLedgerSQLite edits transactions, budgets and categories through the
+// routes main registers and checks that each change shows up in the audit
+// log of the workspace, for every member, with who made it.
+func TestLedgerSQLite(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	stores := store.NewSQL(db)
+	revocations := models.NewRevocationStore(db)
+	auditLog := models.NewAuditLog(db)
+	sessions := testSessions(t)
+	passwords := testPasswords()
+	guard, err := NewLoginGuard(5, time.Minute, passwords.Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification := NewEmailVerification(db, stores, sessions, &mailer.Async{Mailer: make(mailbox, 10)}, "http://auth.test", revocations, passwords)
+	ledger := NewLedger(db)
+	admin := NewAdmin(db, revocations, auditLog)
+
+	r := gin.New()
+	r.Use(middleware.RequestID())
+	authMW := middleware.Auth(sessions.Keys, revocations, models.NewAPIKeyStore(db))
+	r.POST("/register", NewHandler(stores, verification, passwords))
+	r.POST("/login", AuthHandler(stores, sessions, guard, passwords))
+	r.GET("/audit", authMW, middleware.Workspace(db), ListAuditHandler(auditLog))
+	for _, lg := range []*gin.RouterGroup{r.Group("", authMW), r.Group("/workspaces/:workspaceID", authMW)} {
+		lg.Use(middleware.RequireScope(models.ScopeWriteTransactions), middleware.Workspace(db),
+			middleware.RequireWorkspaceRole(models.WorkspaceEditor))
+		lg.PATCH("/transactions/:id", ledger.UpdateTransaction())
+		lg.DELETE("/transactions/:id", ledger.DeleteTransaction())
+		lg.PUT("/budgets/:month/:category", ledger.SetBudget())
+		lg.DELETE("/budgets/:month/:category", ledger.DeleteBudget())
+	}
+	r.POST("/admin/categories/merge", authMW, middleware.RequireRole(models.RoleAdmin), admin.MergeCategories())
+
+	users := map[string]models.User{}
+	wids := map[string]int64{}
+	for _, name := range []string{"ivy", "jo"} {
+		email := name + "@example.com"
+		if w := serve(r, "POST", "/register", Request{Email: email, Password: strongPassword}, nil); w.Code != http.StatusCreated {
+			t.Fatalf("register %s: %d %s", name, w.Code, w.Body)
+		}
+		u, _, err := models.GetUserByEmail(ctx, db, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wids[name], _, err = models.GetDefaultWorkspace(ctx, db, u.ID); err != nil {
+			t.Fatal(err)
+		}
+		users[name] = u
+	}
+	if err := models.SetUserRole(ctx, db, users["ivy"].ID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `
+	INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)`, wids["ivy"], users["jo"].ID, models.WorkspaceViewer); err != nil {
+		t.Fatal(err)
+	}
+	ivy := bearer(login(t, r, "ivy@example.com"))
+	jo := bearer(login(t, r, "jo@example.com"))
+	key := func(scope string) http.Header {
+		k, prefix, err := models.GenerateAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := models.InsertAPIKey(ctx, db, users["ivy"].ID, scope, prefix, models.HashAPIKey(k), []string{scope}, nil); err != nil {
+			t.Fatal(err)
+		}
+		return bearer(k)
+	}
+	reader, writer := key(models.ScopeReadAnalytics), key(models.ScopeWriteTransactions)
+
+	txn := map[string]string{}
+	for _, name := range []string{"ivy", "jo"} {
+		output, returning := db.Dialect.Returning("INSERTED", "id")
+		var id int64
+		if err := db.QueryRowContext(ctx, `
+		INSERT INTO transactions (user_id, workspace_id, date, amount, merchant, category, import_id)
+		`+output+`
+		VALUES (?, ?, ?, -60, 'Market', 'Groceries', 'row-1')`+returning,
+			users[name].ID, wids[name], time.Date(2025, 2, 3, 0, 0, 0, 0, time.UTC)).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		txn[name] = "/transactions/" + strconv.FormatInt(id, 10)
+	}
+	base := "/workspaces/" + strconv.FormatInt(wids["ivy"], 10)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		header http.Header
+		want   int
+	}{
+		{"viewer edits a transaction", "PATCH", base + txn["ivy"], TransactionRequest{}, jo, http.StatusForbidden},
+		{"viewer sets a budget", "PUT", base + "/budgets/2025-02/Groceries", BudgetRequest{}, jo, http.StatusForbidden},
+		{"key without the scope", "PATCH", txn["ivy"], TransactionRequest{}, reader, http.StatusForbidden},
+		{"transaction of another workspace", "PATCH", txn["jo"], TransactionRequest{}, ivy, http.StatusNotFound},
+		{"invalid date", "PATCH", txn["ivy"], `{"date": "03/02/2025"}`, ivy, http.StatusBadRequest},
+		{"empty merchant", "PATCH", txn["ivy"], `{"merchant": " "}`, ivy, http.StatusBadRequest},
+		{"invalid month", "PUT", "/budgets/2025-13/Groceries", BudgetRequest{}, ivy, http.StatusBadRequest},
+		{"no limit", "PUT", "/budgets/2025-02/Groceries", BudgetRequest{}, ivy, http.StatusBadRequest},
+		{"negative limit", "PUT", "/budgets/2025-02/Groceries", `{"limit": -1}`, ivy, http.StatusBadRequest},
+		{"unknown category", "PUT", "/budgets/2025-02/Nowhere", `{"limit": 1}`, ivy, http.StatusNotFound},
+		{"missing budget", "DELETE", "/budgets/2025-02/Rent", nil, ivy, http.StatusNotFound},
+		{"plain user merges", "POST", "/admin/categories/merge", CategoryMergeRequest{From: "Dining", Into: "Groceries"}, jo, http.StatusForbidden},
+		{"merge into itself", "POST", "/admin/categories/merge", CategoryMergeRequest{From: "Dining", Into: "dining"}, ivy, http.StatusConflict},
+		{"merge an unknown category", "POST", "/admin/categories/merge", CategoryMergeRequest{From: "Nowhere", Into: "Dining"}, ivy, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(r, tt.method, tt.path, tt.body, tt.header); w.Code != tt.want {
+			t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+
+	w := serve(r, "PATCH", base+txn["ivy"], `{"amount": -65, "category": "Dining"}`, writer)
+	if got := decode[models.Transaction](t, w); w.Code != http.StatusOK || got.Amount != -65 || got.Category != "Dining" {
+		t.Fatalf("edit: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "PUT", "/budgets/2025-02/Groceries", `{"limit": 300}`, ivy)
+	if got := decode[models.Budget](t, w); w.Code != http.StatusOK || got.Limit != 300 || got.SetBy != users["ivy"].ID {
+		t.Fatalf("set budget: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "POST", "/admin/categories/merge", CategoryMergeRequest{From: "Dining", Into: "Groceries"}, ivy)
+	if got := decode[models.CategoryMerge](t, w); w.Code != http.StatusOK || got.Transactions != 1 {
+		t.Fatalf("merge: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "DELETE", txn["ivy"], nil, ivy); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, "DELETE", txn["ivy"], nil, ivy); w.Code != http.StatusNotFound {
+		t.Errorf("deleting again: %d, want 404", w.Code)
+	}
+
+	// The viewer reads the workspace's log too: the household can see who
+	// changed what.
+	header := jo.Clone()
+	header.Set(middleware.WorkspaceHeader, strconv.FormatInt(wids["ivy"], 10))
+	w = serve(r, "GET", "/audit", nil, header)
+	var actions []string
+	var edit models.AuditEntry
+	for _, e := range decode[struct{ Entries []models.AuditEntry }](t, w).Entries {
+		if e.WorkspaceID == nil || *e.WorkspaceID != wids["ivy"] {
+			t.Errorf("entry of another workspace: %+v", e)
+		}
+		actions = append(actions, e.Action)
+		if e.Action == models.AuditTransactionUpdate {
+			edit = e
+		}
+	}
+	want := []string{models.AuditTransactionDelete, models.AuditCategoryMerge, models.AuditBudgetSet, models.AuditTransactionUpdate}
+	if w.Code != http.StatusOK || strings.Join(actions, " ") != strings.Join(want, " ") {
+		t.Errorf("audit: %d %v, want %v", w.Code, actions, want)
+	}
+	if edit.ActorUserID == nil || *edit.ActorUserID != users["ivy"].ID || edit.ActorAPIKeyID == nil || edit.RequestID == "" {
+		t.Errorf("edit entry = %+v", edit)
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"auth-service/keys"
+	"auth-service/models"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenTypeMFA marks the short-lived token handed out between the password
+// and second-factor steps. middleware.Auth refuses any token with a typ
+// claim, so it cannot be used as a session.
+const tokenTypeMFA = "mfa"
+
+// tokenTypeVerifyEmail marks the token in an email verification link.
+const tokenTypeVerifyEmail = "verify_email"
+
+const preAuthTTL = 5 * time.Minute
+
+// KeyRetention is how long a retired signing key must stay available for
+// every token it signed to verify until it expires: the longer of a
+// session of sessionTTL and a verification link, with an hour to spare.
+func KeyRetention(sessionTTL time.Duration) time.Duration {
+	return max(sessionTTL, verifyEmailTTL) + time.Hour
+}
+
+// Sessions issues the signed tokens handed out by every login path.
+type Sessions struct {
+	Keys    *keys.KeySet
+	Cookies CookieConfig
+	TTL     time.Duration
+	// Audit, if set, records every login.
+	Audit *models.AuditLog
+	// Revocations, if set, is asked for the user's cutoff so that a login
+	// straight after one is not issued in the second it refuses.
+	Revocations *models.RevocationStore
+}
+
+// newTokenID returns a random identifier for the jti claim, which is what
+// logout and the revocation list refer to.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sign returns a token for u. typ is empty for session tokens.
+func (s Sessions) sign(u models.User, typ string, ttl time.Duration) (string, string, error) {
+	return s.signAt(u, typ, ttl, time.Now())
+}
+
+// signAt is sign with the token issued at now.
+func (s Sessions) signAt(u models.User, typ string, ttl time.Duration, now time.Time) (string, string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	claims := jwt.MapClaims{
+		"sub":   u.ID,
+		"email": u.Email,
+		"jti":   jti,
+		"iat":   now.Unix(),
+		"exp":   now.Add(ttl).Unix(),
+	}
+	if typ != "" {
+		claims["typ"] = typ
+	}
+	if u.Role != "" && u.Role != models.RoleUser {
+		claims["role"] = u.Role
+	}
+	signed, err := s.Keys.Sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+	return signed, jti, nil
+}
+
+// Login methods recorded in the audit log.
+const (
+	loginPassword = "password"
+	loginTOTP     = "totp"
+	loginPasskey  = "passkey"
+	loginOIDC     = "oidc"
+)
+
+// start signs a session for u and writes the login response: the token in
+// the body when the client asked for it, cookies otherwise. method is how
+// the user proved who they are.
+func (s Sessions) start(c *gin.Context, u models.User, method string, returnToken bool) {
+	var cutoff time.Time
+	if s.Revocations != nil && u.DisabledAt == nil {
+		var err error
+		cutoff, err = s.Revocations.ValidAfter(c.Request.Context(), u.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+	}
+	s.issue(c, u, cutoff, method, returnToken)
+}
+
+// replace is start for a session that replaces those cut off at cutoff,
+// as after a password change.
+func (s Sessions) replace(c *gin.Context, u models.User, cutoff time.Time, returnToken bool) {
+	s.issue(c, u, cutoff, "", returnToken)
+}
+
+// issue writes the response for start and replace. The cutoff refuses
+// tokens issued up to and including its second, so the session is issued
+// in the second after it if need be. method is empty when no new login was
+// made.
+func (s Sessions) issue(c *gin.Context, u models.User, cutoff time.Time, method string, returnToken bool) {
+	if refuseDisabled(c, u) {
+		return
+	}
+	now := time.Now()
+	if next := cutoff.Add(time.Second); !cutoff.IsZero() && now.Before(next) {
+		now = next
+	}
+	signed, jti, err := s.signAt(u, "", s.TTL, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	if method != "" {
+		s.recordLogin(c, u, method)
+	}
+
+	if returnToken {
+		c.JSON(http.StatusOK, gin.H{
+			"id":         u.ID,
+			"email":      u.Email,
+			"token":      signed,
+			"token_type": "Bearer",
+			"expires_in": int(s.TTL / time.Second),
+		})
+		return
+	}
+
+	csrf := s.Cookies.setSessionCookies(c, signed, jti, int(s.TTL/time.Second))
+	c.JSON(http.StatusOK, gin.H{"id": u.ID, "email": u.Email, "csrf_token": csrf})
+}
+
+func (s Sessions) recordLogin(c *gin.Context, u models.User, method string) {
+	audit(c, s.Audit, models.AuditEntry{
+		ActorUserID: idRef(u.ID),
+		UserID:      idRef(u.ID),
+		Action:      models.AuditUserLogin,
+		EntityType:  models.AuditEntityUser,
+		EntityID:    auditID(u.ID),
+		After:       gin.H{"method": method},
+	})
+}
+
+// refuseDisabled answers 403 and reports true if an administrator has
+// disabled u. Login paths call it once the user has proven who they are,
+// so the answer does not reveal anything to a stranger.
+func refuseDisabled(c *gin.Context, u models.User) bool {
+	if u.DisabledAt == nil {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "this account has been disabled"})
+	return true
+}
+
+var errBadTypedToken = errors.New("invalid token")
+
+// typedToken is a verified single-purpose token such as a pre-auth token.
+type typedToken struct {
+	UserID    int64
+	Email     string
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// parseTyped verifies a token signed by sign with the given typ.
+func (s Sessions) parseTyped(tokenString, typ string) (typedToken, error) {
+	token, err := s.Keys.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return typedToken{}, errBadTypedToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return typedToken{}, errBadTypedToken
+	}
+	if got, _ := claims["typ"].(string); got != typ {
+		return typedToken{}, errBadTypedToken
+	}
+	sub, ok := claims["sub"].(float64)
+	email, _ := claims["email"].(string)
+	jti, _ := claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if !ok || jti == "" || err != nil || exp == nil {
+		return typedToken{}, errBadTypedToken
+	}
+	return typedToken{UserID: int64(sub), Email: email, TokenID: jti, ExpiresAt: exp.Time}, nil
+}
+
+// parsePreAuth verifies a token issued by the password step.
+func (s Sessions) parsePreAuth(tokenString string) (typedToken, error) {
+	return s.parseTyped(tokenString, tokenTypeMFA)
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"auth-service/store"
+	"auth-service/totp"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSecondFactorAttempts is how many wrong codes one pre-auth token
+// tolerates before it is revoked and the password step must be repeated.
+const maxSecondFactorAttempts = 5
+
+// TOTPEnrollHandler starts enrollment: it stores a new, not yet enforced
+// secret and returns it along with the otpauth:// URI for the QR code.
+func TOTPEnrollHandler(db *sqldb.DB, issuer string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+		email := c.GetString("email")
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		stored, err := models.SetPendingTOTPSecret(ctx, db, uid, secret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if !stored {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":           secret,
+			"provisioning_uri": totp.ProvisioningURI(issuer, email, secret),
+		})
+	}
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTPConfirmHandler finishes enrollment once the user proves their app
+// produces valid codes, and returns the initial recovery codes.
+func TOTPConfirmHandler(db *sqldb.DB, auditLog *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		var req TOTPCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		secret, enabled, _, err := models.GetTOTP(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+		if secret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start enrollment first"})
+			return
+		}
+		counter, ok := totp.Validate(secret, req.Code, time.Now())
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		codes, hashes, err := models.GenerateRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if err := models.EnableTOTP(ctx, db, uid, counter, hashes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		auditUser(c, auditLog, uid, models.AuditUserTOTPEnable, nil)
+
+		c.JSON(http.StatusOK, gin.H{"enabled": true, "recovery_codes": codes})
+	}
+}
+
+type TOTPReauthRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// TOTPDisableHandler turns the second factor off. Because this weakens the
+// account it needs both the password and a current code.
+func TOTPDisableHandler(db *sqldb.DB, passwords Passwords, auditLog *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, ok := reauthSecondFactor(c, db, passwords)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		if err := models.DisableTOTP(ctx, db, uid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		auditUser(c, auditLog, uid, models.AuditUserTOTPDisable, nil)
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+	}
+}
+
+// RecoveryCodesRegenerateHandler replaces every recovery code, used or not.
+func RecoveryCodesRegenerateHandler(db *sqldb.DB, passwords Passwords, auditLog *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, ok := reauthSecondFactor(c, db, passwords)
+		if !ok {
+			return
+		}
+
+		codes, hashes, err := models.GenerateRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		if err := models.ReplaceRecoveryCodes(ctx, db, uid, hashes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		auditUser(c, auditLog, uid, models.AuditUserRecoveryCodesRegenerate, nil)
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// reauthSecondFactor checks the password and TOTP code in the request body
+// for the signed-in user, writing the error response itself on failure.
+func reauthSecondFactor(c *gin.Context, db *sqldb.DB, passwords Passwords) (int64, bool) {
+	idVal, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return 0, false
+	}
+	uid := idVal.(int64)
+
+	var req TOTPReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+		return 0, false
+	}
+	if req.Password == "" || strings.TrimSpace(req.Code) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password and code are required"})
+		return 0, false
+	}
+
+	ctx := c.Request.Context()
+
+	_, hash, err := models.GetUserByID(ctx, db, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return 0, false
+	}
+	if ok, _ := passwords.matches(hash, req.Password); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return 0, false
+	}
+
+	secret, enabled, _, err := models.GetTOTP(ctx, db, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return 0, false
+	}
+	if !enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+		return 0, false
+	}
+	counter, valid := totp.Validate(secret, req.Code, time.Now())
+	if valid {
+		valid, err = models.AdvanceTOTPCounter(ctx, db, uid, counter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return 0, false
+		}
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return 0, false
+	}
+	return uid, true
+}
+
+type SecondFactorLogin struct {
+	PreAuthToken string `json:"pre_auth_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	ReturnToken  bool   `json:"return_token"`
+}
+
+// LoginTOTPHandler completes a login that AuthHandler paused for the second
+// factor, accepting either a TOTP code or a recovery code. Wrong codes
+// count towards the guard's backoff and account lockout like wrong
+// passwords, and the account's failures are only cleared once a code is
+// right.
+func LoginTOTPHandler(db *sqldb.DB, users store.UserStore, sessions Sessions, revocations *models.RevocationStore, guard *LoginGuard) gin.HandlerFunc {
+	type attempts struct {
+		n         int
+		expiresAt time.Time
+	}
+	var mu sync.Mutex
+	failures := map[string]attempts{}
+
+	return func(c *gin.Context) {
+		var req SecondFactorLogin
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		if (req.Code == "") == (req.RecoveryCode == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "provide exactly one of code or recovery_code"})
+			return
+		}
+
+		pa, err := sessions.parsePreAuth(req.PreAuthToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired pre-auth token"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		revoked, err := revocations.IsRevoked(ctx, pa.TokenID, pa.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired pre-auth token"})
+			return
+		}
+
+		// The token names the email it was issued for; if that has changed
+		// since, the password step must be repeated.
+		u, _, lockedUntil, err := users.UserForLogin(ctx, pa.Email)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && u.ID != pa.UserID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired pre-auth token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		ip := c.ClientIP()
+		attempt := models.LoginAttempt{Email: u.Email, UserID: u.ID, IP: ip, UserAgent: c.Request.UserAgent()}
+		wait := guard.throttled(ip, u.Email)
+		if until := time.Until(lockedUntil); until > wait {
+			wait = until
+		}
+		if wait > 0 {
+			attempt.Reason = models.LoginReasonThrottled
+			guard.record(ctx, users, attempt)
+			setRetryAfter(c, wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+			return
+		}
+
+		var valid bool
+		if req.RecoveryCode != "" {
+			valid, err = models.UseRecoveryCode(ctx, db, u.ID, req.RecoveryCode)
+		} else {
+			secret, enabled, _, gerr := models.GetTOTP(ctx, db, u.ID)
+			err = gerr
+			if err == nil && enabled {
+				if counter, ok := totp.Validate(secret, req.Code, time.Now()); ok {
+					valid, err = models.AdvanceTOTPCounter(ctx, db, u.ID, counter)
+				}
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if !valid {
+			attempt.Reason = models.LoginReasonBadSecondFactor
+			guard.fail(ctx, users, attempt)
+
+			mu.Lock()
+			now := time.Now()
+			for id, a := range failures {
+				if now.After(a.expiresAt) {
+					delete(failures, id)
+				}
+			}
+			a := failures[pa.TokenID]
+			a.n++
+			a.expiresAt = pa.ExpiresAt
+			failures[pa.TokenID] = a
+			exhausted := a.n >= maxSecondFactorAttempts
+			if exhausted {
+				delete(failures, pa.TokenID)
+			}
+			mu.Unlock()
+			if exhausted {
+				if err := revocations.Revoke(ctx, pa.TokenID, u.ID, pa.ExpiresAt); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+					return
+				}
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		// Pre-auth tokens are single use.
+		mu.Lock()
+		delete(failures, pa.TokenID)
+		mu.Unlock()
+		if err := revocations.Revoke(ctx, pa.TokenID, u.ID, pa.ExpiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		attempt.Reason = loginTOTP
+		guard.succeed(ctx, users, attempt)
+		sessions.start(c, u, loginTOTP, req.ReturnToken)
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"auth-service/mailer"
+	"auth-service/models"
+	"auth-service/sqldb"
+	"auth-service/store"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	verifyEmailTTL = 24 * time.Hour
+	// resendInterval is the least time between two verification emails to
+	// the same user.
+	resendInterval = time.Minute
+)
+
+// EmailVerification sends and checks the links that confirm a user owns
+// their email address. Links carry a signed token naming the user and the
+// address; each is accepted once.
+type EmailVerification struct {
+	db          *sqldb.DB
+	users       store.UserStore
+	sessions    Sessions
+	mailer      *mailer.Async
+	baseURL     string
+	revocations *models.RevocationStore
+	passwords   Passwords
+}
+
+// NewEmailVerification builds links on baseURL, the public URL of this
+// service, e.g. https://auth.example.com.
+func NewEmailVerification(db *sqldb.DB, users store.UserStore, sessions Sessions, m *mailer.Async, baseURL string, revocations *models.RevocationStore, passwords Passwords) *EmailVerification {
+	return &EmailVerification{
+		db:          db,
+		users:       users,
+		sessions:    sessions,
+		mailer:      m,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		revocations: revocations,
+		passwords:   passwords,
+	}
+}
+
+// sendAsync claims the send slot for u and mails the link in the
+// background, so a slow mail server does not hold up the request. It
+// reports whether the resend throttle allowed a send.
+func (v *EmailVerification) sendAsync(ctx context.Context, u models.User) (bool, error) {
+	ok, err := v.users.ClaimVerificationSend(ctx, u.ID, resendInterval)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, v.send(u)
+}
+
+// send mails a verification link for u.Email in the background.
+func (v *EmailVerification) send(u models.User) error {
+	token, _, err := v.sessions.sign(u, tokenTypeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := v.baseURL + "/verify?token=" + url.QueryEscape(token)
+	v.mailAsync(u.ID, mailer.Message{
+		To:      u.Email,
+		Subject: "Confirm your email address",
+		Text: "Confirm your email address by opening this link:\n\n" + link +
+			"\n\nThe link expires in 24 hours. If you did not ask for this, ignore this email.\n",
+	})
+	return nil
+}
+
+func (v *EmailVerification) mailAsync(uid int64, m mailer.Message) {
+	v.mailer.Go(m, func(err error) {
+		log.Printf("send email to user %d: %v", uid, err)
+	})
+}
+
+// Verify handles the link from the email: GET /verify?token=...
+func (v *EmailVerification) Verify() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t, err := v.sessions.parseTyped(c.Query("token"), tokenTypeVerifyEmail)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification link"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		revoked, err := v.revocations.IsRevoked(ctx, t.TokenID, t.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification link"})
+			return
+		}
+
+		// Read the current address first, so that a link which changes it
+		// can be audited.
+		before, _, err := models.GetUserByID(ctx, v.db, t.UserID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		updated, err := models.MarkEmailVerified(ctx, v.db, t.UserID, t.Email)
+		if errors.Is(err, models.ErrEmailExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already exists"})
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if !updated {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification link"})
+			return
+		}
+		if err := v.revocations.Revoke(ctx, t.TokenID, t.UserID, t.ExpiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if before.Email != t.Email {
+			audit(c, v.sessions.Audit, models.AuditEntry{
+				ActorUserID: idRef(t.UserID),
+				UserID:      idRef(t.UserID),
+				Action:      models.AuditUserEmailChange,
+				EntityType:  models.AuditEntityUser,
+				EntityID:    auditID(t.UserID),
+				Before:      gin.H{"email": before.Email},
+				After:       gin.H{"email": t.Email},
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"email": t.Email, "email_verified": true})
+	}
+}
+
+// Resend mails a fresh link to the signed-in user, at most once per
+// resendInterval.
+func (v *EmailVerification) Resend() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		ctx := c.Request.Context()
+
+		u, _, err := models.GetUserByID(ctx, v.db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		verified, err := models.IsEmailVerified(ctx, v.db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if verified {
+			c.JSON(http.StatusConflict, gin.H{"error": "email is already verified"})
+			return
+		}
+
+		sent, err := v.sendAsync(ctx, u)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if !sent {
+			setRetryAfter(c, resendInterval)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "a verification email was sent recently, try again later"})
+			return
+		}
+		c.Status(http.StatusAccepted)
+	}
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// ChangeEmail starts moving the signed-in user to a new address. The
+// change only takes effect once the link sent to the new address is
+// opened; until then the old address stays in use and the new one is
+// shown as pending. The old address is told about the request.
+func (v *EmailVerification) ChangeEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+
+		var req ChangeEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+		if req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		u, hash, err := models.GetUserByID(ctx, v.db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if ok, _ := v.passwords.matches(hash, req.Password); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		if req.Email == u.Email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "that is already your email address"})
+			return
+		}
+
+		sent, err := models.SetPendingEmail(ctx, v.db, uid, req.Email, resendInterval)
+		if errors.Is(err, models.ErrEmailExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if !sent {
+			setRetryAfter(c, resendInterval)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "a verification email was sent recently, try again later"})
+			return
+		}
+
+		if err := v.send(models.User{ID: uid, Email: req.Email}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		v.mailAsync(uid, mailer.Message{
+			To:      u.Email,
+			Subject: "Your email address is being changed",
+			Text: "Someone signed in to your account asked to change its email address to " + req.Email +
+				". The change happens once the new address is confirmed.\n\nIf this was not you, reset your password now.\n",
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{"pending_email": req.Email})
+	}
+}
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"auth-service/mailer"
+	"auth-service/models"
+	"auth-service/sqldb"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const inviteTTL = 7 * 24 * time.Hour
+
+// Workspaces manages households: shared sets of finances with members in
+// owner, editor or viewer roles. Routes under /workspaces/:workspaceID run
+// behind middleware.Workspace, which checks membership.
+type Workspaces struct {
+	db        *sqldb.DB
+	mailer    *mailer.Async
+	acceptURL string
+	audit     *models.AuditLog
+}
+
+// NewWorkspaces links invitations to acceptURL, the public URL of the page
+// that posts the token to /workspaces/invites/accept for a signed-in user.
+func NewWorkspaces(db *sqldb.DB, m *mailer.Async, acceptURL string, auditLog *models.AuditLog) *Workspaces {
+	return &Workspaces{db: db, mailer: m, acceptURL: acceptURL, audit: auditLog}
+}
+
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+func bindWorkspaceName(c *gin.Context) (string, bool) {
+	var req WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+		return "", false
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return "", false
+	}
+	if len(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be at most 100 characters"})
+		return "", false
+	}
+	return name, true
+}
+
+func (w *Workspaces) List() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		ws, err := models.ListWorkspaces(ctx, w.db, idVal.(int64))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load workspaces"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"workspaces": ws})
+	}
+}
+
+func (w *Workspaces) Create() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		name, ok := bindWorkspaceName(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		ws, err := models.CreateWorkspace(ctx, w.db, idVal.(int64), name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, w.audit, models.AuditEntry{
+			WorkspaceID: idRef(ws.ID),
+			Action:      models.AuditWorkspaceCreate,
+			EntityType:  models.AuditEntityWorkspace,
+			EntityID:    auditID(ws.ID),
+			After:       ws,
+		})
+		c.JSON(http.StatusCreated, ws)
+	}
+}
+
+// Rename: PATCH /workspaces/:workspaceID, owners only.
+func (w *Workspaces) Rename() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, ok := bindWorkspaceName(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		wid := c.GetInt64("workspaceID")
+		if err := models.RenameWorkspace(ctx, w.db, wid, name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, w.audit, models.AuditEntry{
+			Action:     models.AuditWorkspaceRename,
+			EntityType: models.AuditEntityWorkspace,
+			EntityID:   auditID(wid),
+			After:      gin.H{"name": name},
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (w *Workspaces) Members() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		members, err := models.ListWorkspaceMembers(ctx, w.db, c.GetInt64("workspaceID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load members"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"members": members})
+	}
+}
+
+type MemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// SetMemberRole: PUT /workspaces/:workspaceID/members/:userID, owners only.
+func (w *Workspaces) SetMemberRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, err := strconv.ParseInt(c.Param("userID"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+		var req MemberRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		if !slices.Contains(models.WorkspaceRoles, req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role " + req.Role})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		wid := c.GetInt64("workspaceID")
+		before, err := models.GetWorkspaceRole(ctx, w.db, wid, uid)
+		if err == nil {
+			err = models.SetWorkspaceMemberRole(ctx, w.db, wid, uid, req.Role)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+			return
+		}
+		if errors.Is(err, models.ErrLastOwner) {
+			c.JSON(http.StatusConflict, gin.H{"error": "make another member an owner first"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, w.audit, models.AuditEntry{
+			UserID:     idRef(uid),
+			Action:     models.AuditWorkspaceMemberRole,
+			EntityType: models.AuditEntityWorkspaceMember,
+			EntityID:   auditID(uid),
+			Before:     gin.H{"role": before},
+			After:      gin.H{"role": req.Role},
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RemoveMember: DELETE /workspaces/:workspaceID/members/:userID. Owners
+// may remove anyone; everyone may remove themselves, i.e. leave.
+func (w *Workspaces) RemoveMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, err := strconv.ParseInt(c.Param("userID"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+		if uid != c.GetInt64("userID") && c.GetString("workspaceRole") != models.WorkspaceOwner {
+			c.JSON(http.StatusForbidden, gin.H{"error": "requires the owner role in this workspace"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		wid := c.GetInt64("workspaceID")
+		before, err := models.GetWorkspaceRole(ctx, w.db, wid, uid)
+		if err == nil {
+			err = models.RemoveWorkspaceMember(ctx, w.db, wid, uid)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+			return
+		}
+		if errors.Is(err, models.ErrLastOwner) {
+			c.JSON(http.StatusConflict, gin.H{"error": "make another member an owner first"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, w.audit, models.AuditEntry{
+			UserID:     idRef(uid),
+			Action:     models.AuditWorkspaceMemberRemove,
+			EntityType: models.AuditEntityWorkspaceMember,
+			EntityID:   auditID(uid),
+			Before:     gin.H{"role": before},
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+type InviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Invite mails a single-use invitation: POST
+// /workspaces/:workspaceID/invites, owners only. Inviting the same address
+// again replaces the earlier invitation.
+func (w *Workspaces) Invite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req InviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+		if req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+		if req.Role == "" {
+			req.Role = models.WorkspaceEditor
+		}
+		if !slices.Contains(models.WorkspaceRoles, req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role " + req.Role})
+			return
+		}
+		wid := c.GetInt64("workspaceID")
+
+		ctx := c.Request.Context()
+
+		members, err := models.ListWorkspaceMembers(ctx, w.db, wid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if slices.ContainsFunc(members, func(m models.WorkspaceMember) bool { return strings.EqualFold(m.Email, req.Email) }) {
+			c.JSON(http.StatusConflict, gin.H{"error": "already a member of this workspace"})
+			return
+		}
+
+		token, hash, err := models.GenerateInviteToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		inv, err := models.InsertWorkspaceInvite(ctx, w.db, wid, req.Email, req.Role, hash, c.GetInt64("userID"), time.Now().Add(inviteTTL))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		audit(c, w.audit, models.AuditEntry{
+			Action:     models.AuditWorkspaceInvite,
+			EntityType: models.AuditEntityWorkspaceInvite,
+			EntityID:   auditID(inv.ID),
+			After:      inv,
+		})
+
+		inviter := c.GetString("email")
+		link := w.acceptURL + "?token=" + url.QueryEscape(token)
+		w.mailer.Go(mailer.Message{
+			To:      req.Email,
+			Subject: "You have been invited to share finances",
+			Text: inviter + " invited you to their household as " + req.Role + ". To join, sign in or create an account with this address and open this link:\n\n" + link +
+				"\n\nThe link expires in 7 days. If you were not expecting this, ignore this email.\n",
+		}, func(err error) {
+			log.Printf("send workspace invite %d: %v", inv.ID, err)
+		})
+
+		c.JSON(http.StatusCreated, inv)
+	}
+}
+
+func (w *Workspaces) Invites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		invites, err := models.ListWorkspaceInvites(ctx, w.db, c.GetInt64("workspaceID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load invitations"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"invites": invites})
+	}
+}
+
+func (w *Workspaces) RevokeInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invitation id"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		err = models.DeleteWorkspaceInvite(ctx, w.db, c.GetInt64("workspaceID"), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, w.audit, models.AuditEntry{
+			Action:     models.AuditWorkspaceInviteRevoke,
+			EntityType: models.AuditEntityWorkspaceInvite,
+			EntityID:   auditID(id),
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+type AcceptInviteRequest struct {
+	Token string `json:"token"`
+}
+
+// Accept joins the signed-in user to the workspace an invitation is for.
+// The invitation must have been sent to the user's address.
+func (w *Workspaces) Accept() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idVal, ok := c.Get("userID")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		uid := idVal.(int64)
+		var req AcceptInviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+		if req.Token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		// The token's email claim may predate an address change.
+		u, _, err := models.GetUserByID(ctx, w.db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		ws, err := models.AcceptWorkspaceInvite(ctx, w.db, models.HashInviteToken(req.Token), uid, u.Email)
+		if errors.Is(err, models.ErrInvalidInvite) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired invitation"})
+			return
+		}
+		if errors.Is(err, models.ErrInviteEmail) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this invitation was sent to a different email address"})
+			return
+		}
+		if errors.Is(err, models.ErrAlreadyMember) {
+			c.JSON(http.StatusConflict, gin.H{"error": "already a member of this workspace"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		audit(c, w.audit, models.AuditEntry{
+			UserID:      idRef(uid),
+			WorkspaceID: idRef(ws.ID),
+			Action:      models.AuditWorkspaceJoin,
+			EntityType:  models.AuditEntityWorkspaceMember,
+			EntityID:    auditID(uid),
+			After:       gin.H{"role": ws.Role},
+		})
+		c.JSON(http.StatusOK, ws)
+	}
+}
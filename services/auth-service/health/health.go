@@ -0,0 +1,75 @@
+// Package health tracks the service's background jobs so that the
+// readiness probe can tell when one has stopped working.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MaxFailures is how many runs of a job in a row may fail before Check
+// does. A job that retries on its next run is not taken out of service for
+// a single blip, such as a reload that races a file being replaced.
+const MaxFailures = 3
+
+// Degraded is the error of a check that still passes, such as a job whose
+// last run failed but which has not yet failed MaxFailures times. The
+// readiness probe reports it without failing.
+type Degraded struct {
+	Err error
+}
+
+func (d *Degraded) Error() string { return d.Err.Error() }
+
+func (d *Degraded) Unwrap() error { return d.Err }
+
+// Worker is the heartbeat of a job that runs every Every. The job calls
+// Beat after each run; Check fails if runs have stopped coming or if the
+// last MaxFailures of them failed.
+type Worker struct {
+	every time.Duration
+	now   func() time.Time
+
+	mu       sync.Mutex
+	last     time.Time
+	err      error
+	failures int
+}
+
+// NewWorker tracks a job that runs every interval, starting now.
+func NewWorker(every time.Duration) *Worker {
+	return &Worker{every: every, now: time.Now, last: time.Now()}
+}
+
+// Beat records a run of the job and its outcome.
+func (w *Worker) Beat(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.last = w.now()
+	w.err = err
+	if err != nil {
+		w.failures++
+	} else {
+		w.failures = 0
+	}
+}
+
+// Check returns an error if there has been no run for two intervals or if
+// the last MaxFailures runs failed. After fewer failed runs it returns the
+// last error as *Degraded.
+func (w *Worker) Check(context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if since := w.now().Sub(w.last); since > 2*w.every {
+		return fmt.Errorf("no run for %s", since.Round(time.Second))
+	}
+	switch {
+	case w.failures >= MaxFailures:
+		return fmt.Errorf("%d runs failed in a row: %w", w.failures, w.err)
+	case w.failures > 0:
+		return &Degraded{Err: w.err}
+	}
+	return nil
+}
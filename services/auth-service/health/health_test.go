@@ -0,0 +1,55 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	w := NewWorker(time.Minute)
+	w.now = func() time.Time { return now }
+	w.last = now
+	ctx := context.Background()
+
+	if err := w.Check(ctx); err != nil {
+		t.Errorf("new worker: %v", err)
+	}
+	now = now.Add(90 * time.Second)
+	if err := w.Check(ctx); err != nil {
+		t.Errorf("one late run: %v", err)
+	}
+
+	// Failed runs are reported, but fail the check only MaxFailures in a
+	// row.
+	down := errors.New("database is down")
+	for i := 1; i < MaxFailures; i++ {
+		w.Beat(down)
+		var degraded *Degraded
+		if err := w.Check(ctx); !errors.As(err, &degraded) || !errors.Is(err, down) {
+			t.Errorf("after %d failed runs: %v, want it degraded", i, err)
+		}
+	}
+	w.Beat(down)
+	err := w.Check(ctx)
+	var degraded *Degraded
+	if err == nil || errors.As(err, &degraded) || err.Error() != "3 runs failed in a row: database is down" {
+		t.Errorf("after %d failed runs: %v", MaxFailures, err)
+	}
+	w.Beat(nil)
+	if err := w.Check(ctx); err != nil {
+		t.Errorf("after a good run: %v", err)
+	}
+	w.Beat(down)
+	if err := w.Check(ctx); !errors.As(err, &degraded) {
+		t.Errorf("a good run did not reset the count: %v", err)
+	}
+
+	now = now.Add(3 * time.Minute)
+	if err := w.Check(ctx); err == nil || errors.As(err, &degraded) || !strings.Contains(err.Error(), "no run for 3m0s") {
+		t.Errorf("stalled worker: %v", err)
+	}
+}
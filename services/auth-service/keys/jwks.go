@@ -0,0 +1,58 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is the public half of a signing key as published in the JWKS document
+// (RFC 7517). Only the members needed for RSA and Ed25519 are included.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every key that may still verify a live token. HMAC secrets
+// are never published, so an HS256 key set returns an empty document.
+func (ks *KeySet) JWKS() JWKS {
+	out := JWKS{Keys: []JWK{}}
+	for _, k := range ks.Keys() {
+		switch pub := k.Public().(type) {
+		case *rsa.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "RSA",
+				Kid: k.ID,
+				Alg: k.Alg,
+				Use: "sig",
+				N:   b64(pub.N.Bytes()),
+				E:   b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "OKP",
+				Kid: k.ID,
+				Alg: k.Alg,
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   b64(pub),
+			})
+		}
+	}
+	return out
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,410 @@
+// Package keys holds the keys used to sign and verify auth tokens.
+//
+// HS256 with a shared secret is still supported, but RS256 and EdDSA let
+// other services verify tokens from the public JWKS document without ever
+// holding signing material. Asymmetric keys live as PKCS#8 PEM files named
+// <kid>.pem in a directory, are rotated on a schedule, and old keys are kept
+// for verification until every token they signed has expired. A new key is
+// published for a while before it signs, so that other instances sharing
+// the directory, and JWKS consumers, know it by the time tokens carry it.
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type Config struct {
+	// Alg is one of AlgHS256, AlgRS256 or AlgEdDSA.
+	Alg string
+	// Secret is the HMAC secret, only used with AlgHS256.
+	Secret []byte
+	// Dir holds <kid>.pem private keys, only used with RS256/EdDSA.
+	Dir string
+	// RotateEvery is the age at which a new signing key is generated.
+	// Zero disables rotation.
+	RotateEvery time.Duration
+	// RetainFor is how long a retired key stays available for verification.
+	// It must be at least the token lifetime.
+	RetainFor time.Duration
+	// PublishAhead is how long a new key is published before it signs. It
+	// must be at least the interval at which instances reload the
+	// directory, and should cover how long JWKS consumers cache the keys.
+	PublishAhead time.Duration
+}
+
+type Key struct {
+	ID        string
+	Alg       string
+	CreatedAt time.Time
+
+	signer crypto.Signer
+	secret []byte
+}
+
+// Public returns the verification key, or nil for HMAC keys.
+func (k *Key) Public() crypto.PublicKey {
+	if k.signer == nil {
+		return nil
+	}
+	return k.signer.Public()
+}
+
+type KeySet struct {
+	cfg Config
+	now func() time.Time
+
+	mu   sync.RWMutex
+	keys []*Key // newest first
+}
+
+func New(cfg Config) (*KeySet, error) {
+	ks := &KeySet{cfg: cfg, now: time.Now}
+	switch cfg.Alg {
+	case AlgHS256:
+		if len(cfg.Secret) == 0 {
+			return nil, fmt.Errorf("HS256 requires a secret")
+		}
+		ks.keys = []*Key{{ID: "hs256", Alg: AlgHS256, secret: cfg.Secret}}
+		return ks, nil
+	case AlgRS256, AlgEdDSA:
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("%s requires a key directory", cfg.Alg)
+		}
+		if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := ks.Reload(); err != nil {
+			return nil, err
+		}
+		if _, err := ks.Rotate(time.Now()); err != nil {
+			return nil, err
+		}
+		return ks, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", cfg.Alg)
+	}
+}
+
+func (ks *KeySet) Alg() string { return ks.cfg.Alg }
+
+// Sign signs claims with the current key and stamps its kid in the header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k := ks.signingKey(ks.now())
+	if k == nil {
+		return "", ErrUnknownKey
+	}
+
+	token := jwt.NewWithClaims(signingMethod(k.Alg), claims)
+	token.Header["kid"] = k.ID
+	if k.secret != nil {
+		return token.SignedString(k.secret)
+	}
+	return token.SignedString(k.signer)
+}
+
+// Keyfunc resolves the verification key for a parsed token. It only accepts
+// the configured algorithm and, for asymmetric keys, a known kid.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	if t.Method.Alg() != ks.cfg.Alg {
+		return nil, fmt.Errorf("bad alg")
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if ks.cfg.Alg == AlgHS256 {
+		return ks.keys[0].secret, nil
+	}
+	kid, _ := t.Header["kid"].(string)
+	for _, k := range ks.keys {
+		if k.ID == kid {
+			return k.Public(), nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// Parse verifies tokenString against the key set.
+func (ks *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, ks.Keyfunc, jwt.WithValidMethods([]string{ks.cfg.Alg}))
+}
+
+// signingKey returns the newest key that has been published for
+// PublishAhead at now. Until there is one, as when the first key has just
+// been made, the oldest key signs.
+func (ks *KeySet) signingKey(now time.Time) *Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if len(ks.keys) == 0 {
+		return nil
+	}
+	for _, k := range ks.keys {
+		if now.Sub(k.CreatedAt) >= ks.cfg.PublishAhead {
+			return k
+		}
+	}
+	return ks.keys[len(ks.keys)-1]
+}
+
+// Keys returns a snapshot of the keys, newest first.
+func (ks *KeySet) Keys() []*Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	out := make([]*Key, len(ks.keys))
+	copy(out, ks.keys)
+	return out
+}
+
+// Reload re-reads the key directory, picking up keys written by other
+// instances sharing it.
+func (ks *KeySet) Reload() error {
+	if ks.cfg.Alg == AlgHS256 {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(ks.cfg.Dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	loaded := make([]*Key, 0, len(paths))
+	for _, p := range paths {
+		k, err := readKey(p)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", p, err)
+		}
+		if k.Alg != ks.cfg.Alg {
+			log.Printf("keys: skipping %s (%s key, want %s)", p, k.Alg, ks.cfg.Alg)
+			continue
+		}
+		loaded = append(loaded, k)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].CreatedAt.After(loaded[j].CreatedAt) })
+
+	ks.mu.Lock()
+	ks.keys = loaded
+	ks.mu.Unlock()
+	return nil
+}
+
+// Rotate generates a new key when there is none or the newest one is older
+// than RotateEvery, and drops retired keys past RetainFor. The new key
+// signs once it has been published for PublishAhead.
+func (ks *KeySet) Rotate(now time.Time) (bool, error) {
+	if ks.cfg.Alg == AlgHS256 {
+		return false, nil
+	}
+
+	ks.mu.RLock()
+	needNew := len(ks.keys) == 0 ||
+		(ks.cfg.RotateEvery > 0 && now.Sub(ks.keys[0].CreatedAt) >= ks.cfg.RotateEvery)
+	ks.mu.RUnlock()
+
+	if needNew {
+		k, err := generateKey(ks.cfg.Alg, now)
+		if err != nil {
+			return false, err
+		}
+		if err := writeKey(ks.cfg.Dir, k); err != nil {
+			return false, err
+		}
+		ks.mu.Lock()
+		ks.keys = append([]*Key{k}, ks.keys...)
+		ks.mu.Unlock()
+	}
+
+	ks.prune(now)
+	return needNew, nil
+}
+
+// prune removes keys that stopped signing more than RetainFor ago. A key
+// stops signing when its successor starts, PublishAhead after the
+// successor is created.
+func (ks *KeySet) prune(now time.Time) {
+	if ks.cfg.RetainFor <= 0 {
+		return
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if len(ks.keys) < 2 {
+		return
+	}
+
+	kept := ks.keys[:1]
+	for i := 1; i < len(ks.keys); i++ {
+		retiredAt := ks.keys[i-1].CreatedAt.Add(ks.cfg.PublishAhead)
+		if now.Sub(retiredAt) > ks.cfg.RetainFor {
+			os.Remove(filepath.Join(ks.cfg.Dir, ks.keys[i].ID+".pem"))
+			continue
+		}
+		kept = append(kept, ks.keys[i])
+	}
+	ks.keys = kept
+}
+
+// Run reloads and rotates keys every interval until ctx is done. It
+// passes the outcome of each round to report.
+func (ks *KeySet) Run(ctx context.Context, every time.Duration, report func(error)) {
+	if ks.cfg.Alg == AlgHS256 {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := ks.Reload(); err != nil {
+				log.Printf("keys: reload: %v", err)
+				report(err)
+				continue
+			}
+			rotated, err := ks.Rotate(now)
+			if err != nil {
+				log.Printf("keys: rotate: %v", err)
+				report(err)
+				continue
+			}
+			if rotated {
+				log.Printf("keys: rotated signing key, now %s", ks.Keys()[0].ID)
+			}
+			report(nil)
+		}
+	}
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func generateKey(alg string, now time.Time) (*Key, error) {
+	var signer crypto.Signer
+	switch alg {
+	case AlgRS256:
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		signer = k
+	case AlgEdDSA:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = k
+	default:
+		return nil, fmt.Errorf("cannot generate %s keys", alg)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	created := now.UTC().Truncate(time.Second)
+	kid := created.Format(kidTime) + "-" + hex.EncodeToString(suffix)
+	return &Key{ID: kid, Alg: alg, CreatedAt: created, signer: signer}, nil
+}
+
+// kidTime is the layout of the creation time that starts a generated kid.
+const kidTime = "20060102T150405Z"
+
+// createdAt reads the creation time from a generated kid. Keys named
+// otherwise fall back to the file's modification time.
+func createdAt(kid string, info os.FileInfo) time.Time {
+	prefix, _, _ := strings.Cut(kid, "-")
+	if t, err := time.Parse(kidTime, prefix); err == nil {
+		return t
+	}
+	return info.ModTime()
+}
+
+func readKey(path string) (*Key, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("expected a PKCS#8 PRIVATE KEY block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), ".pem")
+	k := &Key{ID: id, CreatedAt: createdAt(id, info)}
+	switch pk := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.Alg, k.signer = AlgRS256, pk
+	case ed25519.PrivateKey:
+		k.Alg, k.signer = AlgEdDSA, pk
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return k, nil
+}
+
+// writeKey stores k atomically so that instances reloading the directory
+// never see a half-written file.
+func writeKey(dir string, k *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := pem.Encode(tmp, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, k.ID+".pem")
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return os.Chtimes(path, k.CreatedAt, k.CreatedAt)
+}
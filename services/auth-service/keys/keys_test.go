@@ -0,0 +1,195 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newEdDSA(t *testing.T, dir string) *KeySet {
+	t.Helper()
+	ks, err := New(Config{
+		Alg:          AlgEdDSA,
+		Dir:          dir,
+		RotateEvery:  24 * time.Hour,
+		RetainFor:    2 * time.Hour,
+		PublishAhead: 10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func ids(keys []*Key) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = k.ID
+	}
+	return out
+}
+
+func fileExists(t *testing.T, dir, kid string) bool {
+	t.Helper()
+	_, err := os.Stat(filepath.Join(dir, kid+".pem"))
+	return err == nil
+}
+
+func TestRotatePublishesBeforeSigning(t *testing.T) {
+	dir := t.TempDir()
+	ks := newEdDSA(t, dir)
+	first := ks.Keys()[0]
+	if got := ks.signingKey(first.CreatedAt); got != first {
+		t.Fatal("the only key does not sign at once")
+	}
+
+	rotateAt := first.CreatedAt.Add(24 * time.Hour)
+	if rotated, err := ks.Rotate(rotateAt.Add(-time.Second)); err != nil || rotated {
+		t.Fatalf("rotated early: %v, %v", rotated, err)
+	}
+	if rotated, err := ks.Rotate(rotateAt); err != nil || !rotated {
+		t.Fatalf("did not rotate: %v, %v", rotated, err)
+	}
+	second := ks.Keys()[0]
+	if len(ks.Keys()) != 2 || second == first {
+		t.Fatalf("keys = %v", ids(ks.Keys()))
+	}
+	if got := ks.signingKey(rotateAt.Add(9 * time.Minute)); got != first {
+		t.Errorf("new key signs before it has been published for 10m")
+	}
+	if got := ks.signingKey(rotateAt.Add(10 * time.Minute)); got != second {
+		t.Errorf("new key does not sign after 10m")
+	}
+
+	// The first key retires when the second starts signing and is kept
+	// for RetainFor after that.
+	retiredAt := rotateAt.Add(10 * time.Minute)
+	ks.Rotate(retiredAt.Add(2 * time.Hour))
+	if len(ks.Keys()) != 2 || !fileExists(t, dir, first.ID) {
+		t.Errorf("pruned too early: %v", ids(ks.Keys()))
+	}
+	ks.Rotate(retiredAt.Add(2*time.Hour + time.Second))
+	if got := ids(ks.Keys()); len(got) != 1 || got[0] != second.ID || fileExists(t, dir, first.ID) {
+		t.Errorf("after RetainFor: keys = %v, file kept = %v", got, fileExists(t, dir, first.ID))
+	}
+}
+
+func TestReloadSharesKeysAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+	a := newEdDSA(t, dir)
+	b := newEdDSA(t, dir)
+	if got, want := ids(b.Keys()), ids(a.Keys()); len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("b made its own key: %v, a has %v", got, want)
+	}
+
+	rotateAt := a.Keys()[0].CreatedAt.Add(24 * time.Hour)
+	if _, err := a.Rotate(rotateAt); err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{"sub": 1}
+
+	// Until b reloads, it knows only the old key, which a still signs with.
+	a.now = func() time.Time { return rotateAt }
+	token, err := a.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Parse(token); err != nil {
+		t.Errorf("b rejects a token a signed before the new key was due: %v", err)
+	}
+
+	a.now = func() time.Time { return rotateAt.Add(10 * time.Minute) }
+	token, err = a.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Parse(token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("b verified a token under a key it has not loaded: %v", err)
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Parse(token); err != nil {
+		t.Errorf("b rejects a token under the new key after reloading: %v", err)
+	}
+	if got, want := ids(b.Keys()), ids(a.Keys()); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("b has %v, a has %v", got, want)
+	}
+}
+
+func TestCreatedAtComesFromKid(t *testing.T) {
+	dir := t.TempDir()
+	ks := newEdDSA(t, dir)
+	k := ks.Keys()[0]
+
+	// A copy or a restore from backup resets the modification time.
+	later := time.Now().Add(72 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, k.ID+".pem"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ks.Keys()[0].CreatedAt; !got.Equal(k.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v from the kid", got, k.CreatedAt)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	t.Run("EdDSA", func(t *testing.T) {
+		ks := newEdDSA(t, t.TempDir())
+		k := ks.Keys()[0]
+		doc := ks.JWKS()
+		if len(doc.Keys) != 1 {
+			t.Fatalf("keys = %+v", doc.Keys)
+		}
+		jwk := doc.Keys[0]
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Kid != k.ID || jwk.Alg != AlgEdDSA || jwk.Use != "sig" ||
+			!ed25519.PublicKey(x).Equal(k.Public()) || jwk.N != "" {
+			t.Errorf("jwk = %+v", jwk)
+		}
+	})
+
+	t.Run("RS256", func(t *testing.T) {
+		ks, err := New(Config{Alg: AlgRS256, Dir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := ks.Keys()[0]
+		jwk := ks.JWKS().Keys[0]
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if jwk.Kty != "RSA" || jwk.Kid != k.ID || jwk.Alg != AlgRS256 || !pub.Equal(k.Public()) || jwk.X != "" {
+			t.Errorf("jwk = %+v", jwk)
+		}
+	})
+
+	t.Run("HS256 publishes nothing", func(t *testing.T) {
+		ks, err := New(Config{Alg: AlgHS256, Secret: []byte("secret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc := ks.JWKS(); doc.Keys == nil || len(doc.Keys) != 0 {
+			t.Errorf("keys = %+v", doc.Keys)
+		}
+	})
+}
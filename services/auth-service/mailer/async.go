@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Async sends mail in the background so that requests need not wait for
+// the mail server, and keeps count of the sends in progress so that the
+// process can finish them before it exits.
+type Async struct {
+	Mailer Mailer
+	// Timeout bounds each send; zero means 30 seconds.
+	Timeout time.Duration
+
+	wg sync.WaitGroup
+}
+
+// Go sends m from a new goroutine and passes any error to onError.
+func (a *Async) Go(m Message, onError func(error)) {
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := a.Mailer.Send(ctx, m); err != nil {
+			onError(err)
+		}
+	}()
+}
+
+// Wait blocks until every send started by Go has returned, or until ctx
+// is done.
+func (a *Async) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LogMailer is for local development: it writes each message to Dir as an
+// .eml file, or to the log when Dir is empty. Nothing is delivered.
+type LogMailer struct {
+	From string
+	Dir  string
+}
+
+func (l *LogMailer) Send(_ context.Context, m Message) error {
+	now := time.Now()
+	msg, err := render(l.From, m, now)
+	if err != nil {
+		return err
+	}
+	if l.Dir == "" {
+		log.Printf("mail (not sent):\n%s", msg)
+		return nil
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := now.UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(l.Dir, name), msg, 0o600)
+}
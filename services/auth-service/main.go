@@ -3,33 +3,99 @@ package main
 import (
 	// "github.com/gin-gonic/gin"
 	// "net/http"
+	"auth-service/config"
 	"auth-service/handlers"
 	"auth-service/handlers/middleware"
-	"database/sql"
+	"auth-service/health"
+	"auth-service/keys"
+	"auth-service/mailer"
+	"auth-service/migrate"
+	"auth-service/migrations"
+	"auth-service/models"
+	"auth-service/password"
+	"auth-service/ratelimit"
+	"auth-service/sqldb"
+	"auth-service/sso"
+	"auth-service/store"
+	"auth-service/tlscert"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"github.com/joho/godotenv"
-	_ "github.com/denisenkom/go-mssqldb"
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("No .env file found: %v", err)
+func main() {
+	// A .env file is a convenience for local development; deployments set
+	// the environment or use a configuration file instead.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error reading .env: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
 	}
-}
 
-func main() {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	db_config := os.Getenv("DB_CONN")
-	if jwtSecret == ""{
-		log.Fatal("JWT_SECRET is required")
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-	if db_config == ""{
-		log.Fatal("DB_CONN is required")
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var printed strings.Builder
+	cfg.Print(&printed)
+	log.Printf("Configuration:\n%s", printed.String())
+
+	// SIGTERM, as sent by Kubernetes and systemd, starts a graceful
+	// shutdown; so does Ctrl-C. ctx ends then, which stops the background
+	// jobs, and jobs lets main wait for them.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var jobs sync.WaitGroup
+	background := func(job func()) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job()
+		}()
 	}
-	db, err := sql.Open("mssql", db_config)
+
+	keySet, err := keys.New(keys.Config{
+		Alg:         cfg.Tokens.SigningAlg,
+		Secret:      []byte(cfg.Tokens.Secret),
+		Dir:         cfg.Tokens.KeyDir,
+		RotateEvery: cfg.Tokens.RotateEvery,
+		// Keep retired keys a little longer than tokens live so that tokens
+		// signed just before a rotation still verify.
+		RetainFor:    handlers.KeyRetention(cfg.Tokens.TTL),
+		PublishAhead: cfg.Tokens.PublishAhead,
+	})
+	if err != nil {
+		log.Fatalf("Error loading signing keys: %v", err)
+	}
+	// /readyz fails when a dependency or a background job does.
+	var readyChecks []handlers.ReadyCheck
+	if cfg.Tokens.SigningAlg != keys.AlgHS256 {
+		keysWorker := health.NewWorker(cfg.Tokens.ReloadEvery)
+		readyChecks = append(readyChecks, handlers.ReadyCheck{Name: "worker.keys", Check: keysWorker.Check})
+		background(func() { keySet.Run(ctx, cfg.Tokens.ReloadEvery, keysWorker.Beat) })
+	}
+
+	db, err := sqldb.Open(cfg.Database.Conn)
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
@@ -42,17 +108,352 @@ func main() {
 
 	fmt.Println("Successfully connected and pinged the database")
 
+	// Refuse to serve against a schema this binary was not built for.
+	// Migrations are applied separately, with `auth-service migrate up`.
+	runner, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		log.Fatalf("Error loading migrations: %v", err)
+	}
+	checkCtx, cancelCheck := context.WithTimeout(context.Background(), 10*time.Second)
+	err = runner.Check(checkCtx)
+	cancelCheck()
+	if err != nil {
+		log.Fatalf("Database schema is not up to date: %v; run `auth-service migrate up`", err)
+	}
+
+	revocations := models.NewRevocationStore(db)
+	purgeWorker := health.NewWorker(time.Hour)
+	background(func() { purgeRevocations(ctx, revocations, time.Hour, purgeWorker.Beat) })
+
+	var tlsConfig *tls.Config
+	if cfg.HTTP.TLSCertFile != "" {
+		cert, err := tlscert.Load(cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: cert.GetCertificate}
+		certWorker := health.NewWorker(time.Minute)
+		readyChecks = append(readyChecks, handlers.ReadyCheck{Name: "worker.tls_cert", Check: certWorker.Check})
+		background(func() { cert.Run(ctx, time.Minute, certWorker.Beat) })
+	}
+
+	readyChecks = append(readyChecks,
+		handlers.ReadyCheck{Name: "database", Check: db.PingContext},
+		handlers.ReadyCheck{Name: "migrations", Check: runner.Check},
+		handlers.ReadyCheck{Name: "worker.revocations", Check: purgeWorker.Check},
+	)
+
 	router := gin.Default()
+	// gin believes X-Forwarded-For from anyone unless told otherwise, which
+	// would let clients pick the IP that rate limits and lockouts key on.
+	if err := router.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	router.Use(middleware.RequestID(), middleware.Timeout(cfg.HTTP.RequestTimeout))
+	// Probes come before the rate limit so that a busy client cannot make
+	// an instance look unhealthy.
+	router.GET("/healthz", handlers.HealthzHandler())
+	router.GET("/readyz", handlers.ReadyzHandler(readyChecks, 2*time.Second))
+
+	// Limits are per instance. To share them across instances, use a
+	// ratelimit.RedisStore here instead.
+	limits := ratelimit.NewMemoryStore()
+	router.Use(middleware.RateLimit(limits, "global", ratelimit.Limit{Requests: 300, Per: time.Minute}))
+
+	cookies := cookieConfig(cfg.Cookies)
+
+	authMW := middleware.Auth(keySet, revocations, models.NewAPIKeyStore(db))
+	csrfMW := middleware.CSRF(cookies.CSRFSecret, cfg.Cookies.TrustedOrigins)
+	sessionMW := middleware.SessionOnly()
+	auditLog := models.NewAuditLog(db)
+	stores := store.NewSQL(db)
+	sessions := handlers.Sessions{Keys: keySet, Cookies: cookies, TTL: cfg.Tokens.TTL, Audit: auditLog, Revocations: revocations}
+	mail := &mailer.Async{Mailer: newMailer(cfg.Mail)}
+	passwords := newPasswords(cfg.Passwords)
+	verification := handlers.NewEmailVerification(db, stores, sessions, mail, cfg.HTTP.BaseURL, revocations, passwords)
+	router.POST("/register",
+		middleware.RateLimit(limits, "register", ratelimit.Limit{Requests: 5, Per: time.Hour}),
+		handlers.NewHandler(stores, verification, passwords))
+	router.GET("/verify", verification.Verify())
+	router.POST("/verify/resend", authMW, sessionMW, csrfMW, verification.Resend())
+	passwordReset := handlers.NewPasswordReset(db, mail, cfg.Passwords.ResetURL, revocations, passwords, auditLog)
+	passwordLimit := middleware.RateLimit(limits, "password", ratelimit.Limit{Requests: 5, Per: 15 * time.Minute})
+	router.POST("/password/forgot", passwordLimit, passwordReset.Forgot())
+	router.POST("/password/reset", passwordLimit, passwordReset.Reset())
+	totpIssuer := cfg.TOTP.Issuer
+	loginGuard, err := handlers.NewLoginGuard(cfg.Login.MaxFailures, cfg.Login.Lockout, passwords.Hasher)
+	if err != nil {
+		log.Fatalf("Error configuring login protection: %v", err)
+	}
+	loginLimit := middleware.RateLimit(limits, "login", ratelimit.Limit{Requests: 10, Per: time.Minute})
+	router.POST("/login", loginLimit, handlers.AuthHandler(stores, sessions, loginGuard, passwords))
+	router.POST("/login/2fa", loginLimit, handlers.LoginTOTPHandler(db, stores, sessions, revocations, loginGuard))
+	router.POST("/logout", authMW, sessionMW, csrfMW, handlers.LogoutHandler(revocations, cookies))
+	router.POST("/logout/all", authMW, sessionMW, csrfMW, handlers.LogoutAllHandler(revocations, cookies))
+	router.GET("/me", authMW, handlers.MeHandler(stores))
+	mg := router.Group("/me")
+	mg.Use(authMW, sessionMW, csrfMW)
+	mg.PATCH("", handlers.UpdateProfileHandler(db, auditLog))
+	mg.POST("/password", handlers.ChangePasswordHandler(db, sessions, revocations, passwords))
+	mg.POST("/email", verification.ChangeEmail())
+	router.GET("/.well-known/jwks.json", handlers.JWKSHandler(keySet))
+	if rpID := cfg.WebAuthn.RPID; rpID != "" {
+		wa, err := webauthn.New(&webauthn.Config{
+			RPID:          rpID,
+			RPDisplayName: totpIssuer,
+			RPOrigins:     cfg.WebAuthn.RPOrigins,
+			AuthenticatorSelection: protocol.AuthenticatorSelection{
+				ResidentKey:      protocol.ResidentKeyRequirementRequired,
+				UserVerification: protocol.VerificationRequired,
+			},
+		})
+		if err != nil {
+			log.Fatalf("Error configuring WebAuthn: %v", err)
+		}
+		passkeys := handlers.NewPasskeys(db, wa, sessions)
+		router.POST("/login/passkey/begin", loginLimit, passkeys.LoginBegin())
+		router.POST("/login/passkey/finish", loginLimit, passkeys.LoginFinish())
+		pg := router.Group("/passkeys")
+		pg.Use(authMW, sessionMW, csrfMW)
+		pg.POST("/register/begin", passkeys.RegisterBegin())
+		pg.POST("/register/finish", passkeys.RegisterFinish())
+		pg.GET("", passkeys.List())
+		pg.DELETE("/:id", passkeys.Delete())
+	} else {
+		log.Print("WEBAUTHN_RP_ID not set; passkey login disabled")
+	}
+
+	if providers := ssoProviders(cfg.OIDC.Providers, cfg.HTTP.BaseURL); len(providers) > 0 {
+		oidc := handlers.NewSSO(db, sessions, verification, providers, cfg.OIDC.AfterLoginURL)
+		router.GET("/login/oidc/:provider", loginLimit, oidc.Login())
+		router.GET("/login/oidc/:provider/callback", loginLimit, oidc.Callback())
+		mg.GET("/identities", oidc.ListIdentities())
+		mg.POST("/identities/:provider", oidc.Link())
+		mg.DELETE("/identities/:id", oidc.Unlink())
+	}
 
-	authMW := middleware.Auth(jwtSecret)
-	router.POST("/register", handlers.NewHandler(db))
-	router.POST("/login", handlers.AuthHandler(db, jwtSecret))
-	router.GET("/me", authMW, handlers.MeHandler())
-	ag := router.Group("/analytics")
-	ag.Use(authMW)
-	ag.GET("/summary", handlers.AnalyticsSummary(db))
-	ag.GET("/cashflow", handlers.AnalyticsCashflow(db))
-	ag.GET("/budget", handlers.AnalyticsBudgets(db))
-	router.Run(":8080")
+	tg := router.Group("/2fa")
+	tg.Use(authMW, sessionMW, csrfMW)
+	tg.POST("/totp/enroll", handlers.TOTPEnrollHandler(db, totpIssuer))
+	tg.POST("/totp/confirm", handlers.TOTPConfirmHandler(db, auditLog))
+	tg.POST("/totp/disable", handlers.TOTPDisableHandler(db, passwords, auditLog))
+	tg.POST("/recovery-codes", handlers.RecoveryCodesRegenerateHandler(db, passwords, auditLog))
+	kg := router.Group("/api-keys")
+	kg.Use(authMW, sessionMW, csrfMW)
+	kg.POST("", handlers.CreateAPIKeyHandler(db, auditLog))
+	kg.GET("", handlers.ListAPIKeysHandler(db))
+	kg.DELETE("/:id", handlers.RevokeAPIKeyHandler(db, auditLog))
+	admin := handlers.NewAdmin(db, revocations, auditLog)
+	adm := router.Group("/admin")
+	adm.Use(authMW, sessionMW, middleware.RequireRole(models.RoleAdmin), csrfMW)
+	adm.GET("/users", admin.ListUsers())
+	adm.GET("/users/:id", admin.GetUser())
+	adm.POST("/users/:id/disable", admin.SetDisabled(true))
+	adm.POST("/users/:id/enable", admin.SetDisabled(false))
+	adm.PUT("/users/:id/role", admin.SetRole())
+	adm.POST("/users/:id/logout", admin.Logout())
+	adm.GET("/imports", admin.ListImports())
+	adm.GET("/audit", admin.Audit())
+	adm.POST("/categories/merge", admin.MergeCategories())
+	workspaces := handlers.NewWorkspaces(db, mail, cfg.Workspaces.InviteURL, auditLog)
+	ownerOnly := middleware.RequireWorkspaceRole(models.WorkspaceOwner)
+	wg := router.Group("/workspaces")
+	wg.Use(authMW, sessionMW, csrfMW)
+	wg.GET("", workspaces.List())
+	wg.POST("", workspaces.Create())
+	wg.POST("/invites/accept", workspaces.Accept())
+	wsg := wg.Group("/:workspaceID", middleware.Workspace(db))
+	wsg.PATCH("", ownerOnly, workspaces.Rename())
+	wsg.GET("/members", workspaces.Members())
+	wsg.PUT("/members/:userID", ownerOnly, workspaces.SetMemberRole())
+	wsg.DELETE("/members/:userID", workspaces.RemoveMember())
+	wsg.GET("/invites", ownerOnly, workspaces.Invites())
+	wsg.POST("/invites", ownerOnly, workspaces.Invite())
+	wsg.DELETE("/invites/:id", ownerOnly, workspaces.RevokeInvite())
+	// The audit log shows changes in the workspace and to the caller's own
+	// account; /audit picks the workspace like /analytics does.
+	wsg.GET("/audit", handlers.ListAuditHandler(auditLog))
+	router.GET("/audit", authMW, sessionMW, csrfMW, middleware.Workspace(db), handlers.ListAuditHandler(auditLog))
 
+	// Analytics act on the workspace named in the path or, under
+	// /analytics, the X-Workspace-ID header or the user's default.
+	analyticsLimit := middleware.RateLimit(limits, "analytics", ratelimit.Limit{Requests: 120, Per: time.Minute})
+	for _, ag := range []*gin.RouterGroup{
+		router.Group("/analytics", authMW),
+		router.Group("/workspaces/:workspaceID/analytics", authMW),
+	} {
+		ag.Use(csrfMW, middleware.RequireScope(models.ScopeReadAnalytics), analyticsLimit, middleware.Workspace(db))
+		ag.GET("/summary", handlers.AnalyticsSummary(stores))
+		ag.GET("/cashflow", handlers.AnalyticsCashflow(stores))
+		ag.GET("/budget", handlers.AnalyticsBudgets(stores))
+	}
+
+	// Transactions and budgets are edited by editors and owners, likewise
+	// in the workspace named in the path or the one /analytics would use.
+	ledger := handlers.NewLedger(db)
+	for _, lg := range []*gin.RouterGroup{
+		router.Group("", authMW),
+		router.Group("/workspaces/:workspaceID", authMW),
+	} {
+		lg.Use(csrfMW, middleware.RequireScope(models.ScopeWriteTransactions), middleware.Workspace(db),
+			middleware.RequireWorkspaceRole(models.WorkspaceEditor))
+		lg.PATCH("/transactions/:id", ledger.UpdateTransaction())
+		lg.DELETE("/transactions/:id", ledger.DeleteTransaction())
+		lg.PUT("/budgets/:month/:category", ledger.SetBudget())
+		lg.DELETE("/budgets/:month/:category", ledger.DeleteBudget())
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+		TLSConfig:         tlsConfig,
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", cfg.HTTP.Addr)
+		if srv.TLSConfig != nil {
+			served <- srv.ListenAndServeTLS("", "")
+		} else {
+			served <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-served:
+		log.Fatalf("Error serving HTTP: %v", err)
+	case <-ctx.Done():
+	}
+	// A second signal now kills the process at once.
+	stop()
+
+	log.Print("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error draining requests: %v", err)
+	}
+	// Requests have finished, so no more mail can be queued.
+	if err := mail.Wait(shutdownCtx); err != nil {
+		log.Printf("Error draining mail: %v", err)
+	}
+	jobs.Wait()
+	log.Print("Stopped")
+}
+
+func cookieConfig(c config.Cookies) handlers.CookieConfig {
+	// Validated by config.Load.
+	sameSite, _ := handlers.ParseSameSite(c.SameSite)
+
+	csrfSecret := []byte(c.CSRFSecret)
+	if len(csrfSecret) == 0 {
+		// Fine for a single instance; every instance behind a load balancer
+		// must share the same CSRF_SECRET.
+		csrfSecret = make([]byte, 32)
+		if _, err := rand.Read(csrfSecret); err != nil {
+			log.Fatalf("Error generating CSRF secret: %v", err)
+		}
+		log.Print("CSRF_SECRET not set; using a random per-process secret")
+	}
+
+	return handlers.CookieConfig{
+		Domain:     c.Domain,
+		Secure:     c.Secure,
+		SameSite:   sameSite,
+		CSRFSecret: csrfSecret,
+	}
+}
+
+// newMailer delivers through the SMTP server when one is configured.
+// Otherwise mail is written to the mail directory, or to the log, for
+// local development.
+func newMailer(c config.Mail) mailer.Mailer {
+	if c.SMTPAddr != "" {
+		return &mailer.SMTPMailer{
+			Addr:     c.SMTPAddr,
+			From:     c.From,
+			Username: c.SMTPUser,
+			Password: c.SMTPPassword,
+		}
+	}
+	log.Print("MAIL_SMTP_ADDR not set; emails are logged, not sent")
+	return &mailer.LogMailer{From: c.From, Dir: c.Dir}
+}
+
+// newPasswords configures hashing and the policy for new passwords. Hashes
+// in the scheme not picked for new ones are still accepted and are
+// upgraded on login, as are hashes below the configured cost.
+func newPasswords(c config.Passwords) handlers.Passwords {
+	argon := password.DefaultArgon2id()
+	argon.Time = c.Argon2Time
+	argon.MemoryKiB = c.Argon2MemoryKiB
+	argon.Threads = c.Argon2Threads
+	bc := password.Bcrypt{Cost: c.BcryptCost}
+
+	policy := password.DefaultPolicy()
+	policy.MinLength = c.MinLength
+	policy.MinScore = c.MinScore
+	var hasher password.Hasher
+	switch c.Hash {
+	case "bcrypt":
+		hasher = password.Hasher{Preferred: bc, Accepted: []password.Scheme{argon}}
+		policy.MaxBytes = 72
+	default:
+		hasher = password.Hasher{Preferred: argon, Accepted: []password.Scheme{bc}}
+	}
+	if c.BreachDir != "" {
+		policy.Breached = password.RangeDir{Dir: c.BreachDir}
+	}
+	return handlers.Passwords{Hasher: hasher, Policy: policy}
+}
+
+// ssoProviders discovers the configured OpenID Connect providers. Each
+// one's callback is BASE_URL/login/oidc/<name>/callback, which must be
+// registered with the provider.
+func ssoProviders(configs []config.OIDCProvider, baseURL string) []*sso.Provider {
+	var providers []*sso.Provider
+	for _, c := range configs {
+		cfg := sso.Config{
+			Name:         c.Name,
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  strings.TrimRight(baseURL, "/") + "/login/oidc/" + c.Name + "/callback",
+			TrustEmail:   c.TrustEmail,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		p, err := sso.NewProvider(ctx, cfg)
+		cancel()
+		if err != nil {
+			log.Fatalf("Error configuring OIDC: %v", err)
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// purgeRevocations periodically drops revocation entries for tokens that
+// have expired on their own, until ctx is done. It passes the outcome of
+// each purge to report.
+func purgeRevocations(ctx context.Context, revocations *models.RevocationStore, every time.Duration, report func(error)) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purgeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			err := revocations.PurgeExpired(purgeCtx)
+			cancel()
+			if err != nil {
+				log.Printf("purge revoked tokens: %v", err)
+			}
+			report(err)
+		}
+	}
 }
@@ -0,0 +1,430 @@
+// Package migrate applies the SQL files in package migrations to the
+// database and records them in schema_migrations.
+//
+// Migrations run in file-name order, each in its own transaction together
+// with its schema_migrations row, so a failed migration leaves nothing
+// behind. On SQL Server and PostgreSQL a lock keeps two instances from
+// migrating at once; SQLite allows only one writer anyway.
+package migrate
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPending means migrations in the binary have not been applied.
+	ErrPending = errors.New("migrations have not been applied")
+	// ErrDrift means an applied migration's file has changed since.
+	ErrDrift = errors.New("applied migration has changed")
+	// ErrUnknown means the database has migrations this binary does not,
+	// usually because a newer version has already migrated it.
+	ErrUnknown = errors.New("database has migrations unknown to this binary")
+	// ErrNoDown means a migration cannot be reverted.
+	ErrNoDown = errors.New("migration has no down file")
+)
+
+// bootstrap creates schema_migrations and is never reverted.
+const bootstrap = "0000_bootstrap.sql"
+
+var fileName = regexp.MustCompile(`^(\d{4})_[a-z0-9_]+\.sql$`)
+
+// Migration is one NNNN_name.sql file and its optional NNNN_name.down.sql.
+type Migration struct {
+	// Name is the up file's name, which is what schema_migrations records.
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Load reads migrations from fsys, in order. Version numbers must be
+// unique, but gaps are allowed.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[string]*Migration{}
+	downs := map[string]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if up, ok := strings.CutSuffix(name, ".down.sql"); ok {
+			downs[up+".sql"] = string(b)
+			continue
+		}
+		m := fileName.FindStringSubmatch(name)
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_description.sql", name)
+		}
+		if prev, ok := byVersion[m[1]]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same number", prev.Name, name)
+		}
+		sum := sha256.Sum256(b)
+		byVersion[m[1]] = &Migration{Name: name, Up: string(b), Checksum: hex.EncodeToString(sum[:])}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if down, ok := downs[m.Name]; ok {
+			m.Down = down
+			delete(downs, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	if len(downs) > 0 {
+		var orphans []string
+		for up := range downs {
+			orphans = append(orphans, strings.TrimSuffix(up, ".sql")+".down.sql")
+		}
+		sort.Strings(orphans)
+		return nil, fmt.Errorf("%s: no matching up migration", strings.Join(orphans, ", "))
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Name < migrations[j].Name })
+	return migrations, nil
+}
+
+// Status is a migration and whether it has been applied.
+type Status struct {
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+	// Drifted is set when the file differs from what was applied.
+	Drifted bool `json:"drifted"`
+	// Unknown is set for applied migrations with no file in the binary.
+	Unknown bool `json:"unknown"`
+}
+
+func (s Status) String() string {
+	switch {
+	case s.Unknown:
+		return "unknown"
+	case s.AppliedAt == nil:
+		return "pending"
+	case s.Drifted:
+		return "changed since applied"
+	default:
+		return "applied " + s.AppliedAt.UTC().Format(time.DateTime)
+	}
+}
+
+type applied struct {
+	at       time.Time
+	checksum sql.NullString
+}
+
+// Runner applies a fixed set of migrations to one database.
+type Runner struct {
+	db         *sqldb.DB
+	migrations []Migration
+}
+
+// New loads the migrations for db's dialect, which live in the directory
+// of fsys named after it.
+func New(db *sqldb.DB, fsys fs.FS) (*Runner, error) {
+	sub, err := fs.Sub(fsys, db.Dialect.Name())
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations for %s", db.Dialect.Name())
+	}
+	return &Runner{db: db, migrations: migrations}, nil
+}
+
+// querier is satisfied by *sqldb.DB, *sqldb.Conn and *sqldb.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// tableExists reports whether schema_migrations exists and, on SQL Server,
+// whether it has the checksum column. Tables created by hand from an
+// earlier SQL Server bootstrap lack it; the other dialects always had it.
+func tableExists(ctx context.Context, d sqldb.Dialect, q querier) (exists, hasChecksum bool, err error) {
+	switch d.Name() {
+	case sqldb.SQLServer:
+		err = q.QueryRowContext(ctx, `
+		SELECT CASE WHEN OBJECT_ID('dbo.schema_migrations', 'U') IS NULL THEN 0 ELSE 1 END,
+			CASE WHEN COL_LENGTH('dbo.schema_migrations', 'checksum') IS NULL THEN 0 ELSE 1 END`).Scan(&exists, &hasChecksum)
+		return exists, hasChecksum, err
+	case sqldb.Postgres:
+		err = q.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	default:
+		err = q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&exists)
+	}
+	return exists, exists, err
+}
+
+// loadApplied returns the rows of schema_migrations, or none if the table
+// does not exist yet. Checksums missing from the table read as NULL.
+func loadApplied(ctx context.Context, d sqldb.Dialect, q querier) (map[string]applied, error) {
+	exists, hasChecksum, err := tableExists(ctx, d, q)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]applied{}
+	if !exists {
+		return out, nil
+	}
+
+	checksum := "checksum"
+	if !hasChecksum {
+		checksum = "NULL"
+	}
+	rows, err := q.QueryContext(ctx, `SELECT FileName, applied_at, `+checksum+` FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var a applied
+		if err := rows.Scan(&name, &a.at, &a.checksum); err != nil {
+			return nil, err
+		}
+		out[name] = a
+	}
+	return out, rows.Err()
+}
+
+func (r *Runner) status(done map[string]applied) []Status {
+	known := map[string]bool{}
+	var out []Status
+	for _, m := range r.migrations {
+		known[m.Name] = true
+		s := Status{Name: m.Name}
+		if a, ok := done[m.Name]; ok {
+			at := a.at
+			s.AppliedAt = &at
+			s.Drifted = a.checksum.Valid && a.checksum.String != m.Checksum
+		}
+		out = append(out, s)
+	}
+	var unknown []Status
+	for name, a := range done {
+		if !known[name] {
+			at := a.at
+			unknown = append(unknown, Status{Name: name, AppliedAt: &at, Unknown: true})
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Name < unknown[j].Name })
+	return append(out, unknown...)
+}
+
+// Status lists every migration, applied or not, followed by any applied
+// migrations the binary does not know.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	done, err := loadApplied(ctx, r.db.Dialect, r.db)
+	if err != nil {
+		return nil, err
+	}
+	return r.status(done), nil
+}
+
+// Check returns nil only if every migration has been applied unchanged and
+// the database has none the binary does not know. The service runs it at
+// startup.
+func (r *Runner) Check(ctx context.Context) error {
+	st, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	return check(st)
+}
+
+func check(st []Status) error {
+	var pending []string
+	for _, s := range st {
+		switch {
+		case s.Unknown:
+			return fmt.Errorf("%w: %s", ErrUnknown, s.Name)
+		case s.Drifted:
+			return fmt.Errorf("%w: %s", ErrDrift, s.Name)
+		case s.AppliedAt == nil:
+			pending = append(pending, s.Name)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %s", ErrPending, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// advisoryLock is the PostgreSQL advisory lock key for migrations, an
+// arbitrary number shared by every instance.
+const advisoryLock = 7212349901
+
+// lock takes an exclusive lock for the life of conn, waiting up to a
+// minute on SQL Server, or until ctx is done on PostgreSQL, for another
+// instance to finish. It returns the function that releases the lock.
+func lock(ctx context.Context, conn *sqldb.Conn) (func(), error) {
+	switch conn.Dialect.Name() {
+	case sqldb.SQLServer:
+		var res int
+		err := conn.QueryRowContext(ctx, `
+		DECLARE @res INT;
+		EXEC @res = sp_getapplock @Resource = 'schema_migrations', @LockMode = 'Exclusive',
+			@LockOwner = 'Session', @LockTimeout = 60000;
+		SELECT @res`).Scan(&res)
+		if err != nil {
+			return nil, err
+		}
+		if res < 0 {
+			return nil, fmt.Errorf("could not lock schema_migrations (sp_getapplock returned %d)", res)
+		}
+		return func() {
+			conn.ExecContext(context.Background(), `EXEC sp_releaseapplock @Resource = 'schema_migrations', @LockOwner = 'Session'`)
+		}, nil
+	case sqldb.Postgres:
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(?)`, advisoryLock); err != nil {
+			return nil, err
+		}
+		return func() {
+			conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(?)`, advisoryLock)
+		}, nil
+	}
+	return func() {}, nil
+}
+
+func (r *Runner) withLock(ctx context.Context, fn func(conn *sqldb.Conn, done map[string]applied) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	unlock, err := lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if conn.Dialect.Name() == sqldb.SQLServer {
+		_, err = conn.ExecContext(ctx, `
+		IF OBJECT_ID('dbo.schema_migrations', 'U') IS NOT NULL AND COL_LENGTH('dbo.schema_migrations', 'checksum') IS NULL
+			ALTER TABLE dbo.schema_migrations ADD checksum CHAR(64) NULL`)
+		if err != nil {
+			return err
+		}
+	}
+	done, err := loadApplied(ctx, conn.Dialect, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, done)
+}
+
+func run(ctx context.Context, conn *sqldb.Conn, name, script string, record func(tx *sqldb.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := record(tx); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return tx.Commit()
+}
+
+// Up applies every pending migration and returns their names. It refuses
+// to start if an applied migration has changed or is unknown. Checksums
+// missing from rows recorded before they were tracked are filled in.
+func (r *Runner) Up(ctx context.Context) ([]string, error) {
+	var ran []string
+	err := r.withLock(ctx, func(conn *sqldb.Conn, done map[string]applied) error {
+		for _, s := range r.status(done) {
+			if s.Unknown {
+				return fmt.Errorf("%w: %s", ErrUnknown, s.Name)
+			}
+			if s.Drifted {
+				return fmt.Errorf("%w: %s", ErrDrift, s.Name)
+			}
+		}
+		for _, m := range r.migrations {
+			a, ok := done[m.Name]
+			if ok {
+				if !a.checksum.Valid {
+					if _, err := conn.ExecContext(ctx, `UPDATE schema_migrations SET checksum = ? WHERE FileName = ?`, m.Checksum, m.Name); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			err := run(ctx, conn, m.Name, m.Up, func(tx *sqldb.Tx) error {
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (FileName, checksum) VALUES (?, ?)`, m.Name, m.Checksum)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			ran = append(ran, m.Name)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and
+// returns their names. The bootstrap migration, which creates
+// schema_migrations itself, is never reverted.
+func (r *Runner) Down(ctx context.Context, steps int) ([]string, error) {
+	var reverted []string
+	err := r.withLock(ctx, func(conn *sqldb.Conn, done map[string]applied) error {
+		for _, s := range r.status(done) {
+			if s.Unknown {
+				return fmt.Errorf("%w: %s", ErrUnknown, s.Name)
+			}
+		}
+		for i := len(r.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := r.migrations[i]
+			if m.Name == bootstrap {
+				break
+			}
+			a, ok := done[m.Name]
+			if !ok {
+				continue
+			}
+			if a.checksum.Valid && a.checksum.String != m.Checksum {
+				return fmt.Errorf("%w: %s", ErrDrift, m.Name)
+			}
+			if m.Down == "" {
+				return fmt.Errorf("%w: %s", ErrNoDown, m.Name)
+			}
+			err := run(ctx, conn, m.Name, m.Down, func(tx *sqldb.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE FileName = ?`, m.Name)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			reverted = append(reverted, m.Name)
+		}
+		return nil
+	})
+	return reverted, err
+}
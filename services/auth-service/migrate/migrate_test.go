@@ -0,0 +1,162 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"auth-service/migrations"
+	"auth-service/sqldb"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_b.sql":         {Data: []byte("CREATE TABLE b (id INT);")},
+		"0000_bootstrap.sql": {Data: []byte("CREATE TABLE schema_migrations (FileName VARCHAR(50));")},
+		"0001_a.sql":         {Data: []byte("CREATE TABLE a (id INT);")},
+		"0001_a.down.sql":    {Data: []byte("DROP TABLE a;")},
+		"README.md":          {Data: []byte("not a migration")},
+	}
+	ms, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range ms {
+		names = append(names, m.Name)
+	}
+	if got := strings.Join(names, " "); got != "0000_bootstrap.sql 0001_a.sql 0002_b.sql" {
+		t.Fatalf("order = %s", got)
+	}
+	if ms[1].Down != "DROP TABLE a;" || ms[2].Down != "" {
+		t.Errorf("down files not paired: %q, %q", ms[1].Down, ms[2].Down)
+	}
+	if len(ms[1].Checksum) != 64 || ms[1].Checksum == ms[2].Checksum {
+		t.Errorf("checksums %q and %q", ms[1].Checksum, ms[2].Checksum)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"duplicate number": {
+			"0001_a.sql": {Data: []byte("x")},
+			"0001_b.sql": {Data: []byte("y")},
+		},
+		"bad name": {
+			"1_a.sql": {Data: []byte("x")},
+		},
+		"orphan down": {
+			"0001_a.sql":      {Data: []byte("x")},
+			"0002_b.down.sql": {Data: []byte("y")},
+		},
+	}
+	for name, fsys := range tests {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: loaded without error", name)
+		}
+	}
+}
+
+// TestEmbeddedMigrations checks the files shipped in the binary: every
+// dialect has them, they load, everything but the bootstrap can be
+// reverted, and the dialects end at the same number.
+func TestEmbeddedMigrations(t *testing.T) {
+	var last []string
+	for _, dialect := range []string{sqldb.SQLServer, sqldb.Postgres, sqldb.SQLite} {
+		sub, err := fs.Sub(migrations.FS, dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms, err := Load(sub)
+		if err != nil {
+			t.Fatalf("%s: %v", dialect, err)
+		}
+		if len(ms) == 0 || ms[0].Name != bootstrap {
+			t.Fatalf("%s: first migration is not %s", dialect, bootstrap)
+		}
+		for _, m := range ms[1:] {
+			if m.Down == "" {
+				t.Errorf("%s/%s has no down file", dialect, m.Name)
+			}
+		}
+		last = append(last, ms[len(ms)-1].Name[:4])
+	}
+	if last[0] != last[1] || last[0] != last[2] {
+		t.Errorf("latest migrations differ: sqlserver %s, postgres %s, sqlite %s", last[0], last[1], last[2])
+	}
+}
+
+// TestRunnerSQLite applies, reverts and reapplies the SQLite migrations.
+func TestRunnerSQLite(t *testing.T) {
+	db, err := sqldb.Open("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r, err := New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := r.Check(ctx); !errors.Is(err, ErrPending) {
+		t.Fatalf("Check on an empty database = %v, want ErrPending", err)
+	}
+	ran, err := r.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != len(r.migrations) {
+		t.Errorf("Up ran %d migrations, want %d", len(ran), len(r.migrations))
+	}
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check after Up = %v", err)
+	}
+
+	reverted, err := r.Down(ctx, len(r.migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(r.migrations)-1 {
+		t.Errorf("Down reverted %d migrations, want all but the bootstrap", len(reverted))
+	}
+	if _, err := r.Up(ctx); err != nil {
+		t.Fatalf("Up after Down: %v", err)
+	}
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check after reapplying = %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	r := &Runner{migrations: []Migration{
+		{Name: "0000_bootstrap.sql", Checksum: "aa"},
+		{Name: "0001_a.sql", Checksum: "bb"},
+	}}
+	at := time.Now()
+	sum := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+
+	tests := []struct {
+		name string
+		done map[string]applied
+		want error
+	}{
+		{"fresh database", map[string]applied{}, ErrPending},
+		{"one pending", map[string]applied{"0000_bootstrap.sql": {at, sum("aa")}}, ErrPending},
+		{"up to date", map[string]applied{"0000_bootstrap.sql": {at, sum("aa")}, "0001_a.sql": {at, sum("bb")}}, nil},
+		{"recorded before checksums", map[string]applied{"0000_bootstrap.sql": {at, sql.NullString{}}, "0001_a.sql": {at, sum("bb")}}, nil},
+		{"edited after applying", map[string]applied{"0000_bootstrap.sql": {at, sum("aa")}, "0001_a.sql": {at, sum("cc")}}, ErrDrift},
+		{"newer database", map[string]applied{"0000_bootstrap.sql": {at, sum("aa")}, "0001_a.sql": {at, sum("bb")}, "0002_b.sql": {at, sum("dd")}}, ErrUnknown},
+	}
+	for _, tt := range tests {
+		err := check(r.status(tt.done))
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Package migrations holds the database schema as numbered SQL files,
+// embedded so the binary can apply them; see package migrate. Each
+// dialect has its own directory, named as in package sqldb: sqlserver,
+// postgres and sqlite. A change to the schema adds a file with the same
+// number to each.
+//
+// NNNN_name.sql moves the schema forward and the optional
+// NNNN_name.down.sql reverses it. Each file runs as a single batch inside
+// a transaction, so on SQL Server statements that use a column added
+// earlier in the same file must go through EXEC. Never edit a file once it
+// has been applied anywhere: the runner records a checksum and refuses to
+// continue when a file no longer matches it.
+package migrations
+
+import "embed"
+
+//go:embed sqlserver/*.sql postgres/*.sql sqlite/*.sql
+var FS embed.FS
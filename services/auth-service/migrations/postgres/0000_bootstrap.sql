@@ -0,0 +1,7 @@
+-- Applied migrations, by file name. checksum is the SHA-256 of the file
+-- as applied.
+CREATE TABLE schema_migrations (
+    FileName VARCHAR(50) PRIMARY KEY,
+    applied_at TIMESTAMPTZ(0) DEFAULT now(),
+    checksum CHAR(64) NULL
+);
@@ -0,0 +1,18 @@
+DROP TABLE audit_log;
+DROP FUNCTION audit_log_append_only();
+DROP TABLE import_runs;
+DROP TABLE user_identities;
+DROP TABLE password_reset_tokens;
+DROP TABLE login_attempts;
+DROP TABLE webauthn_credentials;
+DROP TABLE recovery_codes;
+DROP TABLE api_keys;
+DROP TABLE revoked_tokens;
+DROP TABLE budgets;
+DROP TABLE category_aliases;
+DROP TABLE categories;
+DROP TABLE transactions;
+DROP TABLE workspace_invites;
+DROP TABLE workspace_members;
+DROP TABLE users;
+DROP TABLE workspaces;
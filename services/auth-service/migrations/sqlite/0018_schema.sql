@@ -0,0 +1,274 @@
+-- The schema as of sqlserver/0018, in one step. New migrations take the
+-- next number in every dialect's directory.
+--
+-- Times are stored as text in the format SQLite's date functions read,
+-- always in UTC, so they compare correctly as strings. The DATE and
+-- DATETIME column types tell the driver to parse them back into times.
+
+CREATE TABLE workspaces (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        VARCHAR(100)    NOT NULL,
+    created_at  DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE users (
+    id                    INTEGER PRIMARY KEY AUTOINCREMENT,
+    email                 VARCHAR(255)    NOT NULL UNIQUE,
+    hashed_password       VARCHAR(255)    NOT NULL,
+    created_at            DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    tokens_valid_after    DATETIME        NULL,
+    totp_secret           VARCHAR(64)     NULL,
+    totp_enabled          BOOLEAN         NOT NULL DEFAULT 0,
+    totp_last_counter     BIGINT          NULL,
+    webauthn_user_handle  BLOB            NULL,
+    failed_login_count    INTEGER         NOT NULL DEFAULT 0,
+    locked_until          DATETIME        NULL,
+    email_verified        BOOLEAN         NOT NULL DEFAULT 0,
+    email_verified_at     DATETIME        NULL,
+    verification_sent_at  DATETIME        NULL,
+    display_name          VARCHAR(100)    NULL,
+    base_currency         CHAR(3)         NOT NULL DEFAULT 'USD',
+    timezone              VARCHAR(64)     NOT NULL DEFAULT 'UTC',
+    -- 0 = Sunday ... 6 = Saturday, as Go's time.Weekday.
+    week_start            SMALLINT        NOT NULL DEFAULT 1,
+    -- An address the user has asked to change to, pending verification.
+    pending_email         VARCHAR(255)    NULL,
+    role                  VARCHAR(16)     NOT NULL DEFAULT 'user',
+    disabled_at           DATETIME        NULL,
+    default_workspace_id  INTEGER         NULL REFERENCES workspaces(id),
+
+    CONSTRAINT CK_users_week_start CHECK (week_start BETWEEN 0 AND 6),
+    CONSTRAINT CK_users_role CHECK (role IN ('user', 'admin'))
+);
+
+CREATE UNIQUE INDEX UX_users_webauthn_user_handle
+    ON users (webauthn_user_handle)
+    WHERE webauthn_user_handle IS NOT NULL;
+
+CREATE TABLE workspace_members (
+    workspace_id  INTEGER         NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id       INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role          VARCHAR(16)     NOT NULL,
+    created_at    DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    PRIMARY KEY (workspace_id, user_id),
+    CONSTRAINT CK_workspace_members_role CHECK (role IN ('owner', 'editor', 'viewer'))
+);
+
+CREATE INDEX IX_workspace_members_user_id ON workspace_members (user_id);
+
+CREATE TABLE workspace_invites (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id  INTEGER         NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    email         VARCHAR(255)    NOT NULL,
+    role          VARCHAR(16)     NOT NULL,
+    token_hash    CHAR(64)        NOT NULL UNIQUE,
+    invited_by    INTEGER         NULL REFERENCES users(id),
+    expires_at    DATETIME        NOT NULL,
+    accepted_at   DATETIME        NULL,
+    created_at    DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT CK_workspace_invites_role CHECK (role IN ('owner', 'editor', 'viewer'))
+);
+
+CREATE INDEX IX_workspace_invites_workspace_id ON workspace_invites (workspace_id);
+
+CREATE TABLE transactions (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    workspace_id  INTEGER         NOT NULL REFERENCES workspaces(id),
+    date          DATE            NOT NULL,
+    posted_at     DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    amount        NUMERIC(19,4)   NOT NULL,
+    merchant      VARCHAR(100)    NOT NULL,
+    category      VARCHAR(80)     NOT NULL DEFAULT 'Uncategorized',
+    description   VARCHAR(1000)   NULL,
+    import_id     VARCHAR(128)    NOT NULL,
+    created_at    DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT UQ_transactions_workspace_import_id UNIQUE (workspace_id, import_id)
+);
+
+CREATE INDEX IX_transactions_user_date ON transactions (user_id, date);
+CREATE INDEX IX_transactions_workspace_date ON transactions (workspace_id, date);
+
+CREATE TABLE categories (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        VARCHAR(80)     NOT NULL UNIQUE,
+    created_at  DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+INSERT INTO categories (name) VALUES
+('Uncategorized'),
+('Coffee'),
+('Groceries'),
+('Dining'),
+('Transportation'),
+('Rent'),
+('Utilities');
+
+-- Other spellings of a category name, e.g. 'Cafe' for 'Coffee'.
+CREATE TABLE category_aliases (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    alias        VARCHAR(80)     NOT NULL UNIQUE,
+    category_id  INTEGER         NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    created_at   DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+-- Budgets belong to the workspace; user_id is whoever set them.
+CREATE TABLE budgets (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id        INTEGER         NOT NULL REFERENCES users(id),
+    workspace_id   INTEGER         NOT NULL REFERENCES workspaces(id),
+    category_id    INTEGER         NOT NULL REFERENCES categories(id),
+    month          DATE            NOT NULL,
+    monthly_limit  NUMERIC(19,2)   NOT NULL,
+    created_at     DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT UQ_budgets_workspace_cat_month UNIQUE (workspace_id, category_id, month),
+    CONSTRAINT CK_budgets_monthly_limit_nonneg CHECK (monthly_limit >= 0)
+);
+
+CREATE TABLE revoked_tokens (
+    jti         VARCHAR(64)     NOT NULL PRIMARY KEY,
+    user_id     INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at  DATETIME        NOT NULL,
+    revoked_at  DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IX_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE api_keys (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name          VARCHAR(100)    NOT NULL,
+    prefix        VARCHAR(16)     NOT NULL UNIQUE,
+    key_hash      CHAR(64)        NOT NULL,
+    scopes        VARCHAR(400)    NOT NULL,
+    expires_at    DATETIME        NULL,
+    last_used_at  DATETIME        NULL,
+    revoked_at    DATETIME        NULL,
+    created_at    DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IX_api_keys_user_id ON api_keys (user_id);
+
+CREATE TABLE recovery_codes (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id     INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash   CHAR(64)        NOT NULL,
+    used_at     DATETIME        NULL,
+    created_at  DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IX_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE webauthn_credentials (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id           INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name              VARCHAR(100)    NOT NULL,
+    credential_id     BLOB            NOT NULL UNIQUE,
+    public_key        BLOB            NOT NULL,
+    attestation_type  VARCHAR(32)     NOT NULL,
+    aaguid            BLOB            NULL,
+    transports        VARCHAR(200)    NOT NULL DEFAULT '',
+    sign_count        BIGINT          NOT NULL DEFAULT 0,
+    clone_warning     BOOLEAN         NOT NULL DEFAULT 0,
+    user_verified     BOOLEAN         NOT NULL DEFAULT 0,
+    backup_eligible   BOOLEAN         NOT NULL DEFAULT 0,
+    backup_state      BOOLEAN         NOT NULL DEFAULT 0,
+    last_used_at      DATETIME        NULL,
+    created_at        DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IX_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+CREATE TABLE login_attempts (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    email         VARCHAR(255)    NOT NULL,
+    user_id       INTEGER         NULL REFERENCES users(id) ON DELETE SET NULL,
+    ip            VARCHAR(45)     NOT NULL,
+    user_agent    VARCHAR(400)    NULL,
+    success       BOOLEAN         NOT NULL,
+    reason        VARCHAR(32)     NOT NULL,
+    attempted_at  DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IX_login_attempts_email_attempted_at ON login_attempts (email, attempted_at);
+CREATE INDEX IX_login_attempts_ip_attempted_at ON login_attempts (ip, attempted_at);
+
+CREATE TABLE password_reset_tokens (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id     INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash  CHAR(64)        NOT NULL UNIQUE,
+    expires_at  DATETIME        NOT NULL,
+    used_at     DATETIME        NULL,
+    created_at  DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IX_password_reset_tokens_user_id_created_at ON password_reset_tokens (user_id, created_at);
+
+CREATE TABLE user_identities (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id        INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider       VARCHAR(64)     NOT NULL,
+    subject        VARCHAR(255)    NOT NULL,
+    email          VARCHAR(255)    NULL,
+    created_at     DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_login_at  DATETIME        NULL,
+
+    CONSTRAINT UQ_user_identities_provider_subject UNIQUE (provider, subject)
+);
+
+CREATE INDEX IX_user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE import_runs (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source       VARCHAR(260)    NOT NULL,
+    status       VARCHAR(16)     NOT NULL DEFAULT 'running',
+    rows_total   INTEGER         NULL,
+    inserted     INTEGER         NOT NULL DEFAULT 0,
+    skipped      INTEGER         NOT NULL DEFAULT 0,
+    failed       INTEGER         NOT NULL DEFAULT 0,
+    error        VARCHAR(1000)   NULL,
+    started_at   DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    finished_at  DATETIME        NULL,
+
+    CONSTRAINT CK_import_runs_status CHECK (status IN ('running', 'succeeded', 'failed'))
+);
+
+CREATE INDEX IX_import_runs_started_at ON import_runs (started_at DESC);
+
+-- Who changed what, and when. Rows are never updated or deleted, and
+-- there are no foreign keys, so entries outlive the users, keys and
+-- workspaces they mention.
+CREATE TABLE audit_log (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at       DATETIME        NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    actor_user_id     INTEGER         NULL,
+    actor_api_key_id  BIGINT          NULL,
+    user_id           INTEGER         NULL,
+    workspace_id      INTEGER         NULL,
+    action            VARCHAR(64)     NOT NULL,
+    entity_type       VARCHAR(32)     NOT NULL,
+    entity_id         VARCHAR(64)     NULL,
+    before_json       TEXT            NULL,
+    after_json        TEXT            NULL,
+    request_id        VARCHAR(64)     NULL,
+    ip                VARCHAR(45)     NULL
+);
+
+CREATE INDEX IX_audit_log_workspace_id_occurred_at ON audit_log (workspace_id, occurred_at);
+CREATE INDEX IX_audit_log_user_id_occurred_at ON audit_log (user_id, occurred_at);
+CREATE INDEX IX_audit_log_entity ON audit_log (entity_type, entity_id);
+
+CREATE TRIGGER TR_audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER TR_audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,7 @@
+-- Applied migrations, by file name. checksum is the SHA-256 of the file
+-- as applied; it is NULL for rows recorded before the runner tracked it.
+CREATE TABLE schema_migrations (
+    FileName VARCHAR(50) PRIMARY KEY,
+    applied_at DATETIME2(0) DEFAULT SYSUTCDATETIME(),
+    checksum CHAR(64) NULL
+)
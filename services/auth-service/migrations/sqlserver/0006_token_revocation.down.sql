@@ -0,0 +1,3 @@
+DROP TABLE dbo.revoked_tokens;
+
+ALTER TABLE dbo.users DROP COLUMN tokens_valid_after;
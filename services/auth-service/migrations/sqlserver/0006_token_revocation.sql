@@ -0,0 +1,16 @@
+ALTER TABLE dbo.users
+    ADD tokens_valid_after DATETIME2(0) NULL;
+
+CREATE TABLE dbo.revoked_tokens (
+    jti         VARCHAR(64)   NOT NULL PRIMARY KEY,
+    user_id     INT           NOT NULL,
+    expires_at  DATETIME2(0)  NOT NULL,
+    revoked_at  DATETIME2(0)  NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT FK_revoked_tokens_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE
+);
+
+CREATE NONCLUSTERED INDEX IX_revoked_tokens_expires_at
+    ON dbo.revoked_tokens (expires_at);
@@ -0,0 +1 @@
+DROP TABLE dbo.api_keys;
@@ -0,0 +1,22 @@
+CREATE TABLE dbo.api_keys (
+    id            INT IDENTITY(1,1) PRIMARY KEY,
+    user_id       INT            NOT NULL,
+    name          NVARCHAR(100)  NOT NULL,
+    prefix        VARCHAR(16)    NOT NULL,
+    key_hash      CHAR(64)       NOT NULL,
+    scopes        VARCHAR(400)   NOT NULL,
+    expires_at    DATETIME2(0)   NULL,
+    last_used_at  DATETIME2(0)   NULL,
+    revoked_at    DATETIME2(0)   NULL,
+    created_at    DATETIME2(0)   NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT FK_api_keys_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE,
+
+    CONSTRAINT UQ_api_keys_prefix
+      UNIQUE (prefix)
+);
+
+CREATE NONCLUSTERED INDEX IX_api_keys_user_id
+    ON dbo.api_keys (user_id);
@@ -0,0 +1,19 @@
+ALTER TABLE dbo.users ADD
+    totp_secret        VARCHAR(64)  NULL,
+    totp_enabled       BIT          NOT NULL DEFAULT 0,
+    totp_last_counter  BIGINT       NULL;
+
+CREATE TABLE dbo.recovery_codes (
+    id          INT IDENTITY(1,1) PRIMARY KEY,
+    user_id     INT           NOT NULL,
+    code_hash   CHAR(64)      NOT NULL,
+    used_at     DATETIME2(0)  NULL,
+    created_at  DATETIME2(0)  NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT FK_recovery_codes_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE
+);
+
+CREATE NONCLUSTERED INDEX IX_recovery_codes_user_id
+    ON dbo.recovery_codes (user_id);
@@ -0,0 +1,5 @@
+DROP TABLE dbo.webauthn_credentials;
+
+DROP INDEX UX_users_webauthn_user_handle ON dbo.users;
+
+ALTER TABLE dbo.users DROP COLUMN webauthn_user_handle;
@@ -0,0 +1,34 @@
+ALTER TABLE dbo.users
+    ADD webauthn_user_handle VARBINARY(64) NULL;
+
+CREATE UNIQUE NONCLUSTERED INDEX UX_users_webauthn_user_handle
+    ON dbo.users (webauthn_user_handle)
+    WHERE webauthn_user_handle IS NOT NULL;
+
+CREATE TABLE dbo.webauthn_credentials (
+    id                INT IDENTITY(1,1) PRIMARY KEY,
+    user_id           INT             NOT NULL,
+    name              NVARCHAR(100)   NOT NULL,
+    credential_id     VARBINARY(450)  NOT NULL,
+    public_key        VARBINARY(MAX)  NOT NULL,
+    attestation_type  VARCHAR(32)     NOT NULL,
+    aaguid            VARBINARY(16)   NULL,
+    transports        VARCHAR(200)    NOT NULL DEFAULT '',
+    sign_count        BIGINT          NOT NULL DEFAULT 0,
+    clone_warning     BIT             NOT NULL DEFAULT 0,
+    user_verified     BIT             NOT NULL DEFAULT 0,
+    backup_eligible   BIT             NOT NULL DEFAULT 0,
+    backup_state      BIT             NOT NULL DEFAULT 0,
+    last_used_at      DATETIME2(0)    NULL,
+    created_at        DATETIME2(0)    NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT FK_webauthn_credentials_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE,
+
+    CONSTRAINT UQ_webauthn_credentials_credential_id
+      UNIQUE (credential_id)
+);
+
+CREATE NONCLUSTERED INDEX IX_webauthn_credentials_user_id
+    ON dbo.webauthn_credentials (user_id);
@@ -0,0 +1,11 @@
+DROP TABLE dbo.login_attempts;
+
+-- The default on failed_login_count was created without a name.
+DECLARE @sql NVARCHAR(MAX) = N'';
+SELECT @sql += N'ALTER TABLE dbo.users DROP CONSTRAINT ' + QUOTENAME(dc.name) + N';'
+FROM sys.default_constraints dc
+JOIN sys.columns c ON c.object_id = dc.parent_object_id AND c.column_id = dc.parent_column_id
+WHERE dc.parent_object_id = OBJECT_ID(N'dbo.users') AND c.name = N'failed_login_count';
+EXEC sp_executesql @sql;
+
+ALTER TABLE dbo.users DROP COLUMN failed_login_count, locked_until;
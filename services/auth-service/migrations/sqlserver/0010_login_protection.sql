@@ -0,0 +1,24 @@
+ALTER TABLE dbo.users ADD
+    failed_login_count  INT           NOT NULL DEFAULT 0,
+    locked_until        DATETIME2(0)  NULL;
+
+CREATE TABLE dbo.login_attempts (
+    id            BIGINT IDENTITY(1,1) PRIMARY KEY,
+    email         NVARCHAR(255)  NOT NULL,
+    user_id       INT            NULL,
+    ip            VARCHAR(45)    NOT NULL,
+    user_agent    NVARCHAR(400)  NULL,
+    success       BIT            NOT NULL,
+    reason        VARCHAR(32)    NOT NULL,
+    attempted_at  DATETIME2(0)   NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT FK_login_attempts_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE SET NULL
+);
+
+CREATE NONCLUSTERED INDEX IX_login_attempts_email_attempted_at
+    ON dbo.login_attempts (email, attempted_at);
+
+CREATE NONCLUSTERED INDEX IX_login_attempts_ip_attempted_at
+    ON dbo.login_attempts (ip, attempted_at);
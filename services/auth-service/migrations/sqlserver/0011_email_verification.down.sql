@@ -0,0 +1,3 @@
+ALTER TABLE dbo.users DROP CONSTRAINT DF_users_email_verified;
+
+ALTER TABLE dbo.users DROP COLUMN email_verified, email_verified_at, verification_sent_at;
@@ -0,0 +1,10 @@
+-- Accounts that exist before this migration are treated as verified; the
+-- default is then switched so that new registrations start unverified.
+ALTER TABLE dbo.users ADD
+    email_verified        BIT           NOT NULL CONSTRAINT DF_users_email_verified DEFAULT 1,
+    email_verified_at     DATETIME2(0)  NULL,
+    verification_sent_at  DATETIME2(0)  NULL;
+
+ALTER TABLE dbo.users DROP CONSTRAINT DF_users_email_verified;
+
+ALTER TABLE dbo.users ADD CONSTRAINT DF_users_email_verified DEFAULT 0 FOR email_verified;
@@ -0,0 +1 @@
+DROP TABLE dbo.password_reset_tokens;
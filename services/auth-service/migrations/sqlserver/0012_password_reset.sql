@@ -0,0 +1,16 @@
+CREATE TABLE dbo.password_reset_tokens (
+    id          BIGINT IDENTITY(1,1) PRIMARY KEY,
+    user_id     INT            NOT NULL,
+    token_hash  CHAR(64)       NOT NULL,
+    expires_at  DATETIME2(0)   NOT NULL,
+    used_at     DATETIME2(0)   NULL,
+    created_at  DATETIME2(0)   NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT UQ_password_reset_tokens_token_hash UNIQUE (token_hash),
+    CONSTRAINT FK_password_reset_tokens_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE
+);
+
+CREATE NONCLUSTERED INDEX IX_password_reset_tokens_user_id_created_at
+    ON dbo.password_reset_tokens (user_id, created_at);
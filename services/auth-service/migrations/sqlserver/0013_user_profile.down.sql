@@ -0,0 +1,4 @@
+ALTER TABLE dbo.users DROP CONSTRAINT
+    CK_users_week_start, DF_users_base_currency, DF_users_timezone, DF_users_week_start;
+
+ALTER TABLE dbo.users DROP COLUMN display_name, base_currency, timezone, week_start, pending_email;
@@ -0,0 +1,10 @@
+ALTER TABLE dbo.users ADD
+    display_name   NVARCHAR(100)  NULL,
+    base_currency  CHAR(3)        NOT NULL CONSTRAINT DF_users_base_currency DEFAULT 'USD',
+    timezone       VARCHAR(64)    NOT NULL CONSTRAINT DF_users_timezone DEFAULT 'UTC',
+    -- 0 = Sunday ... 6 = Saturday, as Go's time.Weekday.
+    week_start     TINYINT        NOT NULL CONSTRAINT DF_users_week_start DEFAULT 1,
+    -- An address the user has asked to change to, pending verification.
+    pending_email  NVARCHAR(255)  NULL,
+
+    CONSTRAINT CK_users_week_start CHECK (week_start BETWEEN 0 AND 6);
@@ -0,0 +1,3 @@
+-- Users created through a provider keep their unusable '!' password and
+-- can only get back in through a password reset.
+DROP TABLE dbo.user_identities;
@@ -0,0 +1,21 @@
+-- Accounts at external OpenID Connect providers linked to local users.
+-- Users created through a provider have no password until they set one
+-- through a password reset; their hashed_password holds '!', which no
+-- hasher accepts.
+CREATE TABLE dbo.user_identities (
+    id             BIGINT IDENTITY(1,1) PRIMARY KEY,
+    user_id        INT            NOT NULL,
+    provider       VARCHAR(64)    NOT NULL,
+    subject        NVARCHAR(255)  NOT NULL,
+    email          NVARCHAR(255)  NULL,
+    created_at     DATETIME2(0)   NOT NULL DEFAULT SYSUTCDATETIME(),
+    last_login_at  DATETIME2(0)   NULL,
+
+    CONSTRAINT UQ_user_identities_provider_subject UNIQUE (provider, subject),
+    CONSTRAINT FK_user_identities_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE
+);
+
+CREATE NONCLUSTERED INDEX IX_user_identities_user_id
+    ON dbo.user_identities (user_id);
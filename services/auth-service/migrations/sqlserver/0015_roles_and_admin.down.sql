@@ -0,0 +1,5 @@
+DROP TABLE dbo.import_runs;
+
+ALTER TABLE dbo.users DROP CONSTRAINT CK_users_role, DF_users_role;
+
+ALTER TABLE dbo.users DROP COLUMN role, disabled_at;
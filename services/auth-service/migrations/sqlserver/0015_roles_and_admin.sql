@@ -0,0 +1,35 @@
+-- Roles and account disabling for the admin API. Promote the first
+-- administrator by hand:
+--   UPDATE dbo.users SET role = 'admin' WHERE email = '...';
+ALTER TABLE dbo.users ADD
+    role         VARCHAR(16)   NOT NULL CONSTRAINT DF_users_role DEFAULT 'user',
+    -- Set while the account is disabled; a disabled user cannot sign in
+    -- and none of their tokens or API keys are accepted.
+    disabled_at  DATETIME2(0)  NULL,
+
+    CONSTRAINT CK_users_role CHECK (role IN ('user', 'admin'));
+
+-- One row per run of the CSV import worker, so support can see what
+-- happened to a user's import without reading worker logs.
+CREATE TABLE dbo.import_runs (
+    id           BIGINT IDENTITY(1,1) PRIMARY KEY,
+    user_id      INT             NOT NULL,
+    source       NVARCHAR(260)   NOT NULL,
+    status       VARCHAR(16)     NOT NULL CONSTRAINT DF_import_runs_status DEFAULT 'running',
+    rows_total   INT             NULL,
+    inserted     INT             NOT NULL CONSTRAINT DF_import_runs_inserted DEFAULT 0,
+    skipped      INT             NOT NULL CONSTRAINT DF_import_runs_skipped DEFAULT 0,
+    failed       INT             NOT NULL CONSTRAINT DF_import_runs_failed DEFAULT 0,
+    error        NVARCHAR(1000)  NULL,
+    started_at   DATETIME2(0)    NOT NULL DEFAULT SYSUTCDATETIME(),
+    finished_at  DATETIME2(0)    NULL,
+
+    CONSTRAINT CK_import_runs_status CHECK (status IN ('running', 'succeeded', 'failed')),
+    CONSTRAINT FK_import_runs_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE
+);
+
+CREATE NONCLUSTERED INDEX IX_import_runs_started_at
+    ON dbo.import_runs (started_at DESC)
+    INCLUDE (user_id, status);
@@ -0,0 +1,22 @@
+-- Transactions and budgets go back to belonging to the user who created
+-- them. This fails if members of a shared workspace set budgets for the
+-- same category and month, which per-user budgets cannot represent.
+ALTER TABLE dbo.budgets DROP CONSTRAINT UQ_budgets_workspace_cat_month, FK_budgets_workspace_id;
+ALTER TABLE dbo.budgets DROP COLUMN workspace_id;
+ALTER TABLE dbo.budgets ADD CONSTRAINT UQ_budgets_user_cat_month
+    UNIQUE (user_id, category_id, [month]);
+
+ALTER TABLE dbo.transactions DROP CONSTRAINT UQ_transactions_workspace_import_id;
+ALTER TABLE dbo.transactions ADD CONSTRAINT UQ_transactions_user_import_id
+    UNIQUE (user_id, import_id);
+
+DROP INDEX IX_transactions_workspace_date ON dbo.transactions;
+ALTER TABLE dbo.transactions DROP CONSTRAINT FK_transactions_workspace_id;
+ALTER TABLE dbo.transactions DROP COLUMN workspace_id;
+
+ALTER TABLE dbo.users DROP CONSTRAINT FK_users_default_workspace_id;
+ALTER TABLE dbo.users DROP COLUMN default_workspace_id;
+
+DROP TABLE dbo.workspace_invites;
+DROP TABLE dbo.workspace_members;
+DROP TABLE dbo.workspaces;
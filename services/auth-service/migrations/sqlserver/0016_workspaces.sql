@@ -0,0 +1,109 @@
+-- Workspaces (households) own transactions and budgets; users take part
+-- through memberships. Every user gets a personal workspace, which is the
+-- one used when a request does not pick another.
+CREATE TABLE dbo.workspaces (
+    id          INT IDENTITY(1,1) PRIMARY KEY,
+    name        NVARCHAR(100)  NOT NULL,
+    created_at  DATETIME2(0)   NOT NULL DEFAULT SYSUTCDATETIME()
+);
+
+CREATE TABLE dbo.workspace_members (
+    workspace_id  INT           NOT NULL,
+    user_id       INT           NOT NULL,
+    -- owner: everything, including members; editor: change data;
+    -- viewer: read only.
+    role          VARCHAR(16)   NOT NULL,
+    created_at    DATETIME2(0)  NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT PK_workspace_members PRIMARY KEY (workspace_id, user_id),
+    CONSTRAINT CK_workspace_members_role CHECK (role IN ('owner', 'editor', 'viewer')),
+    CONSTRAINT FK_workspace_members_workspace_id
+      FOREIGN KEY (workspace_id) REFERENCES dbo.workspaces(id)
+      ON DELETE CASCADE,
+    CONSTRAINT FK_workspace_members_user_id
+      FOREIGN KEY (user_id) REFERENCES dbo.users(id)
+      ON DELETE CASCADE
+);
+
+CREATE NONCLUSTERED INDEX IX_workspace_members_user_id
+    ON dbo.workspace_members (user_id);
+
+-- Invitations are accepted through an emailed link; only a hash of the
+-- token is stored.
+CREATE TABLE dbo.workspace_invites (
+    id            BIGINT IDENTITY(1,1) PRIMARY KEY,
+    workspace_id  INT            NOT NULL,
+    email         NVARCHAR(255)  NOT NULL,
+    role          VARCHAR(16)    NOT NULL,
+    token_hash    CHAR(64)       NOT NULL,
+    invited_by    INT            NULL,
+    expires_at    DATETIME2(0)   NOT NULL,
+    accepted_at   DATETIME2(0)   NULL,
+    created_at    DATETIME2(0)   NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT UQ_workspace_invites_token_hash UNIQUE (token_hash),
+    CONSTRAINT CK_workspace_invites_role CHECK (role IN ('owner', 'editor', 'viewer')),
+    CONSTRAINT FK_workspace_invites_workspace_id
+      FOREIGN KEY (workspace_id) REFERENCES dbo.workspaces(id)
+      ON DELETE CASCADE,
+    CONSTRAINT FK_workspace_invites_invited_by
+      FOREIGN KEY (invited_by) REFERENCES dbo.users(id)
+);
+
+CREATE NONCLUSTERED INDEX IX_workspace_invites_workspace_id
+    ON dbo.workspace_invites (workspace_id);
+
+ALTER TABLE dbo.users ADD default_workspace_id INT NULL
+    CONSTRAINT FK_users_default_workspace_id REFERENCES dbo.workspaces(id);
+
+ALTER TABLE dbo.transactions ADD workspace_id INT NULL;
+ALTER TABLE dbo.budgets ADD workspace_id INT NULL;
+
+-- Give every existing user a personal workspace holding their data. The
+-- new columns are only visible to statements compiled after they exist,
+-- hence EXEC.
+EXEC('
+DECLARE @personal TABLE (workspace_id INT, user_id INT);
+
+MERGE dbo.workspaces AS w
+USING (SELECT id FROM dbo.users) AS u ON 1 = 0
+WHEN NOT MATCHED THEN INSERT (name) VALUES (N''Personal'')
+OUTPUT INSERTED.id, u.id INTO @personal;
+
+INSERT INTO dbo.workspace_members (workspace_id, user_id, role)
+SELECT workspace_id, user_id, ''owner'' FROM @personal;
+
+UPDATE u SET default_workspace_id = p.workspace_id
+FROM dbo.users u JOIN @personal p ON p.user_id = u.id;
+
+UPDATE t SET workspace_id = u.default_workspace_id
+FROM dbo.transactions t JOIN dbo.users u ON u.id = t.user_id;
+
+UPDATE b SET workspace_id = u.default_workspace_id
+FROM dbo.budgets b JOIN dbo.users u ON u.id = b.user_id;
+');
+
+ALTER TABLE dbo.transactions ALTER COLUMN workspace_id INT NOT NULL;
+ALTER TABLE dbo.transactions ADD CONSTRAINT FK_transactions_workspace_id
+    FOREIGN KEY (workspace_id) REFERENCES dbo.workspaces(id);
+
+CREATE NONCLUSTERED INDEX IX_transactions_workspace_date
+    ON dbo.transactions (workspace_id, [date])
+    INCLUDE (amount, category);
+
+-- Imports are de-duplicated within the workspace: a statement imported by
+-- two members is one set of rows, and a file imported into two workspaces
+-- is two. Each user's rows have just moved to their own workspace, so the
+-- existing rows already satisfy this.
+ALTER TABLE dbo.transactions DROP CONSTRAINT UQ_transactions_user_import_id;
+ALTER TABLE dbo.transactions ADD CONSTRAINT UQ_transactions_workspace_import_id
+    UNIQUE (workspace_id, import_id);
+
+-- Budgets belong to the workspace; user_id is whoever set them.
+ALTER TABLE dbo.budgets ALTER COLUMN workspace_id INT NOT NULL;
+ALTER TABLE dbo.budgets DROP CONSTRAINT UQ_budgets_user_cat_month;
+ALTER TABLE dbo.budgets ADD
+    CONSTRAINT FK_budgets_workspace_id
+      FOREIGN KEY (workspace_id) REFERENCES dbo.workspaces(id),
+    CONSTRAINT UQ_budgets_workspace_cat_month
+      UNIQUE (workspace_id, category_id, [month]);
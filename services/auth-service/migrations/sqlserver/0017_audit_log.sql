@@ -0,0 +1,40 @@
+-- Who changed what, and when. Rows are never updated or deleted, and
+-- there are no foreign keys, so entries outlive the users, keys and
+-- workspaces they mention.
+CREATE TABLE dbo.audit_log (
+    id                BIGINT IDENTITY(1,1) PRIMARY KEY,
+    occurred_at       DATETIME2(3)   NOT NULL DEFAULT SYSUTCDATETIME(),
+    -- The user who made the change; NULL for background jobs.
+    actor_user_id     INT            NULL,
+    actor_api_key_id  BIGINT         NULL,
+    -- The account the change concerns, if it is about one user, and the
+    -- workspace whose data it touched, if any.
+    user_id           INT            NULL,
+    workspace_id      INT            NULL,
+    action            VARCHAR(64)    NOT NULL,
+    entity_type       VARCHAR(32)    NOT NULL,
+    entity_id         NVARCHAR(64)   NULL,
+    before_json       NVARCHAR(MAX)  NULL,
+    after_json        NVARCHAR(MAX)  NULL,
+    request_id        VARCHAR(64)    NULL,
+    ip                VARCHAR(45)    NULL
+);
+
+CREATE NONCLUSTERED INDEX IX_audit_log_workspace_id_occurred_at
+    ON dbo.audit_log (workspace_id, occurred_at);
+
+CREATE NONCLUSTERED INDEX IX_audit_log_user_id_occurred_at
+    ON dbo.audit_log (user_id, occurred_at);
+
+CREATE NONCLUSTERED INDEX IX_audit_log_entity
+    ON dbo.audit_log (entity_type, entity_id);
+
+-- CREATE TRIGGER must start its own batch.
+EXEC('
+CREATE TRIGGER dbo.TR_audit_log_append_only ON dbo.audit_log
+INSTEAD OF UPDATE, DELETE
+AS
+BEGIN
+    THROW 50000, ''audit_log is append-only'', 1;
+END
+');
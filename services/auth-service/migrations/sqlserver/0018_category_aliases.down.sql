@@ -0,0 +1 @@
+DROP TABLE dbo.category_aliases;
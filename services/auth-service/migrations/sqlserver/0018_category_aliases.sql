@@ -0,0 +1,16 @@
+-- Other spellings of a category name, e.g. 'Cafe' for 'Coffee', so that
+-- imported transactions count against the right budget. The budgets report
+-- has always read this table, but it was created by hand, so keep any
+-- that already exists.
+IF OBJECT_ID('dbo.category_aliases', 'U') IS NULL
+CREATE TABLE dbo.category_aliases (
+    id           INT IDENTITY(1,1) PRIMARY KEY,
+    alias        NVARCHAR(80)  NOT NULL,
+    category_id  INT           NOT NULL,
+    created_at   DATETIME2(0)  NOT NULL DEFAULT SYSUTCDATETIME(),
+
+    CONSTRAINT UQ_category_aliases_alias UNIQUE (alias),
+    CONSTRAINT FK_category_aliases_category_id
+      FOREIGN KEY (category_id) REFERENCES dbo.categories(id)
+      ON DELETE CASCADE
+);
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// AdminUser is a user as shown to administrators.
+type AdminUser struct {
+	ID            int64      `json:"id"`
+	Email         string     `json:"email"`
+	DisplayName   *string    `json:"display_name"`
+	Role          string     `json:"role"`
+	EmailVerified bool       `json:"email_verified"`
+	TOTPEnabled   bool       `json:"totp_enabled"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DisabledAt    *time.Time `json:"disabled_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// UserFilter narrows ListUsers. Zero values match everything.
+type UserFilter struct {
+	// Email matches any address containing it, ignoring case.
+	Email    string
+	Role     string
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+const adminUserColumns = `
+	id, email, display_name, role, email_verified, totp_enabled, created_at, disabled_at, locked_until`
+
+func scanAdminUser(row scanner) (AdminUser, error) {
+	var u AdminUser
+	err := row.Scan(&u.ID, &u.Email, &u.DisplayName, &u.Role, &u.EmailVerified, &u.TOTPEnabled,
+		&u.CreatedAt, &u.DisabledAt, &u.LockedUntil)
+	return u, err
+}
+
+// ListUsers returns users matching f, newest first.
+func ListUsers(ctx context.Context, db *sqldb.DB, f UserFilter) ([]AdminUser, error) {
+	var where []string
+	var args []any
+	if f.Email != "" {
+		where = append(where, "LOWER(email) LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(strings.ToLower(f.Email))+"%")
+	}
+	if f.Role != "" {
+		where = append(where, "role = ?")
+		args = append(args, f.Role)
+	}
+	if f.Disabled != nil {
+		if *f.Disabled {
+			where = append(where, "disabled_at IS NOT NULL")
+		} else {
+			where = append(where, "disabled_at IS NULL")
+		}
+	}
+	sqlStatement := `SELECT` + adminUserColumns + ` FROM users`
+	if len(where) > 0 {
+		sqlStatement += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlStatement += " ORDER BY id DESC " + db.Dialect.Limit(f.Limit, f.Offset)
+
+	rows, err := db.QueryContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func GetAdminUser(ctx context.Context, db *sqldb.DB, id int64) (AdminUser, error) {
+	row := db.QueryRowContext(ctx, `SELECT`+adminUserColumns+` FROM users WHERE id = ?`, id)
+	return scanAdminUser(row)
+}
+
+// SetUserDisabled disables or re-enables an account. It returns
+// sql.ErrNoRows if there is no such user. Disabling does not by itself end
+// the user's sessions; see RevocationStore.InvalidateUser.
+func SetUserDisabled(ctx context.Context, db *sqldb.DB, id int64, disabled bool) error {
+	sqlStatement := `
+	UPDATE users SET disabled_at = NULL WHERE id = ?`
+	args := []any{id}
+	if disabled {
+		sqlStatement = `
+		UPDATE users SET disabled_at = COALESCE(disabled_at, ?) WHERE id = ?`
+		args = []any{time.Now().UTC(), id}
+	}
+
+	res, err := db.ExecContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetUserRole returns sql.ErrNoRows if there is no such user.
+func SetUserRole(ctx context.Context, db *sqldb.DB, id int64, role string) error {
+	sqlStatement := `
+	UPDATE users SET role = ? WHERE id = ?`
+
+	res, err := db.ExecContext(ctx, sqlStatement, role, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const (
+	ImportRunning   = "running"
+	ImportSucceeded = "succeeded"
+	ImportFailed    = "failed"
+)
+
+// ImportRun is one run of the CSV import worker.
+type ImportRun struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Source     string     `json:"source"`
+	Status     string     `json:"status"`
+	RowsTotal  *int       `json:"rows_total"`
+	Inserted   int        `json:"inserted"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	Error      *string    `json:"error"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// ImportRunFilter narrows ListImportRuns. Zero values match everything.
+type ImportRunFilter struct {
+	UserID int64
+	Status string
+	Limit  int
+	Offset int
+}
+
+// ListImportRuns returns import runs matching f, most recent first.
+func ListImportRuns(ctx context.Context, db *sqldb.DB, f ImportRunFilter) ([]ImportRun, error) {
+	var where []string
+	var args []any
+	if f.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	sqlStatement := `
+	SELECT id, user_id, source, status, rows_total, inserted, skipped, failed, error, started_at, finished_at
+	FROM import_runs`
+	if len(where) > 0 {
+		sqlStatement += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlStatement += " ORDER BY started_at DESC, id DESC " + db.Dialect.Limit(f.Limit, f.Offset)
+
+	rows, err := db.QueryContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ImportRun{}
+	for rows.Next() {
+		var r ImportRun
+		if err := rows.Scan(&r.ID, &r.UserID, &r.Source, &r.Status, &r.RowsTotal, &r.Inserted, &r.Skipped,
+			&r.Failed, &r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`).Replace(s)
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeReadAnalytics     = "read:analytics"
+	ScopeWriteTransactions = "write:transactions"
+)
+
+// ValidScopes lists every scope an API key may be granted.
+var ValidScopes = []string{ScopeReadAnalytics, ScopeWriteTransactions}
+
+// APIKeyPrefix marks a bearer credential as an API key rather than a JWT.
+const APIKeyPrefix = "fdk_"
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyExpired = errors.New("API key expired or revoked")
+)
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// GenerateAPIKey returns a new plaintext key and its lookup prefix. Keys look
+// like fdk_<prefix>_<secret>; only the prefix and a hash are ever stored.
+func GenerateAPIKey() (key string, prefix string, err error) {
+	p := make([]byte, 5)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix = strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(p))
+	key = APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, nil
+}
+
+// HashAPIKey hashes a plaintext key for storage. Keys carry 256 bits of
+// randomness, so a fast hash is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKeyPrefix extracts the lookup prefix from a plaintext key.
+func parseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+func InsertAPIKey(ctx context.Context, db *sqldb.DB, uid int64, name, prefix, hash string, scopes []string, expiresAt *time.Time) (APIKey, error) {
+	output, returning := db.Dialect.Returning("INSERTED", "id", "created_at")
+	sqlStatement := `
+	INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+	` + output + `
+	VALUES (?, ?, ?, ?, ?, ?)` + returning
+
+	var exp sql.NullTime
+	if expiresAt != nil {
+		exp = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+
+	k := APIKey{UserID: uid, Name: name, Prefix: prefix, Scopes: scopes, ExpiresAt: expiresAt}
+	row := db.QueryRowContext(ctx, sqlStatement, uid, name, prefix, hash, strings.Join(scopes, " "), exp)
+	if err := row.Scan(&k.ID, &k.CreatedAt); err != nil {
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+// ListAPIKeys returns the user's keys that have not been revoked.
+func ListAPIKeys(ctx context.Context, db *sqldb.DB, uid int64) ([]APIKey, error) {
+	sqlStatement := `
+	SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+	FROM api_keys
+	WHERE user_id = ? AND revoked_at IS NULL
+	ORDER BY created_at DESC`
+
+	rows, err := db.QueryContext(ctx, sqlStatement, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]APIKey, 0, 8)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RevokeAPIKey revokes one of the user's keys. It returns sql.ErrNoRows if
+// the key does not exist, belongs to someone else or is already revoked.
+func RevokeAPIKey(ctx context.Context, db *sqldb.DB, uid, id int64) error {
+	sqlStatement := `
+	UPDATE api_keys SET revoked_at = ?
+	WHERE id = ? AND user_id = ? AND revoked_at IS NULL`
+
+	res, err := db.ExecContext(ctx, sqlStatement, time.Now().UTC(), id, uid)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetAPIKeyByPrefix loads a key and its stored hash, along with the owner's
+// email. Revoked keys are returned too so the caller can tell them apart.
+func GetAPIKeyByPrefix(ctx context.Context, db *sqldb.DB, prefix string) (APIKey, string, string, bool, error) {
+	sqlStatement := `
+	SELECT k.id, k.user_id, k.name, k.prefix, k.scopes, k.expires_at, k.last_used_at, k.created_at,
+	       k.key_hash, u.email, CASE WHEN k.revoked_at IS NULL THEN 0 ELSE 1 END
+	FROM api_keys k
+	JOIN users u ON u.id = k.user_id
+	WHERE k.prefix = ? AND u.disabled_at IS NULL`
+
+	row := db.QueryRowContext(ctx, sqlStatement, prefix)
+	var hash, email string
+	var revoked bool
+	k, err := scanAPIKey(row, &hash, &email, &revoked)
+	if err != nil {
+		return APIKey{}, "", "", false, err
+	}
+	return k, hash, email, revoked, nil
+}
+
+func TouchAPIKey(ctx context.Context, db *sqldb.DB, id int64, at time.Time) error {
+	sqlStatement := `
+	UPDATE api_keys SET last_used_at = ? WHERE id = ?`
+
+	_, err := db.ExecContext(ctx, sqlStatement, at.UTC(), id)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanAPIKey scans the common api_keys columns followed by any extra
+// destinations the query selected after them.
+func scanAPIKey(s scanner, extra ...any) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	var exp, used sql.NullTime
+	dest := append([]any{&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes, &exp, &used, &k.CreatedAt}, extra...)
+	if err := s.Scan(dest...); err != nil {
+		return APIKey{}, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if exp.Valid {
+		t := exp.Time.UTC()
+		k.ExpiresAt = &t
+	}
+	if used.Valid {
+		t := used.Time.UTC()
+		k.LastUsedAt = &t
+	}
+	return k, nil
+}
+
+// APIKeyStore verifies API keys presented to the auth middleware and records
+// when they were last used.
+type APIKeyStore struct {
+	db *sqldb.DB
+
+	// TouchEvery limits how often last_used_at is written for a busy key.
+	TouchEvery time.Duration
+
+	mu      sync.Mutex
+	touched map[int64]time.Time
+}
+
+func NewAPIKeyStore(db *sqldb.DB) *APIKeyStore {
+	return &APIKeyStore{db: db, TouchEvery: time.Minute, touched: map[int64]time.Time{}}
+}
+
+// Verify checks a plaintext key and returns it with the owner's email.
+func (s *APIKeyStore) Verify(ctx context.Context, key string) (APIKey, string, error) {
+	prefix, ok := parseAPIKeyPrefix(key)
+	if !ok {
+		return APIKey{}, "", ErrInvalidAPIKey
+	}
+
+	k, hash, email, revoked, err := GetAPIKeyByPrefix(ctx, s.db, prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, "", ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(hash)) != 1 {
+		return APIKey{}, "", ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if revoked || (k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)) {
+		return APIKey{}, "", ErrAPIKeyExpired
+	}
+
+	s.mu.Lock()
+	due := now.Sub(s.touched[k.ID]) >= s.TouchEvery
+	if due {
+		s.touched[k.ID] = now
+	}
+	s.mu.Unlock()
+	if due {
+		if err := TouchAPIKey(ctx, s.db, k.ID, now); err != nil {
+			return APIKey{}, "", err
+		}
+	}
+	return k, email, nil
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Audit actions, named entity.verb. New actions must keep to this form so
+// the log can be filtered by prefix.
+const (
+	AuditUserLogin          = "user.login"
+	AuditUserProfileUpdate  = "user.profile_update"
+	AuditUserPasswordChange = "user.password_change"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserEmailChange    = "user.email_change"
+	AuditUserDisable        = "user.disable"
+	AuditUserEnable         = "user.enable"
+	AuditUserRoleChange     = "user.role_change"
+	AuditUserForceLogout    = "user.force_logout"
+
+	AuditUserTOTPEnable              = "user.totp_enable"
+	AuditUserTOTPDisable             = "user.totp_disable"
+	AuditUserRecoveryCodesRegenerate = "user.recovery_codes_regenerate"
+	AuditUserPasskeyAdd              = "user.passkey_add"
+	AuditUserPasskeyDelete           = "user.passkey_delete"
+	AuditUserIdentityLink            = "user.identity_link"
+	AuditUserIdentityUnlink          = "user.identity_unlink"
+
+	AuditAPIKeyCreate = "api_key.create"
+	AuditAPIKeyRevoke = "api_key.revoke"
+
+	AuditWorkspaceCreate       = "workspace.create"
+	AuditWorkspaceRename       = "workspace.rename"
+	AuditWorkspaceMemberRole   = "workspace.member_role"
+	AuditWorkspaceMemberRemove = "workspace.member_remove"
+	AuditWorkspaceInvite       = "workspace.invite"
+	AuditWorkspaceInviteRevoke = "workspace.invite_revoke"
+	AuditWorkspaceJoin         = "workspace.join"
+
+	AuditTransactionUpdate = "transaction.update"
+	AuditTransactionDelete = "transaction.delete"
+	AuditBudgetSet         = "budget.set"
+	AuditBudgetDelete      = "budget.delete"
+	AuditCategoryMerge     = "category.merge"
+
+	// AuditImportRun and AuditTransactionImport are written by the CSV
+	// import worker, once per run and once per row it inserts.
+	AuditImportRun         = "import.run"
+	AuditTransactionImport = "transaction.import"
+)
+
+// Entity types recorded in the audit log.
+const (
+	AuditEntityUser            = "user"
+	AuditEntityAPIKey          = "api_key"
+	AuditEntityWorkspace       = "workspace"
+	AuditEntityWorkspaceMember = "workspace_member"
+	AuditEntityWorkspaceInvite = "workspace_invite"
+	AuditEntityTransaction     = "transaction"
+	AuditEntityBudget          = "budget"
+	AuditEntityCategory        = "category"
+	AuditEntityImport          = "import"
+)
+
+// AuditEntry is one row of the audit log. Before and After are marshalled
+// to JSON when recorded and come back as json.RawMessage when listed; nil
+// means the entity did not exist on that side of the change.
+type AuditEntry struct {
+	ID            int64     `json:"id"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	ActorUserID   *int64    `json:"actor_user_id"`
+	ActorAPIKeyID *int64    `json:"actor_api_key_id,omitempty"`
+	UserID        *int64    `json:"user_id"`
+	WorkspaceID   *int64    `json:"workspace_id"`
+	Action        string    `json:"action"`
+	EntityType    string    `json:"entity_type"`
+	EntityID      string    `json:"entity_id,omitempty"`
+	Before        any       `json:"before"`
+	After         any       `json:"after"`
+	RequestID     string    `json:"request_id,omitempty"`
+	IP            string    `json:"ip,omitempty"`
+}
+
+func auditJSON(v any) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// AuditActor is who made a change and from where. Model functions that
+// record their own entries take one from the handler.
+type AuditActor struct {
+	UserID    *int64
+	APIKeyID  *int64
+	RequestID string
+	IP        string
+}
+
+func (a AuditActor) entry(e AuditEntry) AuditEntry {
+	e.ActorUserID, e.ActorAPIKeyID = a.UserID, a.APIKeyID
+	e.RequestID, e.IP = a.RequestID, a.IP
+	return e
+}
+
+// recordAudit appends e using q, so that a change and its audit row can be
+// written in the same transaction.
+func recordAudit(ctx context.Context, q execer, e AuditEntry) error {
+	before, err := auditJSON(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := auditJSON(e.After)
+	if err != nil {
+		return err
+	}
+	sqlStatement := `
+	INSERT INTO audit_log (actor_user_id, actor_api_key_id, user_id, workspace_id, action, entity_type,
+		entity_id, before_json, after_json, request_id, ip)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = q.ExecContext(ctx, sqlStatement, e.ActorUserID, e.ActorAPIKeyID, e.UserID, e.WorkspaceID,
+		e.Action, e.EntityType, nullString(e.EntityID), before, after, nullString(e.RequestID), nullString(e.IP))
+	return err
+}
+
+// AuditLog reads the audit log and records changes to accounts and
+// workspaces: handlers hold one and call Record after a change succeeds.
+// Changes to the ledger are not recorded through it. UpdateTransaction,
+// SetBudget, MergeCategories and the other functions that change
+// transactions, budgets or categories call recordAudit in the transaction
+// that makes the change, as the CSV worker does for the rows it imports, so
+// that no such change is committed without its entry.
+type AuditLog struct {
+	db *sqldb.DB
+}
+
+func NewAuditLog(db *sqldb.DB) *AuditLog {
+	return &AuditLog{db: db}
+}
+
+func (l *AuditLog) Record(ctx context.Context, e AuditEntry) error {
+	return recordAudit(ctx, l.db, e)
+}
+
+// AuditFilter narrows AuditLog.List. Zero values match everything.
+type AuditFilter struct {
+	// VisibleTo, if set, limits the result to what a member may see:
+	// entries about their workspace or about themselves.
+	VisibleTo *AuditScope
+
+	ActorUserID int64
+	UserID      int64
+	WorkspaceID int64
+	// Action matches exactly, or every action of an entity when it ends in
+	// ".", e.g. "workspace.".
+	Action     string
+	EntityType string
+	EntityID   string
+	RequestID  string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+// AuditScope is what a workspace member may read of the log.
+type AuditScope struct {
+	UserID      int64
+	WorkspaceID int64
+}
+
+// List returns entries matching f, newest first.
+func (l *AuditLog) List(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	var where []string
+	var args []any
+	if f.VisibleTo != nil {
+		where = append(where, "(workspace_id = ? OR (workspace_id IS NULL AND user_id = ?))")
+		args = append(args, f.VisibleTo.WorkspaceID, f.VisibleTo.UserID)
+	}
+	if f.ActorUserID != 0 {
+		where = append(where, "actor_user_id = ?")
+		args = append(args, f.ActorUserID)
+	}
+	if f.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.WorkspaceID != 0 {
+		where = append(where, "workspace_id = ?")
+		args = append(args, f.WorkspaceID)
+	}
+	if strings.HasSuffix(f.Action, ".") {
+		where = append(where, "action LIKE ? ESCAPE '\\'")
+		args = append(args, escapeLike(f.Action)+"%")
+	} else if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.EntityType != "" {
+		where = append(where, "entity_type = ?")
+		args = append(args, f.EntityType)
+	}
+	if f.EntityID != "" {
+		where = append(where, "entity_id = ?")
+		args = append(args, f.EntityID)
+	}
+	if f.RequestID != "" {
+		where = append(where, "request_id = ?")
+		args = append(args, f.RequestID)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "occurred_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		where = append(where, "occurred_at < ?")
+		args = append(args, f.To)
+	}
+	sqlStatement := `
+	SELECT id, occurred_at, actor_user_id, actor_api_key_id, user_id, workspace_id, action, entity_type,
+		entity_id, before_json, after_json, request_id, ip
+	FROM audit_log`
+	if len(where) > 0 {
+		sqlStatement += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlStatement += " ORDER BY id DESC " + l.db.Dialect.Limit(f.Limit, f.Offset)
+
+	rows, err := l.db.QueryContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var entityID, before, after, requestID, ip sql.NullString
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &e.ActorUserID, &e.ActorAPIKeyID, &e.UserID, &e.WorkspaceID,
+			&e.Action, &e.EntityType, &entityID, &before, &after, &requestID, &ip); err != nil {
+			return nil, err
+		}
+		e.EntityID, e.RequestID, e.IP = entityID.String, requestID.String, ip.String
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Budget is the monthly limit a workspace set for a category from Month
+// on. SetBy is the member who last changed it.
+type Budget struct {
+	ID          int64     `json:"id"`
+	WorkspaceID int64     `json:"workspace_id"`
+	CategoryID  int64     `json:"category_id"`
+	Category    string    `json:"category"`
+	Month       time.Time `json:"month"`
+	Limit       float64   `json:"limit"`
+	SetBy       int64     `json:"set_by"`
+}
+
+// firstOfMonth returns midnight UTC on the first day of t's month, the
+// form budgets are stored in.
+func firstOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// lockBudget reads the workspace's budget for the category and month and
+// holds it until the transaction ends, or returns sql.ErrNoRows.
+func lockBudget(ctx context.Context, tx *sqldb.Tx, wid int64, c Category, month time.Time) (Budget, error) {
+	hint, suffix := tx.Dialect.LockRows()
+	b := Budget{WorkspaceID: wid, CategoryID: c.ID, Category: c.Name, Month: month}
+	err := tx.QueryRowContext(ctx, `
+	SELECT id, monthly_limit, user_id
+	FROM budgets `+hint+`
+	WHERE workspace_id = ? AND category_id = ? AND month = ?`+suffix, wid, c.ID, month).
+		Scan(&b.ID, &b.Limit, &b.SetBy)
+	return b, err
+}
+
+func budgetAudit(by AuditActor, action string, b Budget, before, after any) AuditEntry {
+	return by.entry(AuditEntry{
+		WorkspaceID: &b.WorkspaceID,
+		Action:      action,
+		EntityType:  AuditEntityBudget,
+		EntityID:    strconv.FormatInt(b.ID, 10),
+		Before:      before,
+		After:       after,
+	})
+}
+
+// SetBudget sets the workspace's limit for a category, by name or alias,
+// from month on, and records the change. uid is the member setting it. It
+// returns ErrNoCategory if the category does not exist.
+func SetBudget(ctx context.Context, db *sqldb.DB, wid, uid int64, category string, month time.Time, limit float64, by AuditActor) (Budget, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Budget{}, err
+	}
+	defer tx.Rollback()
+
+	c, err := categoryByName(ctx, tx, category)
+	if err != nil {
+		return Budget{}, err
+	}
+	month = firstOfMonth(month)
+	b, err := lockBudget(ctx, tx, wid, c, month)
+	var before any
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		output, returning := tx.Dialect.Returning("INSERTED", "id")
+		err = tx.QueryRowContext(ctx, `
+		INSERT INTO budgets (user_id, workspace_id, category_id, month, monthly_limit)
+		`+output+`
+		VALUES (?, ?, ?, ?, ?)`+returning, uid, wid, c.ID, month, limit).Scan(&b.ID)
+	case err == nil:
+		before = b
+		_, err = tx.ExecContext(ctx, `
+		UPDATE budgets SET monthly_limit = ?, user_id = ? WHERE id = ?`, limit, uid, b.ID)
+	}
+	if err != nil {
+		return Budget{}, err
+	}
+	b.Limit, b.SetBy = limit, uid
+	if err := recordAudit(ctx, tx, budgetAudit(by, AuditBudgetSet, b, before, b)); err != nil {
+		return Budget{}, err
+	}
+	return b, tx.Commit()
+}
+
+// DeleteBudget removes the limit the workspace set for a category in
+// month, so that the one set before it, if any, applies again, and records
+// what it was. It returns ErrNoCategory or sql.ErrNoRows if there is no
+// such budget.
+func DeleteBudget(ctx context.Context, db *sqldb.DB, wid int64, category string, month time.Time, by AuditActor) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c, err := categoryByName(ctx, tx, category)
+	if err != nil {
+		return err
+	}
+	b, err := lockBudget(ctx, tx, wid, c, firstOfMonth(month))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	DELETE FROM budgets WHERE id = ?`, b.ID); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, budgetAudit(by, AuditBudgetDelete, b, b, nil)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
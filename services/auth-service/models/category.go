@@ -0,0 +1,230 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uncategorized is the category transactions fall back to. The budget
+// queries rely on it, so it cannot be merged away.
+const uncategorized = "Uncategorized"
+
+var (
+	ErrNoCategory         = errors.New("no such category")
+	ErrSameCategory       = errors.New("a category cannot be merged into itself")
+	ErrMergeUncategorized = errors.New("the Uncategorized category cannot be merged away")
+)
+
+type Category struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// CategoryMerge describes a merge of From into Into: how many transactions
+// were moved to Into and how many budgets were moved or added to its own.
+type CategoryMerge struct {
+	From         string `json:"from"`
+	Into         string `json:"into"`
+	Transactions int64  `json:"transactions"`
+	Budgets      int64  `json:"budgets"`
+}
+
+// categoryByName finds a category by name or, failing that, by alias,
+// ignoring case as the budget queries do.
+func categoryByName(ctx context.Context, tx *sqldb.Tx, name string) (Category, error) {
+	var c Category
+	err := tx.QueryRowContext(ctx, `
+	SELECT id, name FROM categories WHERE LOWER(name) = LOWER(?)`, name).Scan(&c.ID, &c.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, `
+		SELECT c.id, c.name
+		FROM category_aliases a
+		JOIN categories c ON c.id = a.category_id
+		WHERE LOWER(a.alias) = LOWER(?)`, name).Scan(&c.ID, &c.Name)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return Category{}, ErrNoCategory
+	}
+	return c, err
+}
+
+// MergeCategories folds the category from into into, for every workspace:
+// transactions filed under from are refiled under into, from's budgets
+// become into's (added to into's own limit where both have one for the
+// same month), and from and its aliases become aliases of into, so later
+// imports land in into too.
+//
+// Each workspace whose transactions or budgets changed gets an audit entry
+// of its own, so that its members can see why their totals moved; one
+// more, with no workspace, records the whole merge.
+func MergeCategories(ctx context.Context, db *sqldb.DB, from, into string, by AuditActor) (CategoryMerge, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return CategoryMerge{}, err
+	}
+	defer tx.Rollback()
+
+	src, err := categoryByName(ctx, tx, from)
+	if err != nil {
+		return CategoryMerge{}, err
+	}
+	dst, err := categoryByName(ctx, tx, into)
+	if err != nil {
+		return CategoryMerge{}, err
+	}
+	if src.ID == dst.ID {
+		return CategoryMerge{}, ErrSameCategory
+	}
+	if strings.EqualFold(src.Name, uncategorized) {
+		return CategoryMerge{}, ErrMergeUncategorized
+	}
+
+	total := CategoryMerge{From: src.Name, Into: dst.Name}
+	perWorkspace := map[int64]*CategoryMerge{}
+	in := func(wid int64) *CategoryMerge {
+		m, ok := perWorkspace[wid]
+		if !ok {
+			m = &CategoryMerge{From: src.Name, Into: dst.Name}
+			perWorkspace[wid] = m
+		}
+		return m
+	}
+
+	// Transactions filed under one of from's aliases are not rewritten, but
+	// they follow the alias to into all the same.
+	rows, err := tx.QueryContext(ctx, `
+	SELECT workspace_id, COUNT(*)
+	FROM transactions
+	WHERE LOWER(category) = LOWER(?)
+	   OR LOWER(category) IN (SELECT LOWER(alias) FROM category_aliases WHERE category_id = ?)
+	GROUP BY workspace_id`, src.Name, src.ID)
+	if err != nil {
+		return CategoryMerge{}, err
+	}
+	for rows.Next() {
+		var wid, n int64
+		if err := rows.Scan(&wid, &n); err != nil {
+			rows.Close()
+			return CategoryMerge{}, err
+		}
+		in(wid).Transactions = n
+		total.Transactions += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return CategoryMerge{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE transactions SET category = ? WHERE LOWER(category) = LOWER(?)`, dst.Name, src.Name); err != nil {
+		return CategoryMerge{}, err
+	}
+
+	if err := mergeBudgets(ctx, tx, src.ID, dst.ID, func(wid int64) {
+		in(wid).Budgets++
+		total.Budgets++
+	}); err != nil {
+		return CategoryMerge{}, err
+	}
+
+	for _, q := range []struct {
+		sql  string
+		args []any
+	}{
+		{`DELETE FROM category_aliases WHERE LOWER(alias) = LOWER(?)`, []any{src.Name}},
+		{`UPDATE category_aliases SET category_id = ? WHERE category_id = ?`, []any{dst.ID, src.ID}},
+		{`INSERT INTO category_aliases (alias, category_id) VALUES (?, ?)`, []any{src.Name, dst.ID}},
+		{`DELETE FROM categories WHERE id = ?`, []any{src.ID}},
+	} {
+		if _, err := tx.ExecContext(ctx, q.sql, q.args...); err != nil {
+			return CategoryMerge{}, err
+		}
+	}
+
+	entry := func(wid *int64, m CategoryMerge) AuditEntry {
+		return by.entry(AuditEntry{
+			WorkspaceID: wid,
+			Action:      AuditCategoryMerge,
+			EntityType:  AuditEntityCategory,
+			EntityID:    strconv.FormatInt(src.ID, 10),
+			Before:      src,
+			After:       m,
+		})
+	}
+	wids := make([]int64, 0, len(perWorkspace))
+	for wid := range perWorkspace {
+		wids = append(wids, wid)
+	}
+	slices.Sort(wids)
+	for _, wid := range wids {
+		if err := recordAudit(ctx, tx, entry(&wid, *perWorkspace[wid])); err != nil {
+			return CategoryMerge{}, err
+		}
+	}
+	if err := recordAudit(ctx, tx, entry(nil, total)); err != nil {
+		return CategoryMerge{}, err
+	}
+	return total, tx.Commit()
+}
+
+// mergeBudgets moves the budgets of category src to dst, adding a limit to
+// dst's own where the workspace has one for the same month, and calls moved
+// with the workspace of each.
+func mergeBudgets(ctx context.Context, tx *sqldb.Tx, src, dst int64, moved func(wid int64)) error {
+	type budget struct {
+		id, wid, category int64
+		month             time.Time
+		limit             float64
+	}
+	type key struct{ wid, month int64 }
+
+	hint, suffix := tx.Dialect.LockRows()
+	rows, err := tx.QueryContext(ctx, `
+	SELECT id, workspace_id, category_id, month, monthly_limit
+	FROM budgets `+hint+`
+	WHERE category_id IN (?, ?)`+suffix, src, dst)
+	if err != nil {
+		return err
+	}
+	var moving []budget
+	existing := map[key]budget{}
+	for rows.Next() {
+		var b budget
+		if err := rows.Scan(&b.id, &b.wid, &b.category, &b.month, &b.limit); err != nil {
+			rows.Close()
+			return err
+		}
+		if b.category == src {
+			moving = append(moving, b)
+		} else {
+			existing[key{b.wid, b.month.Unix()}] = b
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range moving {
+		if d, ok := existing[key{b.wid, b.month.Unix()}]; ok {
+			if _, err := tx.ExecContext(ctx, `
+			UPDATE budgets SET monthly_limit = ? WHERE id = ?`, d.limit+b.limit, d.id); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+			DELETE FROM budgets WHERE id = ?`, b.id); err != nil {
+				return err
+			}
+		} else if _, err := tx.ExecContext(ctx, `
+		UPDATE budgets SET category_id = ? WHERE id = ?`, dst, b.id); err != nil {
+			return err
+		}
+		moved(b.wid)
+	}
+	return nil
+}
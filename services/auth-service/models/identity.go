@@ -0,0 +1,180 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// NoPasswordHash is stored for users who signed up through an external
+// provider and never set a password. No hasher accepts it.
+const NoPasswordHash = "!"
+
+var (
+	ErrIdentityLinked = errors.New("identity already linked to an account")
+	// ErrLastLoginMethod is returned when unlinking an identity or deleting
+	// a passkey would leave the user with no way to sign in.
+	ErrLastLoginMethod = errors.New("cannot remove the last way to sign in")
+)
+
+// Identity is an account at an external provider linked to a user.
+type Identity struct {
+	ID          int64      `json:"id"`
+	Provider    string     `json:"provider"`
+	Email       *string    `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// GetUserByIdentity returns the user linked to the provider's subject and
+// records the login.
+func GetUserByIdentity(ctx context.Context, db *sqldb.DB, provider, subject string) (User, error) {
+	output, returning := db.Dialect.Returning("INSERTED", "user_id")
+	sqlStatement := `
+	UPDATE user_identities SET last_login_at = ?
+	` + output + `
+	WHERE provider = ? AND subject = ?` + returning
+
+	var uid int64
+	if err := db.QueryRowContext(ctx, sqlStatement, time.Now().UTC(), provider, subject).Scan(&uid); err != nil {
+		return User{}, err
+	}
+	u, _, err := GetUserByID(ctx, db, uid)
+	return u, err
+}
+
+func LinkIdentity(ctx context.Context, db *sqldb.DB, uid int64, provider, subject, email string) error {
+	return linkIdentity(ctx, db, uid, provider, subject, email)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func linkIdentity(ctx context.Context, db execer, uid int64, provider, subject, email string) error {
+	sqlStatement := `
+	INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+	VALUES (?, ?, ?, ?, ?)`
+
+	var e sql.NullString
+	if email != "" {
+		e = sql.NullString{String: email, Valid: true}
+	}
+	_, err := db.ExecContext(ctx, sqlStatement, uid, provider, subject, e, time.Now().UTC())
+	if isDuplicateKey(err) {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+// CreateUserWithIdentity creates a passwordless user for a first sign-in
+// through a provider. It returns ErrEmailExists if the address is taken.
+func CreateUserWithIdentity(ctx context.Context, db *sqldb.DB, email string, emailVerified bool, displayName, provider, subject string) (User, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	var name sql.NullString
+	if displayName != "" {
+		name = sql.NullString{String: displayName, Valid: true}
+	}
+	var verifiedAt *time.Time
+	if emailVerified {
+		now := time.Now().UTC()
+		verifiedAt = &now
+	}
+	output, returning := tx.Dialect.Returning("INSERTED", "id", "email", "created_at", "role")
+	var u User
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO users (email, hashed_password, email_verified, email_verified_at, display_name)
+	`+output+`
+	VALUES (?, ?, ?, ?, ?)`+returning,
+		email, NoPasswordHash, emailVerified, verifiedAt, name).Scan(&u.ID, &u.Email, &u.CreatedAt, &u.Role)
+	if isDuplicateKey(err) {
+		return User{}, ErrEmailExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if err := linkIdentity(ctx, tx, u.ID, provider, subject, email); err != nil {
+		return User{}, err
+	}
+	if err := createPersonalWorkspace(ctx, tx, u.ID); err != nil {
+		return User{}, err
+	}
+	return u, tx.Commit()
+}
+
+func ListIdentities(ctx context.Context, db *sqldb.DB, uid int64) ([]Identity, error) {
+	sqlStatement := `
+	SELECT id, provider, email, created_at, last_login_at
+	FROM user_identities
+	WHERE user_id = ?
+	ORDER BY created_at`
+
+	rows, err := db.QueryContext(ctx, sqlStatement, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Identity, 0, 2)
+	for rows.Next() {
+		var i Identity
+		var email sql.NullString
+		var last sql.NullTime
+		if err := rows.Scan(&i.ID, &i.Provider, &email, &i.CreatedAt, &last); err != nil {
+			return nil, err
+		}
+		if email.Valid {
+			i.Email = &email.String
+		}
+		if last.Valid {
+			t := last.Time.UTC()
+			i.LastLoginAt = &t
+		}
+		out = append(out, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UnlinkIdentity removes one of the user's identities, returning
+// sql.ErrNoRows if there is no such identity for this user and
+// ErrLastLoginMethod if the user has no password, passkey or other
+// identity to sign in with afterwards.
+func UnlinkIdentity(ctx context.Context, db *sqldb.DB, uid, id int64) error {
+	res, err := db.ExecContext(ctx, `
+	DELETE FROM user_identities
+	WHERE id = ? AND user_id = ?
+	  AND (EXISTS (SELECT 1 FROM users WHERE id = ? AND hashed_password <> ?)
+	       OR EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = ?)
+	       OR EXISTS (SELECT 1 FROM user_identities WHERE user_id = ? AND id <> ?))`,
+		id, uid, uid, NoPasswordHash, uid, uid, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var exists int
+	if err := db.QueryRowContext(ctx, `
+	SELECT COUNT(1) FROM user_identities WHERE id = ? AND user_id = ?`, id, uid).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
+	return ErrLastLoginMethod
+}
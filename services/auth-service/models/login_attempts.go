@@ -0,0 +1,97 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Reasons recorded for failed login attempts. A successful one records
+// the login method instead.
+const (
+	LoginReasonUnknownEmail    = "unknown_email"
+	LoginReasonBadPassword     = "bad_password"
+	LoginReasonBadSecondFactor = "bad_second_factor"
+	LoginReasonLocked          = "locked"
+	LoginReasonThrottled       = "throttled"
+)
+
+type LoginAttempt struct {
+	Email     string
+	UserID    int64 // zero when the email matched no user
+	IP        string
+	UserAgent string
+	Success   bool
+	Reason    string
+}
+
+func RecordLoginAttempt(ctx context.Context, db *sqldb.DB, a LoginAttempt) error {
+	sqlStatement := `
+	INSERT INTO login_attempts (email, user_id, ip, user_agent, success, reason)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	var uid sql.NullInt64
+	if a.UserID != 0 {
+		uid = sql.NullInt64{Int64: a.UserID, Valid: true}
+	}
+	ua := a.UserAgent
+	if len(ua) > 400 {
+		ua = ua[:400]
+	}
+	_, err := db.ExecContext(ctx, sqlStatement, a.Email, uid, a.IP, ua, a.Success, a.Reason)
+	return err
+}
+
+// GetUserForLogin is GetUserByEmail plus the end of any lockout, so the
+// login path needs a single query; the zero time means not locked.
+func GetUserForLogin(ctx context.Context, db *sqldb.DB, email string) (User, string, time.Time, error) {
+	sqlStatement := `
+	SELECT id, email, hashed_password, created_at, role, disabled_at, locked_until FROM users WHERE email = ?`
+
+	var u User
+	var hash string
+	var locked sql.NullTime
+	row := db.QueryRowContext(ctx, sqlStatement, email)
+	if err := row.Scan(&u.ID, &u.Email, &hash, &u.CreatedAt, &u.Role, &u.DisabledAt, &locked); err != nil {
+		return User{}, "", time.Time{}, err
+	}
+	if !locked.Valid {
+		return u, hash, time.Time{}, nil
+	}
+	return u, hash, locked.Time.UTC(), nil
+}
+
+// RegisterLoginFailure counts a failed password for the user. On the
+// maxFailures-th consecutive failure the account is locked until
+// now+lockFor and the count starts over. It returns the lockout end, or
+// the zero time if this failure did not lock the account.
+func RegisterLoginFailure(ctx context.Context, db *sqldb.DB, uid int64, maxFailures int, lockFor time.Duration) (time.Time, error) {
+	output, returning := db.Dialect.Returning("INSERTED", "locked_until")
+	sqlStatement := `
+	UPDATE users SET
+	  locked_until = CASE WHEN failed_login_count + 1 >= ? THEN ? ELSE locked_until END,
+	  failed_login_count = CASE WHEN failed_login_count + 1 >= ? THEN 0 ELSE failed_login_count + 1 END
+	` + output + `
+	WHERE id = ?` + returning
+
+	until := time.Now().UTC().Add(lockFor).Truncate(time.Second)
+	var locked sql.NullTime
+	err := db.QueryRowContext(ctx, sqlStatement, maxFailures, until, maxFailures, uid).Scan(&locked)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if locked.Valid && locked.Time.Equal(until) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+func ResetLoginFailures(ctx context.Context, db *sqldb.DB, uid int64) error {
+	sqlStatement := `
+	UPDATE users SET failed_login_count = 0, locked_until = NULL
+	WHERE id = ? AND (failed_login_count <> 0 OR locked_until IS NOT NULL)`
+
+	_, err := db.ExecContext(ctx, sqlStatement, uid)
+	return err
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// GenerateResetToken returns a new reset token for the email and the hash
+// to store. Only the hash is kept, so a database leak yields no usable links.
+func GenerateResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashResetToken(token), nil
+}
+
+func HashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// InsertPasswordResetToken stores a reset token unless the user was issued
+// one less than minInterval ago. It reports whether the token was stored.
+func InsertPasswordResetToken(ctx context.Context, db *sqldb.DB, uid int64, tokenHash string, expiresAt time.Time, minInterval time.Duration) (bool, error) {
+	sqlStatement := `
+	INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+	SELECT ?, ?, ?
+	WHERE NOT EXISTS (
+	  SELECT 1 FROM password_reset_tokens
+	  WHERE user_id = ? AND created_at > ?)`
+
+	since := time.Now().UTC().Add(-minInterval)
+	res, err := db.ExecContext(ctx, sqlStatement, uid, tokenHash, expiresAt.UTC(), uid, since)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetPasswordResetUser returns the user a reset token belongs to, or
+// ErrInvalidResetToken if the token is unknown, used or expired. It does
+// not consume the token.
+func GetPasswordResetUser(ctx context.Context, db *sqldb.DB, tokenHash string) (User, error) {
+	sqlStatement := `
+	SELECT u.id, u.email, u.created_at
+	FROM password_reset_tokens t
+	JOIN users u ON u.id = t.user_id
+	WHERE t.token_hash = ? AND t.used_at IS NULL AND t.expires_at > ?`
+
+	var u User
+	err := db.QueryRowContext(ctx, sqlStatement, tokenHash, time.Now().UTC()).Scan(&u.ID, &u.Email, &u.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidResetToken
+	}
+	return u, err
+}
+
+// ResetPassword consumes the reset token and sets the new password hash in
+// one transaction. Every other outstanding token for the user is spent too,
+// any login lockout is lifted and the user's sessions are cut off, so no
+// session outlives the old password even if the request fails half way.
+// It returns the user's id and the new cutoff, or ErrInvalidResetToken if
+// the token is unknown, used or expired.
+func ResetPassword(ctx context.Context, db *sqldb.DB, tokenHash, hashedPassword string) (int64, time.Time, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	output, returning := tx.Dialect.Returning("INSERTED", "user_id")
+	var uid int64
+	err = tx.QueryRowContext(ctx, `
+	UPDATE password_reset_tokens SET used_at = ?
+	`+output+`
+	WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`+returning, now, tokenHash, now).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE password_reset_tokens SET used_at = ?
+	WHERE user_id = ? AND used_at IS NULL`, now, uid); err != nil {
+		return 0, time.Time{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE users SET hashed_password = ?, failed_login_count = 0, locked_until = NULL
+	WHERE id = ?`, hashedPassword, uid); err != nil {
+		return 0, time.Time{}, err
+	}
+	cutoff, err := invalidateUserTokens(ctx, tx, uid)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return uid, cutoff, tx.Commit()
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Profile is the user record as the user sees it.
+type Profile struct {
+	ID            int64        `json:"id"`
+	Email         string       `json:"email"`
+	EmailVerified bool         `json:"email_verified"`
+	PendingEmail  *string      `json:"pending_email"`
+	DisplayName   *string      `json:"display_name"`
+	BaseCurrency  string       `json:"base_currency"`
+	Timezone      string       `json:"timezone"`
+	WeekStart     time.Weekday `json:"-"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// ProfileUpdate holds the fields to change; nil fields are left alone. An
+// empty DisplayName clears it.
+type ProfileUpdate struct {
+	DisplayName  *string
+	BaseCurrency *string
+	Timezone     *string
+	WeekStart    *time.Weekday
+}
+
+func GetProfile(ctx context.Context, db *sqldb.DB, uid int64) (Profile, error) {
+	sqlStatement := `
+	SELECT id, email, email_verified, pending_email, display_name, base_currency,
+	       timezone, week_start, created_at
+	FROM users WHERE id = ?`
+
+	var p Profile
+	var pending, name sql.NullString
+	var weekStart int
+	err := db.QueryRowContext(ctx, sqlStatement, uid).Scan(&p.ID, &p.Email, &p.EmailVerified,
+		&pending, &name, &p.BaseCurrency, &p.Timezone, &weekStart, &p.CreatedAt)
+	if err != nil {
+		return Profile{}, err
+	}
+	if pending.Valid {
+		p.PendingEmail = &pending.String
+	}
+	if name.Valid {
+		p.DisplayName = &name.String
+	}
+	p.WeekStart = time.Weekday(weekStart)
+	return p, nil
+}
+
+// UpdateProfile applies u and returns the updated profile.
+func UpdateProfile(ctx context.Context, db *sqldb.DB, uid int64, u ProfileUpdate) (Profile, error) {
+	var sets []string
+	var args []interface{}
+	if u.DisplayName != nil {
+		sets = append(sets, "display_name = ?")
+		if *u.DisplayName == "" {
+			args = append(args, sql.NullString{})
+		} else {
+			args = append(args, *u.DisplayName)
+		}
+	}
+	if u.BaseCurrency != nil {
+		sets = append(sets, "base_currency = ?")
+		args = append(args, *u.BaseCurrency)
+	}
+	if u.Timezone != nil {
+		sets = append(sets, "timezone = ?")
+		args = append(args, *u.Timezone)
+	}
+	if u.WeekStart != nil {
+		sets = append(sets, "week_start = ?")
+		args = append(args, int(*u.WeekStart))
+	}
+
+	if len(sets) > 0 {
+		sqlStatement := `UPDATE users SET ` + strings.Join(sets, ", ") + ` WHERE id = ?`
+		res, err := db.ExecContext(ctx, sqlStatement, append(args, uid)...)
+		if err != nil {
+			return Profile{}, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return Profile{}, sql.ErrNoRows
+		}
+	}
+	return GetProfile(ctx, db, uid)
+}
+
+func UpdatePasswordHash(ctx context.Context, db *sqldb.DB, uid int64, hashedPassword string) error {
+	sqlStatement := `
+	UPDATE users SET hashed_password = ? WHERE id = ?`
+
+	_, err := db.ExecContext(ctx, sqlStatement, hashedPassword, uid)
+	return err
+}
+
+// ChangePassword sets a new password hash and cuts off the user's sessions
+// in one transaction, so that the old sessions cannot outlive the old
+// password. It returns the new cutoff.
+func ChangePassword(ctx context.Context, db *sqldb.DB, uid int64, hashedPassword string) (time.Time, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	UPDATE users SET hashed_password = ? WHERE id = ?`, hashedPassword, uid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return time.Time{}, sql.ErrNoRows
+	}
+	cutoff, err := invalidateUserTokens(ctx, tx, uid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cutoff, tx.Commit()
+}
+
+// SetPendingEmail records the address the user wants to change to, unless
+// a verification email was sent less than minInterval ago. It reports
+// whether the caller may send the verification email, and returns
+// ErrEmailExists if another account already uses the address.
+func SetPendingEmail(ctx context.Context, db *sqldb.DB, uid int64, email string, minInterval time.Duration) (bool, error) {
+	var taken int
+	if err := db.QueryRowContext(ctx, `
+	SELECT COUNT(1) FROM users WHERE email = ? AND id <> ?`, email, uid).Scan(&taken); err != nil {
+		return false, err
+	}
+	if taken > 0 {
+		return false, ErrEmailExists
+	}
+
+	sqlStatement := `
+	UPDATE users SET pending_email = ?, verification_sent_at = ?
+	WHERE id = ?
+	  AND (verification_sent_at IS NULL OR verification_sent_at <= ?)`
+
+	now := time.Now().UTC()
+	res, err := db.ExecContext(ctx, sqlStatement, email, now, uid, now.Add(-minInterval))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// RevokeToken records a token ID as revoked until its original expiry.
+// Revoking the same token twice is not an error.
+func RevokeToken(ctx context.Context, db *sqldb.DB, jti string, uid int64, expiresAt time.Time) error {
+	sqlStatement := `
+	INSERT INTO revoked_tokens (jti, user_id, expires_at)
+	VALUES (?, ?, ?)`
+
+	_, err := db.ExecContext(ctx, sqlStatement, jti, uid, expiresAt.UTC())
+	if isDuplicateKey(err) {
+		return nil
+	}
+	return err
+}
+
+func IsTokenRevoked(ctx context.Context, db *sqldb.DB, jti string) (bool, error) {
+	sqlStatement := `
+	SELECT COUNT(1) FROM revoked_tokens WHERE jti = ?`
+
+	var n int
+	if err := db.QueryRowContext(ctx, sqlStatement, jti).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetTokensValidAfter returns the cutoff before which every token issued to
+// the user is rejected. The zero time means no cutoff has been set. A
+// disabled user has no valid tokens at all and gets sql.ErrNoRows, as for
+// a deleted one.
+func GetTokensValidAfter(ctx context.Context, db *sqldb.DB, uid int64) (time.Time, error) {
+	sqlStatement := `
+	SELECT tokens_valid_after FROM users WHERE id = ? AND disabled_at IS NULL`
+
+	var t sql.NullTime
+	if err := db.QueryRowContext(ctx, sqlStatement, uid).Scan(&t); err != nil {
+		return time.Time{}, err
+	}
+	if !t.Valid {
+		return time.Time{}, nil
+	}
+	return t.Time.UTC(), nil
+}
+
+// InvalidateUserTokens moves the user's cutoff to now, so every token issued
+// up to this call stops being accepted, and returns the new cutoff.
+func InvalidateUserTokens(ctx context.Context, db *sqldb.DB, uid int64) (time.Time, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	at, err := invalidateUserTokens(ctx, tx, uid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return at, tx.Commit()
+}
+
+// invalidateUserTokens moves the cutoff within tx. The cutoff has the
+// one-second resolution of iat claims, and tokens issued in its second are
+// refused too. A session that replaces cut-off ones is issued in the next
+// second, so a second cutoff within the same second moves past that one.
+func invalidateUserTokens(ctx context.Context, tx *sqldb.Tx, uid int64) (time.Time, error) {
+	hint, suffix := tx.Dialect.LockRows()
+	var prev sql.NullTime
+	if err := tx.QueryRowContext(ctx, `
+	SELECT tokens_valid_after FROM users `+hint+`
+	WHERE id = ?`+suffix, uid).Scan(&prev); err != nil {
+		return time.Time{}, err
+	}
+
+	at := time.Now().UTC().Truncate(time.Second)
+	if prev.Valid && !prev.Time.Before(at) {
+		at = prev.Time.UTC().Add(time.Second)
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE users SET tokens_valid_after = ?
+	WHERE id = ?`, at, uid); err != nil {
+		return time.Time{}, err
+	}
+	return at, nil
+}
+
+func DeleteExpiredRevocations(ctx context.Context, db *sqldb.DB, now time.Time) (int64, error) {
+	sqlStatement := `
+	DELETE FROM revoked_tokens WHERE expires_at < ?`
+
+	res, err := db.ExecContext(ctx, sqlStatement, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevocationStore answers "is this token still allowed?" for the auth
+// middleware. Answers are cached in memory so that most requests do not hit
+// the database; the DB remains the source of truth across instances.
+type RevocationStore struct {
+	db *sqldb.DB
+
+	// CheckTTL bounds how long a "not revoked" answer or a per-user cutoff is
+	// trusted before the database is asked again. Revocations made through
+	// this store are visible immediately; ones made by other instances are
+	// visible after at most CheckTTL.
+	CheckTTL time.Duration
+
+	mu         sync.Mutex
+	revoked    map[string]time.Time
+	notRevoked map[string]time.Time
+	validAfter map[int64]cachedCutoff
+}
+
+type cachedCutoff struct {
+	at        time.Time
+	fetchedAt time.Time
+}
+
+func NewRevocationStore(db *sqldb.DB) *RevocationStore {
+	return &RevocationStore{
+		db:         db,
+		CheckTTL:   30 * time.Second,
+		revoked:    map[string]time.Time{},
+		notRevoked: map[string]time.Time{},
+		validAfter: map[int64]cachedCutoff{},
+	}
+}
+
+func (s *RevocationStore) Revoke(ctx context.Context, jti string, uid int64, expiresAt time.Time) error {
+	if err := RevokeToken(ctx, s.db, jti, uid, expiresAt); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.revoked[jti] = expiresAt
+	delete(s.notRevoked, jti)
+	s.mu.Unlock()
+	return nil
+}
+
+// IsRevoked reports whether jti has been revoked. expiresAt is the token's own
+// expiry and bounds how long a positive answer is cached.
+func (s *RevocationStore) IsRevoked(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if _, ok := s.revoked[jti]; ok {
+		s.mu.Unlock()
+		return true, nil
+	}
+	if checked, ok := s.notRevoked[jti]; ok && now.Sub(checked) < s.CheckTTL {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.mu.Unlock()
+
+	revoked, err := IsTokenRevoked(ctx, s.db, jti)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	if revoked {
+		s.revoked[jti] = expiresAt
+	} else {
+		s.notRevoked[jti] = now
+	}
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+// ValidAfter returns the user's "tokens issued before" cutoff.
+func (s *RevocationStore) ValidAfter(ctx context.Context, uid int64) (time.Time, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if c, ok := s.validAfter[uid]; ok && now.Sub(c.fetchedAt) < s.CheckTTL {
+		s.mu.Unlock()
+		return c.at, nil
+	}
+	s.mu.Unlock()
+
+	at, err := GetTokensValidAfter(ctx, s.db, uid)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.mu.Lock()
+	s.validAfter[uid] = cachedCutoff{at: at, fetchedAt: now}
+	s.mu.Unlock()
+	return at, nil
+}
+
+// InvalidateUser rejects every token issued to uid up to now, e.g. after a
+// password change or a "log out everywhere", and returns the new cutoff.
+func (s *RevocationStore) InvalidateUser(ctx context.Context, uid int64) (time.Time, error) {
+	at, err := InvalidateUserTokens(ctx, s.db, uid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	s.Invalidated(uid, at)
+	return at, nil
+}
+
+// Invalidated caches a cutoff that the caller moved to at within its own
+// transaction, so that this instance refuses the cut-off tokens at once.
+func (s *RevocationStore) Invalidated(uid int64, at time.Time) {
+	s.mu.Lock()
+	s.validAfter[uid] = cachedCutoff{at: at, fetchedAt: time.Now()}
+	s.mu.Unlock()
+}
+
+// PurgeExpired removes revocations for tokens that have expired anyway and
+// drops stale cache entries.
+func (s *RevocationStore) PurgeExpired(ctx context.Context) error {
+	now := time.Now()
+	if _, err := DeleteExpiredRevocations(ctx, s.db, now); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, exp := range s.revoked {
+		if exp.Before(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	for jti, checked := range s.notRevoked {
+		if now.Sub(checked) >= s.CheckTTL {
+			delete(s.notRevoked, jti)
+		}
+	}
+	for uid, c := range s.validAfter {
+		if now.Sub(c.fetchedAt) >= s.CheckTTL {
+			delete(s.validAfter, uid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is how many single-use recovery codes a user holds.
+const RecoveryCodeCount = 10
+
+func IsTOTPEnabled(ctx context.Context, db *sqldb.DB, uid int64) (bool, error) {
+	_, enabled, _, err := GetTOTP(ctx, db, uid)
+	return enabled, err
+}
+
+// GetTOTP returns the user's TOTP secret (empty if never enrolled), whether
+// it has been confirmed, and the last time step accepted from it.
+func GetTOTP(ctx context.Context, db *sqldb.DB, uid int64) (string, bool, int64, error) {
+	sqlStatement := `
+	SELECT totp_secret, totp_enabled, totp_last_counter FROM users WHERE id = ?`
+
+	var secret sql.NullString
+	var enabled bool
+	var last sql.NullInt64
+	if err := db.QueryRowContext(ctx, sqlStatement, uid).Scan(&secret, &enabled, &last); err != nil {
+		return "", false, 0, err
+	}
+	return secret.String, enabled, last.Int64, nil
+}
+
+// SetPendingTOTPSecret stores a secret that is not enforced until the user
+// proves possession with EnableTOTP. It never overwrites an enabled secret.
+func SetPendingTOTPSecret(ctx context.Context, db *sqldb.DB, uid int64, secret string) (bool, error) {
+	sqlStatement := `
+	UPDATE users SET totp_secret = ?, totp_last_counter = NULL
+	WHERE id = ? AND totp_enabled = ?`
+
+	res, err := db.ExecContext(ctx, sqlStatement, secret, uid, false)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// EnableTOTP turns on the second factor and replaces the recovery codes in
+// one transaction.
+func EnableTOTP(ctx context.Context, db *sqldb.DB, uid int64, counter int64, codeHashes []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE users SET totp_enabled = ?, totp_last_counter = ?
+	WHERE id = ?`, true, counter, uid); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, uid, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func DisableTOTP(ctx context.Context, db *sqldb.DB, uid int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE users SET totp_secret = NULL, totp_enabled = ?, totp_last_counter = NULL
+	WHERE id = ?`, false, uid); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdvanceTOTPCounter records counter as the last accepted time step. It
+// returns false if that step (or a later one) was already used, which is
+// how replayed codes are rejected even across concurrent requests.
+func AdvanceTOTPCounter(ctx context.Context, db *sqldb.DB, uid int64, counter int64) (bool, error) {
+	sqlStatement := `
+	UPDATE users SET totp_last_counter = ?
+	WHERE id = ? AND totp_enabled = ?
+	  AND (totp_last_counter IS NULL OR totp_last_counter < ?)`
+
+	res, err := db.ExecContext(ctx, sqlStatement, counter, uid, true, counter)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func ReplaceRecoveryCodes(ctx context.Context, db *sqldb.DB, uid int64, codeHashes []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, uid, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sqldb.Tx, uid int64, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, uid); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, uid, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes a recovery code. It returns false if the code is
+// unknown or already used.
+func UseRecoveryCode(ctx context.Context, db *sqldb.DB, uid int64, code string) (bool, error) {
+	sqlStatement := `
+	UPDATE recovery_codes SET used_at = ?
+	WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+
+	res, err := db.ExecContext(ctx, sqlStatement, time.Now().UTC(), uid, HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GenerateRecoveryCodes returns fresh codes formatted xxxx-xxxx-xxxx (60
+// bits each) and their hashes for storage.
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))[:12]
+		codes[i] = s[0:4] + "-" + s[4:8] + "-" + s[8:12]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode normalises case and separators before hashing, so codes
+// can be typed however the user likes.
+func HashRecoveryCode(code string) string {
+	norm := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(norm))
+	return hex.EncodeToString(sum[:])
+}
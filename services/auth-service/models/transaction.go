@@ -0,0 +1,125 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"strconv"
+	"time"
+)
+
+// Transaction is one row of a workspace's ledger. UserID is the member
+// who imported it.
+type Transaction struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	WorkspaceID int64     `json:"workspace_id"`
+	Date        time.Time `json:"date"`
+	Amount      float64   `json:"amount"`
+	Merchant    string    `json:"merchant"`
+	Category    string    `json:"category"`
+	Description *string   `json:"description"`
+}
+
+// TransactionChange is an edit to a transaction. Nil fields are left as
+// they are.
+type TransactionChange struct {
+	Date        *time.Time
+	Amount      *float64
+	Merchant    *string
+	Category    *string
+	Description *string
+}
+
+func (ch TransactionChange) apply(t Transaction) Transaction {
+	if ch.Date != nil {
+		t.Date = *ch.Date
+	}
+	if ch.Amount != nil {
+		t.Amount = *ch.Amount
+	}
+	if ch.Merchant != nil {
+		t.Merchant = *ch.Merchant
+	}
+	if ch.Category != nil {
+		t.Category = *ch.Category
+	}
+	if ch.Description != nil {
+		t.Description = ch.Description
+	}
+	return t
+}
+
+// lockTransaction reads a transaction of the workspace and holds it until
+// the transaction ends. It returns sql.ErrNoRows for a transaction of
+// another workspace.
+func lockTransaction(ctx context.Context, tx *sqldb.Tx, wid, id int64) (Transaction, error) {
+	hint, suffix := tx.Dialect.LockRows()
+	var t Transaction
+	err := tx.QueryRowContext(ctx, `
+	SELECT id, user_id, workspace_id, date, amount, merchant, category, description
+	FROM transactions `+hint+`
+	WHERE id = ? AND workspace_id = ?`+suffix, id, wid).
+		Scan(&t.ID, &t.UserID, &t.WorkspaceID, &t.Date, &t.Amount, &t.Merchant, &t.Category, &t.Description)
+	return t, err
+}
+
+func transactionAudit(by AuditActor, action string, t Transaction, before, after any) AuditEntry {
+	return by.entry(AuditEntry{
+		UserID:      &t.UserID,
+		WorkspaceID: &t.WorkspaceID,
+		Action:      action,
+		EntityType:  AuditEntityTransaction,
+		EntityID:    strconv.FormatInt(t.ID, 10),
+		Before:      before,
+		After:       after,
+	})
+}
+
+// UpdateTransaction edits a transaction of the workspace and records the
+// edit, with the transaction before and after it. The import ID is kept,
+// so importing the same statement again does not bring the original back.
+func UpdateTransaction(ctx context.Context, db *sqldb.DB, wid, id int64, ch TransactionChange, by AuditActor) (Transaction, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transaction{}, err
+	}
+	defer tx.Rollback()
+
+	before, err := lockTransaction(ctx, tx, wid, id)
+	if err != nil {
+		return Transaction{}, err
+	}
+	after := ch.apply(before)
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE transactions SET date = ?, amount = ?, merchant = ?, category = ?, description = ?
+	WHERE id = ?`, after.Date, after.Amount, after.Merchant, after.Category, after.Description, id); err != nil {
+		return Transaction{}, err
+	}
+	if err := recordAudit(ctx, tx, transactionAudit(by, AuditTransactionUpdate, before, before, after)); err != nil {
+		return Transaction{}, err
+	}
+	return after, tx.Commit()
+}
+
+// DeleteTransaction deletes a transaction of the workspace and records
+// what it was.
+func DeleteTransaction(ctx context.Context, db *sqldb.DB, wid, id int64, by AuditActor) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := lockTransaction(ctx, tx, wid, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	DELETE FROM transactions WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, transactionAudit(by, AuditTransactionDelete, before, before, nil)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,55 +1,75 @@
 package models
 
 import (
+	"auth-service/sqldb"
 	"context"
 	"database/sql"
 	"errors"
 	"time"
-	"github.com/denisenkom/go-mssqldb"
 )
 
 type User struct {
 	ID             int64     `json:"id"`
 	Email          string    `json:"email"` 
 	CreatedAt      time.Time `json:"created_at"`
+	Role           string    `json:"role"`
+	// DisabledAt is set while an administrator has disabled the account.
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
 }
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var ValidRoles = []string{RoleUser, RoleAdmin}
+
 
 var ErrEmailExists = errors.New("email already exists")
-func InsertUser (ctx context.Context, db *sql.DB, email string, hashedPassword string) (User, error) {
+func InsertUser (ctx context.Context, db *sqldb.DB, email string, hashedPassword string) (User, error) {
+	output, returning := db.Dialect.Returning("INSERTED", "id", "email", "created_at", "role")
 	sqlStatement := `
 	INSERT INTO users (email, hashed_password)
-	OUTPUT INSERTED.id, INSERTED.email, INSERTED.created_at
-	VALUES (?, ?)`
+	` + output + `
+	VALUES (?, ?)` + returning
 
 
-	var u User
-	row :=db.QueryRowContext(ctx, sqlStatement, email, hashedPassword)
-	err := row.Scan(&u.ID, &u.Email, &u.CreatedAt)
-	if err == nil {
-		return u, nil
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
 	}
-	var mePtr *mssql.Error
-	if errors.As(err, &mePtr) && (mePtr.Number == 2627 || mePtr.Number == 2601) {
+	defer tx.Rollback()
+
+	var u User
+	row :=tx.QueryRowContext(ctx, sqlStatement, email, hashedPassword)
+	err = row.Scan(&u.ID, &u.Email, &u.CreatedAt, &u.Role)
+	if isDuplicateKey(err) {
 		return User{}, ErrEmailExists
 	}
-	var meVal mssql.Error
-	if errors.As(err, &meVal) && (meVal.Number == 2627 || meVal.Number == 2601) {
-		return User{}, ErrEmailExists
+	if err != nil {
+		return User{}, err
+	}
+	if err := createPersonalWorkspace(ctx, tx, u.ID); err != nil {
+		return User{}, err
 	}
+	return u, tx.Commit()
+}
 
-	return User{}, err
+// isDuplicateKey reports whether err is a unique constraint or unique index
+// violation.
+func isDuplicateKey(err error) bool {
+	return sqldb.IsDuplicateKey(err)
 }
 
 
-func GetUserByEmail(ctx context.Context, db *sql.DB, email string) (User, string, error){
+func GetUserByEmail(ctx context.Context, db *sqldb.DB, email string) (User, string, error){
 	sqlStatement := `
-	SELECT id, email, hashed_password, created_at FROM users WHERE email = ?`
+	SELECT id, email, hashed_password, created_at, role, disabled_at FROM users WHERE email = ?`
 
 	var u User
 	var hash string
 	row :=db.QueryRowContext(ctx, sqlStatement, email)
-	err := row.Scan(&u.ID, &u.Email, &hash, &u.CreatedAt)
+	err := row.Scan(&u.ID, &u.Email, &hash, &u.CreatedAt, &u.Role, &u.DisabledAt)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -65,4 +85,17 @@ func GetUserByEmail(ctx context.Context, db *sql.DB, email string) (User, string
 
 
 }
-	
\ No newline at end of file
+	
+// GetUserByID loads a user and their password hash.
+func GetUserByID(ctx context.Context, db *sqldb.DB, id int64) (User, string, error) {
+	sqlStatement := `
+	SELECT id, email, hashed_password, created_at, role, disabled_at FROM users WHERE id = ?`
+
+	var u User
+	var hash string
+	row := db.QueryRowContext(ctx, sqlStatement, id)
+	if err := row.Scan(&u.ID, &u.Email, &hash, &u.CreatedAt, &u.Role, &u.DisabledAt); err != nil {
+		return User{}, "", err
+	}
+	return u, hash, nil
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"time"
+)
+
+func IsEmailVerified(ctx context.Context, db *sqldb.DB, uid int64) (bool, error) {
+	sqlStatement := `
+	SELECT email_verified FROM users WHERE id = ?`
+
+	var verified bool
+	err := db.QueryRowContext(ctx, sqlStatement, uid).Scan(&verified)
+	return verified, err
+}
+
+// MarkEmailVerified marks email verified for the user. If email is the
+// user's pending new address it becomes their address. A link for an
+// address that is neither current nor pending verifies nothing. It reports
+// whether the user was updated, and returns ErrEmailExists if another
+// account took the pending address in the meantime.
+func MarkEmailVerified(ctx context.Context, db *sqldb.DB, uid int64, email string) (bool, error) {
+	sqlStatement := `
+	UPDATE users SET
+	  email_verified_at = CASE WHEN email = ? AND email_verified = ?
+	                           THEN email_verified_at ELSE ? END,
+	  email_verified = ?,
+	  email = ?,
+	  pending_email = CASE WHEN pending_email = ? THEN NULL ELSE pending_email END
+	WHERE id = ? AND (email = ? OR pending_email = ?)`
+
+	res, err := db.ExecContext(ctx, sqlStatement, email, true, time.Now().UTC(), true, email, email, uid, email, email)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return false, ErrEmailExists
+		}
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClaimVerificationSend records that a verification email is about to be
+// sent, unless the address is already verified or one was sent less than
+// minInterval ago. It reports whether the caller may send.
+func ClaimVerificationSend(ctx context.Context, db *sqldb.DB, uid int64, minInterval time.Duration) (bool, error) {
+	sqlStatement := `
+	UPDATE users SET verification_sent_at = ?
+	WHERE id = ? AND email_verified = ?
+	  AND (verification_sent_at IS NULL OR verification_sent_at <= ?)`
+
+	now := time.Now().UTC()
+	res, err := db.ExecContext(ctx, sqlStatement, now, uid, false, now.Add(-minInterval))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var ErrPasskeyExists = errors.New("passkey already registered")
+
+// Passkey is the user-facing view of a stored WebAuthn credential.
+type Passkey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// PasskeyUser adapts a user and their credentials to webauthn.User.
+type PasskeyUser struct {
+	User
+	Handle      []byte
+	Credentials []webauthn.Credential
+}
+
+func (u *PasskeyUser) WebAuthnID() []byte                         { return u.Handle }
+func (u *PasskeyUser) WebAuthnName() string                       { return u.Email }
+func (u *PasskeyUser) WebAuthnDisplayName() string                { return u.Email }
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential { return u.Credentials }
+
+// EnsureWebAuthnHandle returns the user's WebAuthn user handle, creating it
+// on first use. The handle is random rather than the row id so that
+// authenticators never learn anything about the account.
+func EnsureWebAuthnHandle(ctx context.Context, db *sqldb.DB, uid int64) ([]byte, error) {
+	handle := make([]byte, 32)
+	if _, err := rand.Read(handle); err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, `
+	UPDATE users SET webauthn_user_handle = ?
+	WHERE id = ? AND webauthn_user_handle IS NULL`, handle, uid); err != nil {
+		return nil, err
+	}
+
+	var stored []byte
+	err := db.QueryRowContext(ctx, `
+	SELECT webauthn_user_handle FROM users WHERE id = ?`, uid).Scan(&stored)
+	return stored, err
+}
+
+// GetPasskeyUser loads a user with their credentials by row id.
+func GetPasskeyUser(ctx context.Context, db *sqldb.DB, uid int64) (*PasskeyUser, error) {
+	handle, err := EnsureWebAuthnHandle(ctx, db, uid)
+	if err != nil {
+		return nil, err
+	}
+	u, _, err := GetUserByID(ctx, db, uid)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := getWebAuthnCredentials(ctx, db, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyUser{User: u, Handle: handle, Credentials: creds}, nil
+}
+
+// GetPasskeyUserByHandle loads a user with their credentials by the user
+// handle an authenticator returned during a discoverable login.
+func GetPasskeyUserByHandle(ctx context.Context, db *sqldb.DB, handle []byte) (*PasskeyUser, error) {
+	var u User
+	err := db.QueryRowContext(ctx, `
+	SELECT id, email, created_at, role, disabled_at FROM users WHERE webauthn_user_handle = ?`, handle).
+		Scan(&u.ID, &u.Email, &u.CreatedAt, &u.Role, &u.DisabledAt)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := getWebAuthnCredentials(ctx, db, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyUser{User: u, Handle: handle, Credentials: creds}, nil
+}
+
+func getWebAuthnCredentials(ctx context.Context, db *sqldb.DB, uid int64) ([]webauthn.Credential, error) {
+	sqlStatement := `
+	SELECT credential_id, public_key, attestation_type, aaguid, transports, sign_count,
+	       clone_warning, user_verified, backup_eligible, backup_state
+	FROM webauthn_credentials
+	WHERE user_id = ?`
+
+	rows, err := db.QueryContext(ctx, sqlStatement, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]webauthn.Credential, 0, 4)
+	for rows.Next() {
+		var cred webauthn.Credential
+		var transports string
+		var signCount int64
+		if err := rows.Scan(&cred.ID, &cred.PublicKey, &cred.AttestationType, &cred.Authenticator.AAGUID,
+			&transports, &signCount, &cred.Authenticator.CloneWarning,
+			&cred.Flags.UserVerified, &cred.Flags.BackupEligible, &cred.Flags.BackupState); err != nil {
+			return nil, err
+		}
+		cred.Authenticator.SignCount = uint32(signCount)
+		cred.Flags.UserPresent = true
+		for _, t := range strings.Fields(transports) {
+			cred.Transport = append(cred.Transport, protocol.AuthenticatorTransport(t))
+		}
+		out = append(out, cred)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func InsertWebAuthnCredential(ctx context.Context, db *sqldb.DB, uid int64, name string, cred *webauthn.Credential) (Passkey, error) {
+	output, returning := db.Dialect.Returning("INSERTED", "id", "created_at")
+	sqlStatement := `
+	INSERT INTO webauthn_credentials
+	  (user_id, name, credential_id, public_key, attestation_type, aaguid, transports,
+	   sign_count, clone_warning, user_verified, backup_eligible, backup_state)
+	` + output + `
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)` + returning
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+
+	p := Passkey{Name: name}
+	row := db.QueryRowContext(ctx, sqlStatement, uid, name, cred.ID, cred.PublicKey, cred.AttestationType,
+		cred.Authenticator.AAGUID, strings.Join(transports, " "), int64(cred.Authenticator.SignCount),
+		cred.Authenticator.CloneWarning, cred.Flags.UserVerified, cred.Flags.BackupEligible, cred.Flags.BackupState)
+	if err := row.Scan(&p.ID, &p.CreatedAt); err != nil {
+		if isDuplicateKey(err) {
+			return Passkey{}, ErrPasskeyExists
+		}
+		return Passkey{}, err
+	}
+	return p, nil
+}
+
+// UpdateWebAuthnCredentialUse stores the sign counter and flags reported by
+// a successful login.
+func UpdateWebAuthnCredentialUse(ctx context.Context, db *sqldb.DB, cred *webauthn.Credential) error {
+	sqlStatement := `
+	UPDATE webauthn_credentials
+	SET sign_count = ?, clone_warning = ?, backup_state = ?, last_used_at = ?
+	WHERE credential_id = ?`
+
+	_, err := db.ExecContext(ctx, sqlStatement, int64(cred.Authenticator.SignCount),
+		cred.Authenticator.CloneWarning, cred.Flags.BackupState, time.Now().UTC(), cred.ID)
+	return err
+}
+
+func ListPasskeys(ctx context.Context, db *sqldb.DB, uid int64) ([]Passkey, error) {
+	sqlStatement := `
+	SELECT id, name, created_at, last_used_at
+	FROM webauthn_credentials
+	WHERE user_id = ?
+	ORDER BY created_at DESC`
+
+	rows, err := db.QueryContext(ctx, sqlStatement, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Passkey, 0, 4)
+	for rows.Next() {
+		var p Passkey
+		var used sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &used); err != nil {
+			return nil, err
+		}
+		if used.Valid {
+			t := used.Time.UTC()
+			p.LastUsedAt = &t
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeletePasskey removes one of the user's passkeys, returning sql.ErrNoRows
+// if there is no such passkey for this user and ErrLastLoginMethod if the
+// user has no password, identity or other passkey to sign in with
+// afterwards.
+func DeletePasskey(ctx context.Context, db *sqldb.DB, uid, id int64) error {
+	res, err := db.ExecContext(ctx, `
+	DELETE FROM webauthn_credentials
+	WHERE id = ? AND user_id = ?
+	  AND (EXISTS (SELECT 1 FROM users WHERE id = ? AND hashed_password <> ?)
+	       OR EXISTS (SELECT 1 FROM user_identities WHERE user_id = ?)
+	       OR EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = ? AND id <> ?))`,
+		id, uid, uid, NoPasswordHash, uid, uid, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var exists int
+	if err := db.QueryRowContext(ctx, `
+	SELECT COUNT(1) FROM webauthn_credentials WHERE id = ? AND user_id = ?`, id, uid).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
+	return ErrLastLoginMethod
+}
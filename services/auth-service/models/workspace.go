@@ -0,0 +1,419 @@
+package models
+
+import (
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Workspace roles, from most to least privileged.
+const (
+	WorkspaceOwner  = "owner"
+	WorkspaceEditor = "editor"
+	WorkspaceViewer = "viewer"
+)
+
+var WorkspaceRoles = []string{WorkspaceOwner, WorkspaceEditor, WorkspaceViewer}
+
+// WorkspaceRoleAtLeast reports whether role grants everything want does.
+func WorkspaceRoleAtLeast(role, want string) bool {
+	have := slices.Index(WorkspaceRoles, role)
+	need := slices.Index(WorkspaceRoles, want)
+	return have >= 0 && need >= 0 && have <= need
+}
+
+var (
+	// ErrLastOwner is returned when a change would leave a workspace
+	// without an owner.
+	ErrLastOwner     = errors.New("a workspace must keep at least one owner")
+	ErrInvalidInvite = errors.New("invalid or expired invitation")
+	ErrInviteEmail   = errors.New("invitation is for a different email address")
+	ErrAlreadyMember = errors.New("already a member of this workspace")
+	ErrNoWorkspace   = errors.New("user has no default workspace")
+)
+
+// Workspace is a workspace as seen by one of its members.
+type Workspace struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Default   bool      `json:"default"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WorkspaceMember struct {
+	UserID      int64     `json:"user_id"`
+	Email       string    `json:"email"`
+	DisplayName *string   `json:"display_name"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+type WorkspaceInvite struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// insertWorkspace creates a workspace with uid as its owner.
+func insertWorkspace(ctx context.Context, tx *sqldb.Tx, uid int64, name string) (Workspace, error) {
+	w := Workspace{Name: name, Role: WorkspaceOwner}
+	output, returning := tx.Dialect.Returning("INSERTED", "id", "created_at")
+	err := tx.QueryRowContext(ctx, `
+	INSERT INTO workspaces (name)
+	`+output+`
+	VALUES (?)`+returning, name).Scan(&w.ID, &w.CreatedAt)
+	if err != nil {
+		return Workspace{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO workspace_members (workspace_id, user_id, role)
+	VALUES (?, ?, ?)`, w.ID, uid, WorkspaceOwner)
+	return w, err
+}
+
+// createPersonalWorkspace gives a new user the workspace their data lives
+// in until they join or create another. It runs in the transaction that
+// creates the user.
+func createPersonalWorkspace(ctx context.Context, tx *sqldb.Tx, uid int64) error {
+	w, err := insertWorkspace(ctx, tx, uid, "Personal")
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+	UPDATE users SET default_workspace_id = ? WHERE id = ?`, w.ID, uid)
+	return err
+}
+
+func CreateWorkspace(ctx context.Context, db *sqldb.DB, uid int64, name string) (Workspace, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	w, err := insertWorkspace(ctx, tx, uid, name)
+	if err != nil {
+		return Workspace{}, err
+	}
+	return w, tx.Commit()
+}
+
+// ListWorkspaces returns the workspaces uid belongs to, the default first.
+func ListWorkspaces(ctx context.Context, db *sqldb.DB, uid int64) ([]Workspace, error) {
+	sqlStatement := `
+	SELECT w.id, w.name, m.role,
+	       CASE WHEN u.default_workspace_id = w.id THEN 1 ELSE 0 END,
+	       w.created_at
+	FROM workspace_members m
+	JOIN workspaces w ON w.id = m.workspace_id
+	JOIN users u ON u.id = m.user_id
+	WHERE m.user_id = ?
+	ORDER BY 4 DESC, w.name`
+
+	rows, err := db.QueryContext(ctx, sqlStatement, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Workspace{}
+	for rows.Next() {
+		var w Workspace
+		if err := rows.Scan(&w.ID, &w.Name, &w.Role, &w.Default, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// GetDefaultWorkspace returns the workspace used when a request does not
+// name one, with uid's role in it. It returns ErrNoWorkspace if the user
+// has left every workspace they had.
+func GetDefaultWorkspace(ctx context.Context, db *sqldb.DB, uid int64) (int64, string, error) {
+	sqlStatement := `
+	SELECT m.workspace_id, m.role
+	FROM users u
+	JOIN workspace_members m ON m.workspace_id = u.default_workspace_id AND m.user_id = u.id
+	WHERE u.id = ?`
+
+	var wid int64
+	var role string
+	err := db.QueryRowContext(ctx, sqlStatement, uid).Scan(&wid, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", ErrNoWorkspace
+	}
+	return wid, role, err
+}
+
+// GetWorkspaceRole returns uid's role in the workspace, or sql.ErrNoRows if
+// they are not a member.
+func GetWorkspaceRole(ctx context.Context, db *sqldb.DB, wid, uid int64) (string, error) {
+	sqlStatement := `
+	SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?`
+
+	var role string
+	err := db.QueryRowContext(ctx, sqlStatement, wid, uid).Scan(&role)
+	return role, err
+}
+
+func RenameWorkspace(ctx context.Context, db *sqldb.DB, wid int64, name string) error {
+	res, err := db.ExecContext(ctx, `
+	UPDATE workspaces SET name = ? WHERE id = ?`, name, wid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func ListWorkspaceMembers(ctx context.Context, db *sqldb.DB, wid int64) ([]WorkspaceMember, error) {
+	sqlStatement := `
+	SELECT u.id, u.email, u.display_name, m.role, m.created_at
+	FROM workspace_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id = ?
+	ORDER BY m.created_at`
+
+	rows, err := db.QueryContext(ctx, sqlStatement, wid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []WorkspaceMember{}
+	for rows.Next() {
+		var m WorkspaceMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.DisplayName, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// lockOwners takes an update lock on the workspace's owner rows for the
+// rest of tx and returns how many there are, so that two owners cannot
+// demote each other at the same time.
+func lockOwners(ctx context.Context, tx *sqldb.Tx, wid int64) (int, error) {
+	// The rows are counted here rather than with COUNT(*), which PostgreSQL
+	// will not combine with FOR UPDATE.
+	hint, suffix := tx.Dialect.LockRows()
+	rows, err := tx.QueryContext(ctx, `
+	SELECT user_id FROM workspace_members `+hint+`
+	WHERE workspace_id = ? AND role = 'owner'`+suffix, wid)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
+
+// SetWorkspaceMemberRole changes a member's role. It returns sql.ErrNoRows
+// if uid is not a member and ErrLastOwner if it would demote the only
+// owner.
+func SetWorkspaceMemberRole(ctx context.Context, db *sqldb.DB, wid, uid int64, role string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	owners, err := lockOwners(ctx, tx, wid)
+	if err != nil {
+		return err
+	}
+	var old string
+	err = tx.QueryRowContext(ctx, `
+	SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?`, wid, uid).Scan(&old)
+	if err != nil {
+		return err
+	}
+	if old == WorkspaceOwner && role != WorkspaceOwner && owners <= 1 {
+		return ErrLastOwner
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE workspace_members SET role = ?
+	WHERE workspace_id = ? AND user_id = ?`, role, wid, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveWorkspaceMember takes uid out of the workspace. It returns
+// sql.ErrNoRows if uid is not a member and ErrLastOwner if they are its
+// only owner. If it was the user's default workspace, another of theirs
+// becomes the default.
+func RemoveWorkspaceMember(ctx context.Context, db *sqldb.DB, wid, uid int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	owners, err := lockOwners(ctx, tx, wid)
+	if err != nil {
+		return err
+	}
+	output, returning := tx.Dialect.Returning("DELETED", "role")
+	var old string
+	err = tx.QueryRowContext(ctx, `
+	DELETE FROM workspace_members
+	`+output+`
+	WHERE workspace_id = ? AND user_id = ?`+returning, wid, uid).Scan(&old)
+	if err != nil {
+		return err
+	}
+	if old == WorkspaceOwner && owners <= 1 {
+		return ErrLastOwner
+	}
+	_, err = tx.ExecContext(ctx, `
+	UPDATE users SET default_workspace_id = (
+	  SELECT workspace_id FROM workspace_members
+	  WHERE user_id = ? ORDER BY CASE WHEN role = 'owner' THEN 0 ELSE 1 END, created_at
+	  `+tx.Dialect.Limit(1, 0)+`)
+	WHERE id = ? AND default_workspace_id = ?`, uid, uid, wid)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GenerateInviteToken returns a new invitation token and the hash to store.
+func GenerateInviteToken() (string, string, error) {
+	return GenerateResetToken()
+}
+
+func HashInviteToken(token string) string {
+	return HashResetToken(token)
+}
+
+// InsertWorkspaceInvite stores an invitation, replacing any pending one for
+// the same address.
+func InsertWorkspaceInvite(ctx context.Context, db *sqldb.DB, wid int64, email, role, tokenHash string, invitedBy int64, expiresAt time.Time) (WorkspaceInvite, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+	DELETE FROM workspace_invites
+	WHERE workspace_id = ? AND email = ? AND accepted_at IS NULL`, wid, email); err != nil {
+		return WorkspaceInvite{}, err
+	}
+	inv := WorkspaceInvite{Email: email, Role: role}
+	output, returning := tx.Dialect.Returning("INSERTED", "id", "expires_at", "created_at")
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO workspace_invites (workspace_id, email, role, token_hash, invited_by, expires_at)
+	`+output+`
+	VALUES (?, ?, ?, ?, ?, ?)`+returning, wid, email, role, tokenHash, invitedBy, expiresAt.UTC()).
+		Scan(&inv.ID, &inv.ExpiresAt, &inv.CreatedAt)
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+	return inv, tx.Commit()
+}
+
+// ListWorkspaceInvites returns the invitations still waiting to be
+// accepted.
+func ListWorkspaceInvites(ctx context.Context, db *sqldb.DB, wid int64) ([]WorkspaceInvite, error) {
+	sqlStatement := `
+	SELECT id, email, role, expires_at, created_at
+	FROM workspace_invites
+	WHERE workspace_id = ? AND accepted_at IS NULL AND expires_at > ?
+	ORDER BY created_at DESC`
+
+	rows, err := db.QueryContext(ctx, sqlStatement, wid, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []WorkspaceInvite{}
+	for rows.Next() {
+		var inv WorkspaceInvite
+		if err := rows.Scan(&inv.ID, &inv.Email, &inv.Role, &inv.ExpiresAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, inv)
+	}
+	return out, rows.Err()
+}
+
+// DeleteWorkspaceInvite withdraws a pending invitation. It returns
+// sql.ErrNoRows if there is none with that id in the workspace.
+func DeleteWorkspaceInvite(ctx context.Context, db *sqldb.DB, wid, id int64) error {
+	res, err := db.ExecContext(ctx, `
+	DELETE FROM workspace_invites
+	WHERE id = ? AND workspace_id = ? AND accepted_at IS NULL`, id, wid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AcceptWorkspaceInvite makes uid a member with the invited role. The
+// invitation must be addressed to email, the user's own address, so a
+// forwarded link is no use to anyone else. It returns the workspace, or
+// ErrInvalidInvite, ErrInviteEmail or ErrAlreadyMember.
+func AcceptWorkspaceInvite(ctx context.Context, db *sqldb.DB, tokenHash string, uid int64, email string) (Workspace, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	hint, suffix := tx.Dialect.LockRows()
+	var id int64
+	var invited string
+	var w Workspace
+	err = tx.QueryRowContext(ctx, `
+	SELECT i.id, i.email, i.role, w.id, w.name, w.created_at
+	FROM workspace_invites i `+hint+`
+	JOIN workspaces w ON w.id = i.workspace_id
+	WHERE i.token_hash = ? AND i.accepted_at IS NULL AND i.expires_at > ?`+suffix, tokenHash, now).
+		Scan(&id, &invited, &w.Role, &w.ID, &w.Name, &w.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Workspace{}, ErrInvalidInvite
+	}
+	if err != nil {
+		return Workspace{}, err
+	}
+	if !strings.EqualFold(invited, email) {
+		return Workspace{}, ErrInviteEmail
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO workspace_members (workspace_id, user_id, role)
+	VALUES (?, ?, ?)`, w.ID, uid, w.Role)
+	if isDuplicateKey(err) {
+		return Workspace{}, ErrAlreadyMember
+	}
+	if err != nil {
+		return Workspace{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE workspace_invites SET accepted_at = ? WHERE id = ?`, now, id); err != nil {
+		return Workspace{}, err
+	}
+	return w, tx.Commit()
+}
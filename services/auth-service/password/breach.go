@@ -0,0 +1,52 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachList says whether a password is known from a breach.
+type BreachList interface {
+	Contains(pw string) (bool, error)
+}
+
+// RangeDir is a local copy of the Pwned Passwords range files, as written
+// by the haveibeenpwned-downloader with single-file output turned off: one
+// file per 5-character SHA-1 prefix, named PREFIX.txt, with lines of
+// "SUFFIX:COUNT". A lookup reads only the file for the password's prefix,
+// the same k-anonymity split the online API uses, so the full hash never
+// has to be compared against the whole corpus.
+type RangeDir struct {
+	Dir string
+}
+
+func (r RangeDir) Contains(pw string) (bool, error) {
+	sum := sha1.Sum([]byte(pw))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := h[:5], h[5:]
+
+	f, err := os.Open(filepath.Join(r.Dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		s, count, _ := strings.Cut(line, ":")
+		if strings.EqualFold(s, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
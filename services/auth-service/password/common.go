@@ -0,0 +1,55 @@
+package password
+
+import "sort"
+
+// commonPasswords are among the most used passwords in public breach
+// corpora. A password equal to one of them scores 0.
+var commonPasswords = set(
+	"123456", "123456789", "12345678", "password", "qwerty123", "qwerty1",
+	"111111", "12345", "1234567890", "1234567", "qwerty", "abc123",
+	"password1", "1q2w3e4r", "000000", "iloveyou", "1234", "dragon",
+	"123123", "monkey", "letmein", "baseball", "football", "sunshine",
+	"princess", "welcome", "shadow", "superman", "michael", "master",
+	"trustno1", "starwars", "passw0rd", "zaq12wsx", "qazwsx", "654321",
+	"666666", "121212", "whatever", "freedom", "ashley", "bailey",
+	"jennifer", "hunter2", "charlie", "donald", "login", "admin",
+	"admin123", "welcome1", "p@ssw0rd", "p@ssword", "qwertyuiop",
+	"asdfghjkl", "zxcvbnm", "1qaz2wsx", "q1w2e3r4", "mustang", "access",
+	"batman", "solo", "flower", "hottie", "loveme", "zaq1zaq1",
+	"password123", "password12", "aa123456", "123qwe", "7777777",
+	"88888888", "987654321", "11111111", "123321", "555555", "computer",
+	"internet", "secret", "cheese", "killer", "pepper", "ginger",
+	"jordan23", "soccer", "hockey", "summer", "winter", "autumn",
+	"spring", "changeme", "default", "guest", "test1234", "letmein1",
+	"iloveyou1", "monkey123", "dragon123", "abcd1234", "abcdef",
+	"1q2w3e", "qwe123", "asd123", "google", "samsung",
+)
+
+// commonWords are words that turn up inside many passwords. Finding one
+// costs an attacker about as much as picking it from a list of this size.
+// Longer words come first so that "password" is matched before "pass".
+var commonWords = byLengthDesc(
+	"password", "passw0rd", "qwerty", "asdf", "zxcv", "admin", "welcome",
+	"letmein", "login", "dragon", "monkey", "football", "baseball",
+	"soccer", "hockey", "iloveyou", "love", "sunshine", "princess",
+	"master", "shadow", "secret", "summer", "winter", "spring", "autumn",
+	"hello", "freedom", "whatever", "superman", "batman", "starwars",
+	"money", "finance", "budget", "bank", "dollar", "euro", "change",
+	"default", "guest", "test", "user", "pass", "god",
+	"angel", "baby", "jesus", "michael", "jordan", "charlie", "thomas",
+	"computer", "internet", "google", "apple", "orange", "banana",
+	"cookie", "cheese", "pepper", "ginger", "tiger", "killer", "lover",
+)
+
+func byLengthDesc(words ...string) []string {
+	sort.SliceStable(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	return words
+}
+
+func set(words ...string) map[string]struct{} {
+	m := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		m[w] = struct{}{}
+	}
+	return m
+}
@@ -0,0 +1,172 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scheme is one password hashing algorithm with its current parameters.
+type Scheme interface {
+	Hash(pw string) (string, error)
+	// Recognizes reports whether hash is in this scheme's format.
+	Recognizes(hash string) bool
+	Verify(hash, pw string) (bool, error)
+	// Outdated reports whether hash was made with weaker parameters than
+	// the scheme's current ones.
+	Outdated(hash string) bool
+}
+
+var ErrUnknownHash = errors.New("password: unrecognized hash format")
+
+// Hasher hashes new passwords with Preferred and verifies stored hashes in
+// any of Preferred or Accepted. Verify says when a stored hash should be
+// replaced, so parameters can be raised over time and old hashes upgraded
+// as users log in.
+type Hasher struct {
+	Preferred Scheme
+	Accepted  []Scheme
+}
+
+// DefaultHasher prefers Argon2id and still accepts bcrypt, in which every
+// password was stored before Argon2id was introduced.
+func DefaultHasher() Hasher {
+	return Hasher{Preferred: DefaultArgon2id(), Accepted: []Scheme{Bcrypt{Cost: bcrypt.DefaultCost}}}
+}
+
+func (h Hasher) Hash(pw string) (string, error) {
+	return h.Preferred.Hash(pw)
+}
+
+// Verify checks pw against a stored hash. needsRehash is true when the
+// password matched but the hash is in another scheme or has outdated
+// parameters; the caller should then store Hash(pw).
+func (h Hasher) Verify(hash, pw string) (ok, needsRehash bool, err error) {
+	if h.Preferred.Recognizes(hash) {
+		ok, err = h.Preferred.Verify(hash, pw)
+		return ok, ok && h.Preferred.Outdated(hash), err
+	}
+	for _, s := range h.Accepted {
+		if s.Recognizes(hash) {
+			ok, err = s.Verify(hash, pw)
+			return ok, ok, err
+		}
+	}
+	return false, false, ErrUnknownHash
+}
+
+// Argon2id hashes with RFC 9106 Argon2id and stores the result in the PHC
+// string format, $argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>.
+type Argon2id struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+	KeyLen    uint32
+	SaltLen   uint32
+}
+
+// DefaultArgon2id uses the parameters RFC 9106 recommends for machines
+// without 2 GiB to spare per hash: 3 passes over 64 MiB.
+func DefaultArgon2id() Argon2id {
+	return Argon2id{Time: 3, MemoryKiB: 64 * 1024, Threads: 4, KeyLen: 32, SaltLen: 16}
+}
+
+var b64 = base64.RawStdEncoding
+
+func (a Argon2id) Hash(pw string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(pw), salt, a.Time, a.MemoryKiB, a.Threads, a.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.MemoryKiB, a.Time, a.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+type argon2Params struct {
+	version      int
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parseArgon2id(hash string) (argon2Params, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil {
+		return p, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, ErrUnknownHash
+	}
+	var err error
+	if p.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return p, ErrUnknownHash
+	}
+	if p.key, err = b64.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return p, ErrUnknownHash
+	}
+	return p, nil
+}
+
+func (a Argon2id) Verify(hash, pw string) (bool, error) {
+	p, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	if p.version != argon2.Version {
+		return false, fmt.Errorf("password: unsupported argon2 version %d", p.version)
+	}
+	key := argon2.IDKey([]byte(pw), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (a Argon2id) Outdated(hash string) bool {
+	p, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return p.memory < a.MemoryKiB || p.time < a.Time || p.threads != a.Threads ||
+		uint32(len(p.key)) < a.KeyLen || uint32(len(p.salt)) < a.SaltLen
+}
+
+// Bcrypt is the scheme passwords were originally stored in. It only looks
+// at the first 72 bytes of a password.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(pw string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(pw), b.Cost)
+	return string(h), err
+}
+
+func (b Bcrypt) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b Bcrypt) Verify(hash, pw string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b Bcrypt) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.Cost
+}
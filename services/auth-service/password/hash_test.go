@@ -0,0 +1,65 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+// Small parameters keep the test fast; the format is what matters here.
+var testArgon = Argon2id{Time: 1, MemoryKiB: 64, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h, err := testArgon.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(h, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %q is not in PHC format", h)
+	}
+	if ok, err := testArgon.Verify(h, "correct horse"); !ok || err != nil {
+		t.Errorf("Verify(right password) = %v, %v", ok, err)
+	}
+	if ok, _ := testArgon.Verify(h, "correct horse "); ok {
+		t.Error("Verify accepted a different password")
+	}
+}
+
+func TestHasherRehash(t *testing.T) {
+	old := Bcrypt{Cost: 4}
+	bcryptHash, err := old.Hash("hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakArgon, err := Argon2id{Time: 1, MemoryKiB: 32, Threads: 1, KeyLen: 32, SaltLen: 16}.Hash("hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentArgon, err := testArgon.Hash("hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Hasher{Preferred: testArgon, Accepted: []Scheme{old}}
+	tests := []struct {
+		name, hash, pw string
+		ok, rehash     bool
+	}{
+		{"bcrypt", bcryptHash, "hunter22", true, true},
+		{"bcrypt wrong password", bcryptHash, "hunter23", false, false},
+		{"outdated argon2id", weakArgon, "hunter22", true, true},
+		{"current argon2id", currentArgon, "hunter22", true, false},
+	}
+	for _, tt := range tests {
+		ok, rehash, err := h.Verify(tt.hash, tt.pw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.ok || rehash != tt.rehash {
+			t.Errorf("%s: Verify = %v, %v; want %v, %v", tt.name, ok, rehash, tt.ok, tt.rehash)
+		}
+	}
+
+	if _, _, err := h.Verify("plaintext", "plaintext"); err != ErrUnknownHash {
+		t.Errorf("unknown format: err = %v, want ErrUnknownHash", err)
+	}
+}
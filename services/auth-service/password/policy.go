@@ -0,0 +1,99 @@
+// Package password decides whether a new password is acceptable: long
+// enough, hard enough to guess, not the user's email and not known from a
+// breach.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Problem is one reason a password was refused. Code is stable for
+// clients; Message is for people.
+type Problem struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeIsEmail  = "matches_email"
+	CodeWeak     = "too_weak"
+	CodeBreached = "breached"
+)
+
+// Policy is the set of rules for new passwords. The zero value accepts
+// anything non-empty; DefaultPolicy is what the service uses unless
+// configured otherwise.
+type Policy struct {
+	// MinLength and MaxLength count characters, not bytes. MaxLength zero
+	// means no limit beyond what the hasher accepts.
+	MinLength int
+	MaxLength int
+	// MaxBytes limits the UTF-8 encoding instead, for hashers such as
+	// bcrypt whose limit is in bytes; zero means no limit.
+	MaxBytes int
+	// MinScore is the least acceptable Strength, 0 to 4.
+	MinScore int
+	// Breached, if set, is consulted for passwords known from breaches.
+	Breached BreachList
+}
+
+// DefaultPolicy suits the default Argon2id hasher. With bcrypt, which
+// accepts at most 72 bytes, set MaxBytes to 72.
+func DefaultPolicy() Policy {
+	return Policy{MinLength: 8, MaxLength: 256, MinScore: 2}
+}
+
+// Check returns every rule the password breaks for the account with this
+// email, or nil if it is acceptable. The breach list is only consulted
+// once the cheaper rules pass. Passwords are taken exactly as typed:
+// leading and trailing spaces are part of the password.
+func (p Policy) Check(pw, email string) ([]Problem, error) {
+	var problems []Problem
+	n := utf8.RuneCountInString(pw)
+	if n == 0 || n < p.MinLength {
+		problems = append(problems, Problem{CodeTooShort,
+			fmt.Sprintf("password must be at least %d characters", max(p.MinLength, 1))})
+	}
+	switch {
+	case p.MaxLength > 0 && n > p.MaxLength:
+		problems = append(problems, Problem{CodeTooLong,
+			fmt.Sprintf("password must be at most %d characters", p.MaxLength)})
+	case p.MaxBytes > 0 && len(pw) > p.MaxBytes:
+		problems = append(problems, Problem{CodeTooLong,
+			fmt.Sprintf("password must be at most %d bytes; accented letters and other non-ASCII characters take two to four each", p.MaxBytes)})
+	}
+	if matchesEmail(pw, email) {
+		problems = append(problems, Problem{CodeIsEmail, "password must not be your email address"})
+	}
+	if s := Strength(pw, email); s < p.MinScore {
+		problems = append(problems, Problem{CodeWeak,
+			"password is too easy to guess; try a longer passphrase of unrelated words"})
+	}
+	if len(problems) > 0 || p.Breached == nil {
+		return problems, nil
+	}
+
+	breached, err := p.Breached.Contains(pw)
+	if err != nil {
+		return nil, err
+	}
+	if breached {
+		problems = append(problems, Problem{CodeBreached,
+			"password has appeared in a data breach; choose a different one"})
+	}
+	return problems, nil
+}
+
+func matchesEmail(pw, email string) bool {
+	if email == "" {
+		return false
+	}
+	pw = strings.ToLower(strings.TrimSpace(pw))
+	email = strings.ToLower(email)
+	local, _, _ := strings.Cut(email, "@")
+	return pw == email || pw == local
+}
@@ -0,0 +1,96 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStrength(t *testing.T) {
+	tests := []struct {
+		pw   string
+		want int
+	}{
+		{"password", 0},
+		{"123456789", 0},
+		{"aaaaaaaaaaaa", 0},
+		{"abcdefgh", 0},
+		{"summer2024", 1},
+		{"Tr0ub4dour&3", 4},
+		{"correct horse battery staple", 4},
+	}
+	for _, tt := range tests {
+		if got := Strength(tt.pw, ""); got != tt.want {
+			t.Errorf("Strength(%q) = %d, want %d", tt.pw, got, tt.want)
+		}
+	}
+}
+
+func codes(ps []Problem) string {
+	var out []string
+	for _, p := range ps {
+		out = append(out, p.Code)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestPolicyCheck(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("zebra-lamp-quietly-9"))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(dir, h[:5]+".txt"), []byte("0000000000000000000000000000000000A:1\r\n"+h[5:]+":42\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := DefaultPolicy()
+	p.Breached = RangeDir{Dir: dir}
+
+	tests := []struct {
+		pw, email, want string
+	}{
+		{"short", "", "too_short"},
+		{"jane.doe@example.com", "jane.doe@example.com", "matches_email"},
+		{"zebra-lamp-quietly-9", "", "breached"},
+		{"  zebra-lamp-quietly-9", "", ""},
+		{"violet anchor drifting", "jane@example.com", ""},
+		{strings.Repeat("x", 257), "", "too_long,too_weak"},
+	}
+	for _, tt := range tests {
+		got, err := p.Check(tt.pw, tt.email)
+		if err != nil {
+			t.Fatalf("Check(%q): %v", tt.pw, err)
+		}
+		if codes(got) != tt.want {
+			t.Errorf("Check(%q) = %q, want %q", tt.pw, codes(got), tt.want)
+		}
+	}
+}
+
+func TestPolicyMaxBytes(t *testing.T) {
+	p := DefaultPolicy()
+	p.MaxBytes = 72
+
+	tests := []struct {
+		name, pw, want string
+	}{
+		{"72 ASCII bytes", "violet anchor drifting " + strings.Repeat("x", 49), ""},
+		{"73 ASCII bytes", "violet anchor drifting " + strings.Repeat("x", 50), "too_long"},
+		// 43 characters in 72 bytes, then 44 characters in 74 bytes: well
+		// under a limit that counted characters.
+		{"72 bytes with two-byte letters", "violet anchor " + strings.Repeat("é", 29), ""},
+		{"74 bytes with two-byte letters", "violet anchor " + strings.Repeat("é", 30), "too_long"},
+		{"emoji", "violet anchor 🌊🌊🌊🌊🌊🌊🌊🌊🌊🌊🌊🌊🌊🌊🌊", "too_long"},
+	}
+	for _, tt := range tests {
+		got, err := p.Check(tt.pw, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codes(got) != tt.want {
+			t.Errorf("%s (%d characters, %d bytes): %q, want %q", tt.name, len([]rune(tt.pw)), len(tt.pw), codes(got), tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength scores how hard pw is to guess on zxcvbn's 0-4 scale:
+//
+//	0  < 10^3 guesses   (in a common-password list)
+//	1  < 10^6 guesses
+//	2  < 10^8 guesses
+//	3  < 10^10 guesses
+//	4  otherwise
+//
+// It is a deliberately small estimator, not a port of zxcvbn: it charges
+// full entropy per character from the character classes used, little for
+// repeats and runs such as "aaa" or "1234", and little for common
+// passwords and the user's own email appearing inside the password.
+func Strength(pw, userInput string) int {
+	log10 := guessesLog10(pw, userInput)
+	switch {
+	case log10 < 3:
+		return 0
+	case log10 < 6:
+		return 1
+	case log10 < 8:
+		return 2
+	case log10 < 10:
+		return 3
+	}
+	return 4
+}
+
+func guessesLog10(pw, userInput string) float64 {
+	lower := strings.ToLower(pw)
+	if _, ok := commonPasswords[lower]; ok {
+		return math.Log10(float64(len(commonPasswords)))
+	}
+
+	// Pieces of the user's own email are among the first things an
+	// attacker tries, so each costs a few bits rather than per character.
+	dictionary := make([]string, 0, 4)
+	local, domain, _ := strings.Cut(strings.ToLower(userInput), "@")
+	for _, w := range []string{local, strings.Split(domain, ".")[0]} {
+		if len([]rune(w)) >= 3 {
+			dictionary = append(dictionary, w)
+		}
+	}
+
+	runes := []rune(lower)
+	covered := make([]bool, len(runes))
+	bits := 0.0
+	for _, w := range dictionary {
+		if i := strings.Index(lower, w); i >= 0 {
+			start := len([]rune(lower[:i]))
+			for j := start; j < start+len([]rune(w)); j++ {
+				covered[j] = true
+			}
+			bits += 4
+		}
+	}
+	for _, w := range commonWords {
+		if i := strings.Index(lower, w); i >= 0 {
+			start := len([]rune(lower[:i]))
+			fresh := false
+			for j := start; j < start+len([]rune(w)); j++ {
+				if !covered[j] {
+					fresh = true
+				}
+				covered[j] = true
+			}
+			if fresh {
+				bits += math.Log2(float64(len(commonWords)))
+			}
+		}
+	}
+
+	// Four-digit years are among the first things tried.
+	for i := 0; i+4 <= len(runes); i++ {
+		if covered[i] || covered[i+1] || covered[i+2] || covered[i+3] {
+			continue
+		}
+		if y := string(runes[i : i+4]); y >= "1900" && y <= "2039" && isDigits(y) {
+			for j := i; j < i+4; j++ {
+				covered[j] = true
+			}
+			bits += math.Log2(140)
+		}
+	}
+
+	// What is left is charged per character, except that repeats and
+	// ascending or descending runs ("aaaa", "abcd", "4321") cost only the
+	// log of their length beyond the first character.
+	perChar := math.Log2(float64(poolSize(pw)))
+	for i := 0; i < len(runes); {
+		if covered[i] {
+			i++
+			continue
+		}
+		bits += perChar
+		j := i + 1
+		if j < len(runes) && !covered[j] {
+			d := runes[j] - runes[i]
+			if d >= -1 && d <= 1 {
+				for j+1 < len(runes) && !covered[j+1] && runes[j+1]-runes[j] == d {
+					j++
+				}
+				j++
+				bits += math.Log2(float64(j - i))
+			}
+		}
+		i = j
+	}
+	return bits * math.Log10(2)
+}
+
+// poolSize is the alphabet an attacker must search given the classes of
+// character pw uses.
+func poolSize(pw string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range pw {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	if lower {
+		n += 26
+	}
+	if upper {
+		n += 26
+	}
+	if digit {
+		n += 10
+	}
+	if symbol {
+		n += 33
+	}
+	if other {
+		n += 100
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in process memory. Limits are per instance, so
+// with several instances behind a load balancer each allows the full rate.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+	lastGC  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled, after which it carries
+	// no state and can be dropped.
+	full time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]bucket{}}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastGC) > time.Minute {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastGC = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = bucket{tokens: float64(limit.Requests), last: now}
+	}
+	tokens, res := take(b.tokens, b.last, now, limit)
+	s.buckets[key] = bucket{tokens: tokens, last: now, full: now.Add(res.Reset)}
+	return res, nil
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreBucket(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+	now := time.Unix(1700000000, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := s.Take(ctx, "k", limit, now)
+		if err != nil || !res.Allowed {
+			t.Fatalf("request %d: allowed=%v err=%v", i, res.Allowed, err)
+		}
+		if res.Remaining != 2-i {
+			t.Errorf("request %d: remaining = %d, want %d", i, res.Remaining, 2-i)
+		}
+	}
+
+	res, _ := s.Take(ctx, "k", limit, now)
+	if res.Allowed {
+		t.Fatal("fourth request in a burst of three was allowed")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("retry after = %v, want 1s", res.RetryAfter)
+	}
+
+	if res, _ := s.Take(ctx, "other", limit, now); !res.Allowed {
+		t.Error("buckets are not independent per key")
+	}
+
+	res, _ = s.Take(ctx, "k", limit, now.Add(time.Second))
+	if !res.Allowed {
+		t.Error("request after one refill interval was refused")
+	}
+	if res.Reset != 3*time.Second {
+		t.Errorf("reset = %v, want 3s", res.Reset)
+	}
+}
@@ -0,0 +1,65 @@
+// Package ratelimit implements token-bucket rate limiting over a pluggable
+// store, so that limits can be kept per process or shared through Redis.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit allows Requests per Per on average, with bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// rate is the refill speed in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Result describes the bucket after a Take.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed; zero
+	// when Allowed.
+	RetryAfter time.Duration
+}
+
+// Store takes one token from the bucket for key.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// take applies one request to a bucket holding tokens, last refilled at
+// last, and returns the new token count along with the result. Both stores
+// share it so that they behave identically.
+func take(tokens float64, last, now time.Time, limit Limit) (float64, Result) {
+	burst := float64(limit.Requests)
+	rate := limit.rate()
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens += elapsed * rate
+	}
+	if tokens > burst {
+		tokens = burst
+	}
+
+	res := Result{Limit: limit.Requests}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = seconds((burst - tokens) / rate)
+	return tokens, res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
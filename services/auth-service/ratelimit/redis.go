@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RedisClient is the one command RedisStore needs. go-redis satisfies it
+// with a small adapter:
+//
+//	func (a adapter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return a.Client.Eval(ctx, script, keys, args...).Result()
+//	}
+type RedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// RedisStore keeps buckets in Redis (or anything speaking its protocol and
+// Lua, such as Valkey or KeyDB), so that all instances share one limit.
+type RedisStore struct {
+	Client RedisClient
+	// Prefix namespaces the keys, e.g. "auth:rl:".
+	Prefix string
+}
+
+// takeScript is take() in Lua, run atomically. The bucket is a hash of
+// tokens and last-refill time in milliseconds, and expires once full.
+const takeScript = `
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(b[1])
+local last = tonumber(b[2])
+if tokens == nil then
+  tokens = burst
+  last = now
+end
+if now > last then
+  tokens = tokens + (now - last) / 1000 * rate
+end
+if tokens > burst then
+  tokens = burst
+end
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	reply, err := s.Client.Eval(ctx, takeScript, []string{s.Prefix + key},
+		limit.Requests, limit.rate(), now.UnixMilli())
+	if err != nil {
+		return Result{}, err
+	}
+	vals, ok := reply.([]interface{})
+	if !ok || len(vals) != 2 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+	allowed, _ := vals[0].(int64)
+	var tokens float64
+	if str, ok := vals[1].(string); !ok {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	} else if _, err := fmt.Sscan(str, &tokens); err != nil {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+
+	burst := float64(limit.Requests)
+	rate := limit.rate()
+	res := Result{
+		Allowed:   allowed == 1,
+		Limit:     limit.Requests,
+		Remaining: int(math.Max(tokens, 0)),
+		Reset:     seconds((burst - tokens) / rate),
+	}
+	if !res.Allowed {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return res, nil
+}
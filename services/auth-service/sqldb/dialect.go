@@ -0,0 +1,135 @@
+package sqldb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect builds the pieces of SQL that differ between databases.
+type Dialect interface {
+	// Name is SQLServer, Postgres or SQLite.
+	Name() string
+
+	// Rebind rewrites ? placeholders into the driver's own form.
+	Rebind(query string) string
+
+	// Returning makes an INSERT, UPDATE or DELETE return cols of the rows
+	// it touched. SQL Server wants "OUTPUT INSERTED.col" (or DELETED.col
+	// for DELETE) before VALUES or WHERE; the others want "RETURNING col"
+	// at the end. One of output and returning is always empty, so both
+	// can be spliced in unconditionally:
+	//
+	//	out, ret := d.Returning("INSERTED", "id")
+	//	q := "INSERT INTO t (a) " + out + " VALUES (?)" + ret
+	Returning(pseudo string, cols ...string) (output, returning string)
+
+	// Limit returns the clause that follows ORDER BY to select one page.
+	Limit(limit, offset int) string
+
+	// LockRows makes a SELECT hold update locks on the rows it reads until
+	// the transaction ends. SQL Server takes a table hint after the table
+	// name; PostgreSQL takes FOR UPDATE at the end. SQLite needs neither,
+	// since it allows one writer at a time.
+	LockRows() (tableHint, suffix string)
+
+	// Month extracts the month, 1 to 12, from a date expression.
+	Month(expr string) string
+}
+
+type sqlServer struct{}
+
+func (sqlServer) Name() string { return SQLServer }
+
+func (sqlServer) Rebind(query string) string { return query }
+
+func (sqlServer) Returning(pseudo string, cols ...string) (string, string) {
+	prefixed := make([]string, len(cols))
+	for i, c := range cols {
+		prefixed[i] = pseudo + "." + c
+	}
+	return "OUTPUT " + strings.Join(prefixed, ", "), ""
+}
+
+func (sqlServer) Limit(limit, offset int) string {
+	return fmt.Sprintf("OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", offset, limit)
+}
+
+func (sqlServer) LockRows() (string, string) { return "WITH (UPDLOCK, HOLDLOCK)", "" }
+
+func (sqlServer) Month(expr string) string { return "MONTH(" + expr + ")" }
+
+type postgres struct{}
+
+func (postgres) Name() string { return Postgres }
+
+// Rebind numbers the placeholders $1, $2, ..., leaving question marks in
+// string literals, quoted identifiers and comments alone.
+func (postgres) Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(query[i+1:], ch)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == '-' && i+1 < len(query) && query[i+1] == '-':
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+func (postgres) Returning(_ string, cols ...string) (string, string) {
+	return "", " RETURNING " + strings.Join(cols, ", ")
+}
+
+func (postgres) Limit(limit, offset int) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
+func (postgres) LockRows() (string, string) { return "", " FOR UPDATE" }
+
+func (postgres) Month(expr string) string { return "CAST(EXTRACT(MONTH FROM " + expr + ") AS INTEGER)" }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return SQLite }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) Returning(_ string, cols ...string) (string, string) {
+	return "", " RETURNING " + strings.Join(cols, ", ")
+}
+
+func (sqliteDialect) Limit(limit, offset int) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
+func (sqliteDialect) LockRows() (string, string) { return "", "" }
+
+func (sqliteDialect) Month(expr string) string {
+	return "CAST(strftime('%m', " + expr + ") AS INTEGER)"
+}
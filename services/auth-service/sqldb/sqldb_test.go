@@ -0,0 +1,144 @@
+package sqldb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		dialect string
+		driver  string
+		source  string
+	}{
+		{"server=db;user id=sa;password=x;database=finance", SQLServer, "mssql", "server=db;user id=sa;password=x;database=finance"},
+		{"Server=tcp:db,1433;Database=finance", SQLServer, "mssql", "Server=tcp:db,1433;Database=finance"},
+		{"sqlserver://sa:x@db?database=finance", SQLServer, "mssql", "sqlserver://sa:x@db?database=finance"},
+		{"mssql://sa:x@db?database=finance", SQLServer, "mssql", "sqlserver://sa:x@db?database=finance"},
+		{"postgres://app:x@db/finance", Postgres, "pgx", "postgres://app:x@db/finance"},
+		{"postgresql://app:x@db/finance?sslmode=disable", Postgres, "pgx", "postgresql://app:x@db/finance?sslmode=disable"},
+		{"sqlite:///var/lib/finance/app.db", SQLite, "sqlite", "file:/var/lib/finance/app.db?"},
+		{"sqlite:app.db", SQLite, "sqlite", "file:app.db?"},
+		{"sqlite::memory:", SQLite, "sqlite", "file::memory:?"},
+	}
+	for _, tt := range tests {
+		d, driver, source, err := parseDSN(tt.dsn)
+		if err != nil {
+			t.Errorf("%s: %v", tt.dsn, err)
+			continue
+		}
+		if d.Name() != tt.dialect || driver != tt.driver || !strings.HasPrefix(source, tt.source) {
+			t.Errorf("%s: got %s, %s, %s; want %s, %s, %s...", tt.dsn, d.Name(), driver, source, tt.dialect, tt.driver, tt.source)
+		}
+	}
+
+	for _, dsn := range []string{"mysql://app@db/finance", "sqlite:"} {
+		if _, _, _, err := parseDSN(dsn); err == nil {
+			t.Errorf("%s: parsed without error", dsn)
+		}
+	}
+}
+
+func TestSQLiteSourceKeepsOptions(t *testing.T) {
+	_, _, source, err := parseDSN("sqlite:///tmp/app.db?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"mode=ro", "foreign_keys", "busy_timeout", "_time_format=sqlite"} {
+		if !strings.Contains(source, want) {
+			t.Errorf("source %q lacks %s", source, want)
+		}
+	}
+}
+
+func TestPostgresRebind(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{"SELECT '?' FROM t WHERE a = ?", "SELECT '?' FROM t WHERE a = $1"},
+		{`SELECT "odd?col" FROM t WHERE a = ?`, `SELECT "odd?col" FROM t WHERE a = $1`},
+		{"SELECT 'it''s?' WHERE a = ?", "SELECT 'it''s?' WHERE a = $1"},
+		{"-- why?\nSELECT ?", "-- why?\nSELECT $1"},
+		{"SELECT 'unterminated ?", "SELECT 'unterminated ?"},
+	}
+	for _, tt := range tests {
+		if got := (postgres{}).Rebind(tt.in); got != tt.want {
+			t.Errorf("Rebind(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReturning(t *testing.T) {
+	out, ret := (sqlServer{}).Returning("INSERTED", "id", "created_at")
+	if out != "OUTPUT INSERTED.id, INSERTED.created_at" || ret != "" {
+		t.Errorf("sqlserver: %q, %q", out, ret)
+	}
+	for _, d := range []Dialect{postgres{}, sqliteDialect{}} {
+		out, ret := d.Returning("DELETED", "role")
+		if out != "" || ret != " RETURNING role" {
+			t.Errorf("%s: %q, %q", d.Name(), out, ret)
+		}
+	}
+}
+
+// TestPostgresStatements builds statements the way package models does and
+// checks the SQL that reaches the PostgreSQL driver, next to the SQL
+// Server form they were first written for.
+func TestPostgresStatements(t *testing.T) {
+	build := map[string]func(d Dialect) string{
+		"lock rows": func(d Dialect) string {
+			hint, suffix := d.LockRows()
+			return `SELECT tokens_valid_after FROM users ` + hint + ` WHERE id = ?` + suffix
+		},
+		"update returning": func(d Dialect) string {
+			out, ret := d.Returning("INSERTED", "locked_until")
+			return `UPDATE users SET failed_login_count = ? ` + out + ` WHERE id = ?` + ret
+		},
+		"insert returning": func(d Dialect) string {
+			out, ret := d.Returning("INSERTED", "id", "created_at")
+			return `INSERT INTO users (email, hashed_password) ` + out + ` VALUES (?, ?)` + ret
+		},
+		"delete returning": func(d Dialect) string {
+			out, ret := d.Returning("DELETED", "user_id")
+			return `DELETE FROM sessions ` + out + ` WHERE jti = ? AND note <> '?'` + ret
+		},
+		"page": func(d Dialect) string {
+			return `SELECT id FROM audit_log WHERE user_id = ? ORDER BY id DESC ` + d.Limit(20, 40)
+		},
+		"month": func(d Dialect) string {
+			return `SELECT ` + d.Month("date") + ` FROM transactions WHERE workspace_id = ?`
+		},
+	}
+	tests := []struct {
+		name, postgres, sqlServer string
+	}{
+		{"lock rows",
+			`SELECT tokens_valid_after FROM users  WHERE id = $1 FOR UPDATE`,
+			`SELECT tokens_valid_after FROM users WITH (UPDLOCK, HOLDLOCK) WHERE id = ?`},
+		{"update returning",
+			`UPDATE users SET failed_login_count = $1  WHERE id = $2 RETURNING locked_until`,
+			`UPDATE users SET failed_login_count = ? OUTPUT INSERTED.locked_until WHERE id = ?`},
+		{"insert returning",
+			`INSERT INTO users (email, hashed_password)  VALUES ($1, $2) RETURNING id, created_at`,
+			`INSERT INTO users (email, hashed_password) OUTPUT INSERTED.id, INSERTED.created_at VALUES (?, ?)`},
+		{"delete returning",
+			`DELETE FROM sessions  WHERE jti = $1 AND note <> '?' RETURNING user_id`,
+			`DELETE FROM sessions OUTPUT DELETED.user_id WHERE jti = ? AND note <> '?'`},
+		{"page",
+			`SELECT id FROM audit_log WHERE user_id = $1 ORDER BY id DESC LIMIT 20 OFFSET 40`,
+			`SELECT id FROM audit_log WHERE user_id = ? ORDER BY id DESC OFFSET 40 ROWS FETCH NEXT 20 ROWS ONLY`},
+		{"month",
+			`SELECT CAST(EXTRACT(MONTH FROM date) AS INTEGER) FROM transactions WHERE workspace_id = $1`,
+			`SELECT MONTH(date) FROM transactions WHERE workspace_id = ?`},
+	}
+	for _, tt := range tests {
+		q := build[tt.name]
+		if got := (postgres{}).Rebind(q(postgres{})); got != tt.postgres {
+			t.Errorf("%s, postgres:\n got %s\nwant %s", tt.name, got, tt.postgres)
+		}
+		if got := (sqlServer{}).Rebind(q(sqlServer{})); got != tt.sqlServer {
+			t.Errorf("%s, sqlserver:\n got %s\nwant %s", tt.name, got, tt.sqlServer)
+		}
+	}
+}
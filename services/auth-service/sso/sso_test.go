@@ -0,0 +1,88 @@
+package sso_test
+
+import (
+	"auth-service/sso"
+	"auth-service/sso/ssotest"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// signIn walks the browser's part of the flow against the mock provider
+// and returns the code and state it was redirected back with.
+func signIn(t *testing.T, p *sso.Provider, f sso.Flow) (string, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	mock := ssotest.NewProvider("client", "secret")
+	defer mock.Close()
+	mock.SetUser(ssotest.User{Subject: "42", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+
+	ctx := context.Background()
+	p, err := sso.NewProvider(ctx, sso.Config{
+		Name:         "mock",
+		Issuer:       mock.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/login/oidc/mock/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := sso.NewFlow()
+	code, state := signIn(t, p, f)
+	if state != f.State {
+		t.Fatalf("state = %q, want %q", state, f.State)
+	}
+	id, err := p.Exchange(ctx, code, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sso.Identity{Provider: "mock", Subject: "42", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+	if id != want {
+		t.Errorf("identity = %+v, want %+v", id, want)
+	}
+
+	// Codes are single use.
+	if _, err := p.Exchange(ctx, code, f); err == nil {
+		t.Error("second exchange of the same code succeeded")
+	}
+
+	// A code redeemed without the matching PKCE verifier is refused.
+	f2 := sso.NewFlow()
+	code, _ = signIn(t, p, f2)
+	stolen := f2
+	stolen.Verifier = sso.NewFlow().Verifier
+	if _, err := p.Exchange(ctx, code, stolen); err == nil {
+		t.Error("exchange with the wrong code verifier succeeded")
+	}
+
+	// An ID token minted for another login's nonce is refused.
+	f3 := sso.NewFlow()
+	code, _ = signIn(t, p, f3)
+	replay := f3
+	replay.Nonce = "something else"
+	if _, err := p.Exchange(ctx, code, replay); !errors.Is(err, sso.ErrNonceMismatch) {
+		t.Errorf("exchange with the wrong nonce: err = %v, want ErrNonceMismatch", err)
+	}
+}
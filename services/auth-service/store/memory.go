@@ -0,0 +1,383 @@
+package store
+
+import (
+	"auth-service/models"
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory implements every store interface in process memory, for tests.
+// It starts with the Uncategorized category that the migrations create;
+// the Add and Set methods fill in the rest.
+type Memory struct {
+	mu         sync.Mutex
+	users      map[int64]*memUser
+	nextID     int64
+	attempts   []models.LoginAttempt
+	txns       []Transaction
+	categories map[int64]string
+	aliases    map[string]int64
+	budgets    []memBudget
+}
+
+type memUser struct {
+	profile      models.Profile
+	user         models.User
+	hash         string
+	failures     int
+	lockedUntil  time.Time
+	totpEnabled  bool
+	verifySentAt time.Time
+}
+
+type memBudget struct {
+	wid, categoryID int64
+	month           time.Time
+	limit           float64
+}
+
+// Transaction is a row for Memory.AddTransaction.
+type Transaction struct {
+	WorkspaceID int64
+	Date        time.Time
+	Amount      float64
+	Category    string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:      map[int64]*memUser{},
+		categories: map[int64]string{1: UncategorizedName},
+		aliases:    map[string]int64{},
+	}
+}
+
+func (m *Memory) CreateUser(ctx context.Context, email, hashedPassword string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.user.Email == email {
+			return models.User{}, models.ErrEmailExists
+		}
+	}
+	m.nextID++
+	now := time.Now().UTC()
+	u := &memUser{
+		user: models.User{ID: m.nextID, Email: email, CreatedAt: now, Role: models.RoleUser},
+		profile: models.Profile{
+			ID: m.nextID, Email: email, BaseCurrency: "USD", Timezone: "UTC",
+			WeekStart: time.Monday, CreatedAt: now,
+		},
+		hash: hashedPassword,
+	}
+	m.users[u.user.ID] = u
+	return u.user, nil
+}
+
+func (m *Memory) byEmail(email string) *memUser {
+	for _, u := range m.users {
+		if u.user.Email == email {
+			return u
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UserForLogin(ctx context.Context, email string) (models.User, string, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.byEmail(email)
+	if u == nil {
+		return models.User{}, "", time.Time{}, sql.ErrNoRows
+	}
+	return u.user, u.hash, u.lockedUntil, nil
+}
+
+func (m *Memory) SetPasswordHash(ctx context.Context, uid int64, hashedPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.users[uid]; u != nil {
+		u.hash = hashedPassword
+	}
+	return nil
+}
+
+func (m *Memory) TOTPEnabled(ctx context.Context, uid int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[uid]
+	if u == nil {
+		return false, sql.ErrNoRows
+	}
+	return u.totpEnabled, nil
+}
+
+func (m *Memory) Profile(ctx context.Context, uid int64) (models.Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[uid]
+	if u == nil {
+		return models.Profile{}, sql.ErrNoRows
+	}
+	return u.profile, nil
+}
+
+func (m *Memory) ClaimVerificationSend(ctx context.Context, uid int64, minInterval time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[uid]
+	now := time.Now()
+	if u == nil || u.profile.EmailVerified || now.Sub(u.verifySentAt) < minInterval {
+		return false, nil
+	}
+	u.verifySentAt = now
+	return true, nil
+}
+
+func (m *Memory) RegisterLoginFailure(ctx context.Context, uid int64, maxFailures int, lockFor time.Duration) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[uid]
+	if u == nil {
+		return time.Time{}, sql.ErrNoRows
+	}
+	u.failures++
+	if u.failures < maxFailures {
+		return time.Time{}, nil
+	}
+	u.failures = 0
+	u.lockedUntil = time.Now().UTC().Add(lockFor).Truncate(time.Second)
+	return u.lockedUntil, nil
+}
+
+func (m *Memory) ResetLoginFailures(ctx context.Context, uid int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.users[uid]; u != nil {
+		u.failures = 0
+		u.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (m *Memory) RecordLoginAttempt(ctx context.Context, a models.LoginAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts = append(m.attempts, a)
+	return nil
+}
+
+// LoginAttempts returns the attempts recorded so far, oldest first.
+func (m *Memory) LoginAttempts() []models.LoginAttempt {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.LoginAttempt(nil), m.attempts...)
+}
+
+// SetTOTPEnabled turns the second factor on or off for the user.
+func (m *Memory) SetTOTPEnabled(uid int64, enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.users[uid]; u != nil {
+		u.totpEnabled = enabled
+	}
+}
+
+// DisableUser marks the user as disabled by an administrator.
+func (m *Memory) DisableUser(uid int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.users[uid]; u != nil {
+		now := time.Now().UTC()
+		u.user.DisabledAt = &now
+	}
+}
+
+// AddCategory adds a category and returns its id. Adding an existing name
+// returns the existing id.
+func (m *Memory) AddCategory(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.category(name)
+}
+
+func (m *Memory) category(name string) int64 {
+	var max int64
+	for id, n := range m.categories {
+		if n == name {
+			return id
+		}
+		if id > max {
+			max = id
+		}
+	}
+	m.categories[max+1] = name
+	return max + 1
+}
+
+// AddAlias makes alias another spelling of the category, which is added if
+// it does not exist.
+func (m *Memory) AddAlias(alias, category string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aliases[strings.ToLower(alias)] = m.category(category)
+}
+
+func (m *Memory) AddTransaction(t Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.txns = append(m.txns, t)
+}
+
+// SetBudget sets the workspace's limit for the category from month on; the
+// category is added if it does not exist.
+func (m *Memory) SetBudget(wid int64, category string, month time.Time, limit float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cid := m.category(category)
+	for i, b := range m.budgets {
+		if b.wid == wid && b.categoryID == cid && b.month.Equal(month) {
+			m.budgets[i].limit = limit
+			return
+		}
+	}
+	m.budgets = append(m.budgets, memBudget{wid: wid, categoryID: cid, month: month, limit: limit})
+}
+
+// between returns the workspace's transactions dated in [from, to).
+func (m *Memory) between(wid int64, from, to time.Time) []Transaction {
+	var out []Transaction
+	for _, t := range m.txns {
+		if t.WorkspaceID == wid && !t.Date.Before(from) && t.Date.Before(to) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func (m *Memory) Totals(ctx context.Context, wid int64, from, toExclusive time.Time) (Totals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var t Totals
+	for _, tx := range m.between(wid, from, toExclusive) {
+		if tx.Amount > 0 {
+			t.Income += tx.Amount
+		} else {
+			t.Expenses += tx.Amount
+		}
+		t.Net += tx.Amount
+	}
+	return t, nil
+}
+
+func (m *Memory) CategoryTotals(ctx context.Context, wid int64, from, toExclusive time.Time) ([]CategoryTotal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sums := map[string]float64{}
+	for _, tx := range m.between(wid, from, toExclusive) {
+		cat := tx.Category
+		if cat == "" {
+			cat = UncategorizedName
+		}
+		sums[cat] += tx.Amount
+	}
+	out := make([]CategoryTotal, 0, len(sums))
+	for cat, amount := range sums {
+		out = append(out, CategoryTotal{Category: cat, Amount: amount})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Amount != out[j].Amount {
+			return out[i].Amount > out[j].Amount
+		}
+		return out[i].Category < out[j].Category
+	})
+	return out, nil
+}
+
+func (m *Memory) Cashflow(ctx context.Context, wid int64, start, endExclusive time.Time) ([]MonthFlow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byMonth := map[int]*MonthFlow{}
+	for _, tx := range m.between(wid, start, endExclusive) {
+		month := int(tx.Date.Month())
+		f := byMonth[month]
+		if f == nil {
+			f = &MonthFlow{Month: month}
+			byMonth[month] = f
+		}
+		if tx.Amount > 0 {
+			f.Income += tx.Amount
+		} else {
+			f.Expenses += tx.Amount
+		}
+	}
+	out := make([]MonthFlow, 0, len(byMonth))
+	for _, f := range byMonth {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Month < out[j].Month })
+	return out, nil
+}
+
+func (m *Memory) BudgetsForMonth(ctx context.Context, wid int64, month time.Time) ([]Budget, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	latest := map[int64]memBudget{}
+	for _, b := range m.budgets {
+		if b.wid != wid || b.month.After(month) {
+			continue
+		}
+		if cur, ok := latest[b.categoryID]; !ok || b.month.After(cur.month) {
+			latest[b.categoryID] = b
+		}
+	}
+	out := make([]Budget, 0, len(latest))
+	for cid, b := range latest {
+		out = append(out, Budget{CategoryID: cid, Category: m.categories[cid], Limit: b.limit})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Category < out[j].Category })
+	return out, nil
+}
+
+func (m *Memory) SpentByCategory(ctx context.Context, wid int64, start, endExclusive time.Time) ([]CategorySpend, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byName := map[string]int64{}
+	for id, name := range m.categories {
+		byName[strings.ToLower(name)] = id
+	}
+	uncategorized := byName[strings.ToLower(UncategorizedName)]
+
+	spent := map[int64]float64{}
+	for _, tx := range m.between(wid, start, endExclusive) {
+		key := strings.ToLower(tx.Category)
+		cid, ok := byName[key]
+		if !ok {
+			cid, ok = m.aliases[key]
+		}
+		if !ok {
+			cid = uncategorized
+		}
+		// Income still puts its category in the result, with nothing spent.
+		var s float64
+		if tx.Amount < 0 {
+			s = -tx.Amount
+		}
+		spent[cid] += s
+	}
+	out := make([]CategorySpend, 0, len(spent))
+	for cid, s := range spent {
+		out = append(out, CategorySpend{CategoryID: cid, Category: m.categories[cid], Spent: s})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Spent != out[j].Spent {
+			return out[i].Spent > out[j].Spent
+		}
+		return out[i].Category < out[j].Category
+	})
+	return out, nil
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"auth-service/models"
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQL implements every store interface on the service database. Account
+// queries live in package models and are shared with the handlers that do
+// not go through a store yet.
+type SQL struct {
+	db *sqldb.DB
+}
+
+func NewSQL(db *sqldb.DB) *SQL {
+	return &SQL{db: db}
+}
+
+func (s *SQL) CreateUser(ctx context.Context, email, hashedPassword string) (models.User, error) {
+	return models.InsertUser(ctx, s.db, email, hashedPassword)
+}
+
+func (s *SQL) UserForLogin(ctx context.Context, email string) (models.User, string, time.Time, error) {
+	return models.GetUserForLogin(ctx, s.db, email)
+}
+
+func (s *SQL) SetPasswordHash(ctx context.Context, uid int64, hashedPassword string) error {
+	return models.UpdatePasswordHash(ctx, s.db, uid, hashedPassword)
+}
+
+func (s *SQL) TOTPEnabled(ctx context.Context, uid int64) (bool, error) {
+	return models.IsTOTPEnabled(ctx, s.db, uid)
+}
+
+func (s *SQL) Profile(ctx context.Context, uid int64) (models.Profile, error) {
+	return models.GetProfile(ctx, s.db, uid)
+}
+
+func (s *SQL) ClaimVerificationSend(ctx context.Context, uid int64, minInterval time.Duration) (bool, error) {
+	return models.ClaimVerificationSend(ctx, s.db, uid, minInterval)
+}
+
+func (s *SQL) RegisterLoginFailure(ctx context.Context, uid int64, maxFailures int, lockFor time.Duration) (time.Time, error) {
+	return models.RegisterLoginFailure(ctx, s.db, uid, maxFailures, lockFor)
+}
+
+func (s *SQL) ResetLoginFailures(ctx context.Context, uid int64) error {
+	return models.ResetLoginFailures(ctx, s.db, uid)
+}
+
+func (s *SQL) RecordLoginAttempt(ctx context.Context, a models.LoginAttempt) error {
+	return models.RecordLoginAttempt(ctx, s.db, a)
+}
+
+func (s *SQL) Totals(ctx context.Context, wid int64, from, toExclusive time.Time) (Totals, error) {
+	const q = `
+		SELECT
+			COALESCE(SUM(CASE WHEN amount > 0 THEN amount ELSE 0 END), 0) AS income,
+			COALESCE(SUM(CASE WHEN amount < 0 THEN amount ELSE 0 END), 0) AS expenses,
+			COALESCE(SUM(amount), 0) AS net
+		FROM transactions
+		WHERE workspace_id = ? AND date >= ? AND date < ?;`
+	var t Totals
+	err := s.db.QueryRowContext(ctx, q, wid, from, toExclusive).Scan(&t.Income, &t.Expenses, &t.Net)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Totals{}, nil
+	}
+	return t, err
+}
+
+func (s *SQL) CategoryTotals(ctx context.Context, wid int64, from, toExclusive time.Time) ([]CategoryTotal, error) {
+	const q = `
+		SELECT COALESCE(category, 'Uncategorized') AS category,
+		COALESCE(SUM(amount), 0) AS amount
+		FROM transactions
+		WHERE workspace_id = ? AND date >= ? AND date < ?
+		GROUP BY COALESCE(category, 'Uncategorized')
+		ORDER BY amount DESC;`
+	rows, err := s.db.QueryContext(ctx, q, wid, from, toExclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]CategoryTotal, 0, 16)
+	for rows.Next() {
+		var ct CategoryTotal
+		if err := rows.Scan(&ct.Category, &ct.Amount); err != nil {
+			return nil, err
+		}
+		out = append(out, ct)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQL) Cashflow(ctx context.Context, wid int64, start, endExclusive time.Time) ([]MonthFlow, error) {
+	month := s.db.Dialect.Month("date")
+	q := `
+		SELECT ` + month + ` AS m,
+		SUM(CASE WHEN amount > 0 THEN amount ELSE 0 END) AS income,
+		SUM(CASE WHEN amount < 0 THEN amount ELSE 0 END) AS expenses
+		FROM transactions
+		WHERE workspace_id = ? AND date >= ? AND date < ?
+		GROUP BY ` + month + `
+		ORDER BY m;`
+	rows, err := s.db.QueryContext(ctx, q, wid, start, endExclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]MonthFlow, 0, 12)
+	for rows.Next() {
+		var m MonthFlow
+		if err := rows.Scan(&m.Month, &m.Income, &m.Expenses); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQL) BudgetsForMonth(ctx context.Context, wid int64, month time.Time) ([]Budget, error) {
+	const q = `
+		SELECT
+		  c.id,
+		  c.name,
+		  b.monthly_limit
+		FROM budgets b
+		JOIN categories c
+		  ON c.id = b.category_id
+		WHERE b.workspace_id = ?
+		  AND b.month = (
+		    SELECT MAX(b2.month)
+		    FROM budgets b2
+		    WHERE b2.workspace_id = b.workspace_id AND b2.category_id = b.category_id AND b2.month <= ?
+		  )
+		ORDER BY c.name;`
+	rows, err := s.db.QueryContext(ctx, q, wid, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Budget, 0, 16)
+	for rows.Next() {
+		var b Budget
+		if err := rows.Scan(&b.CategoryID, &b.Category, &b.Limit); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *SQL) SpentByCategory(ctx context.Context, wid int64, start, endExclusive time.Time) ([]CategorySpend, error) {
+	const q = `
+		WITH norm AS (
+		  SELECT
+		    t.amount,
+		    -- try direct match to categories
+		    c.id   AS cat_id_direct,
+		    c.name AS cat_name_direct,
+		    -- try alias -> category
+		    c2.id   AS cat_id_alias,
+		    c2.name AS cat_name_alias
+		  FROM transactions t
+		  LEFT JOIN categories c
+		    ON LOWER(c.name) = LOWER(t.category)
+		  LEFT JOIN category_aliases a
+		    ON LOWER(a.alias) = LOWER(t.category)
+		  LEFT JOIN categories c2
+		    ON c2.id = a.category_id
+		  WHERE t.workspace_id = ? AND t.date >= ? AND t.date < ?
+		)
+		SELECT
+		  COALESCE(cat_id_direct, cat_id_alias, uc.id)       AS category_id,
+		  COALESCE(cat_name_direct, cat_name_alias, uc.name) AS name,
+		  SUM(CASE WHEN n.amount < 0 THEN -n.amount ELSE 0 END) AS spent
+		FROM norm n
+		CROSS JOIN (SELECT id, name FROM categories WHERE name = 'Uncategorized') uc
+		GROUP BY COALESCE(cat_id_direct, cat_id_alias, uc.id),
+		         COALESCE(cat_name_direct, cat_name_alias, uc.name)
+		ORDER BY spent DESC;`
+	rows, err := s.db.QueryContext(ctx, q, wid, start, endExclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]CategorySpend, 0, 16)
+	for rows.Next() {
+		var cs CategorySpend
+		if err := rows.Scan(&cs.CategoryID, &cs.Category, &cs.Spent); err != nil {
+			return nil, err
+		}
+		out = append(out, cs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
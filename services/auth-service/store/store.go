@@ -0,0 +1,99 @@
+// Package store is the data access the HTTP handlers depend on, expressed
+// as interfaces so that handlers can be exercised without a database. SQL
+// implements them over package sqldb; Memory is an in-process fake for
+// tests.
+//
+// Lookups that find nothing return sql.ErrNoRows, and CreateUser returns
+// models.ErrEmailExists for a taken address, from either implementation.
+package store
+
+import (
+	"auth-service/models"
+	"context"
+	"time"
+)
+
+// UncategorizedName is the category of transactions whose category matches
+// neither a category nor an alias.
+const UncategorizedName = "Uncategorized"
+
+// UserStore holds accounts and what the sign-up and login paths record
+// about them.
+type UserStore interface {
+	// CreateUser adds a user along with their personal workspace.
+	CreateUser(ctx context.Context, email, hashedPassword string) (models.User, error)
+	// UserForLogin returns the user, their password hash and the end of any
+	// lockout; the zero time means not locked.
+	UserForLogin(ctx context.Context, email string) (models.User, string, time.Time, error)
+	SetPasswordHash(ctx context.Context, uid int64, hashedPassword string) error
+	TOTPEnabled(ctx context.Context, uid int64) (bool, error)
+	Profile(ctx context.Context, uid int64) (models.Profile, error)
+	// ClaimVerificationSend reports whether a verification email may be
+	// sent to an unverified user, at most once per minInterval.
+	ClaimVerificationSend(ctx context.Context, uid int64, minInterval time.Duration) (bool, error)
+
+	// RegisterLoginFailure counts a wrong password and, on the
+	// maxFailures-th in a row, locks the account for lockFor. It returns
+	// the end of the lockout it started, or the zero time.
+	RegisterLoginFailure(ctx context.Context, uid int64, maxFailures int, lockFor time.Duration) (time.Time, error)
+	ResetLoginFailures(ctx context.Context, uid int64) error
+	RecordLoginAttempt(ctx context.Context, a models.LoginAttempt) error
+}
+
+// Totals sums a workspace's transactions over a period. Expenses are
+// negative.
+type Totals struct {
+	Income   float64 `json:"income"`
+	Expenses float64 `json:"expenses"`
+	Net      float64 `json:"net"`
+}
+
+// CategoryTotal is the net amount of one category over a period.
+type CategoryTotal struct {
+	Category string  `json:"category"`
+	Amount   float64 `json:"amount"`
+}
+
+// MonthFlow is the income and (negative) expenses of one calendar month.
+type MonthFlow struct {
+	Month    int // 1-12
+	Income   float64
+	Expenses float64
+}
+
+// TransactionStore answers the analytics questions about a workspace's
+// transactions. Periods include from and exclude the end.
+type TransactionStore interface {
+	Totals(ctx context.Context, wid int64, from, toExclusive time.Time) (Totals, error)
+	// CategoryTotals returns one entry per category, largest amount first.
+	CategoryTotals(ctx context.Context, wid int64, from, toExclusive time.Time) ([]CategoryTotal, error)
+	// Cashflow returns the months of the period that have transactions, in
+	// order. The period must lie within one year.
+	Cashflow(ctx context.Context, wid int64, start, endExclusive time.Time) ([]MonthFlow, error)
+}
+
+// Budget is the monthly limit in force for a category.
+type Budget struct {
+	CategoryID int64
+	Category   string
+	Limit      float64
+}
+
+// CategorySpend is how much was spent in a category, as a positive amount.
+type CategorySpend struct {
+	CategoryID int64
+	Category   string
+	Spent      float64
+}
+
+// BudgetStore answers the budget questions about a workspace.
+type BudgetStore interface {
+	// BudgetsForMonth carries budgets forward: each category that has ever
+	// had a budget gets the latest limit set on or before month. The result
+	// is ordered by category name.
+	BudgetsForMonth(ctx context.Context, wid int64, month time.Time) ([]Budget, error)
+	// SpentByCategory resolves each transaction's category by name, then by
+	// alias, falling back to Uncategorized, and sums the spending per
+	// category, largest first.
+	SpentByCategory(ctx context.Context, wid int64, start, endExclusive time.Time) ([]CategorySpend, error)
+}
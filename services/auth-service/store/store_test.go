@@ -0,0 +1,287 @@
+package store
+
+import (
+	"auth-service/migrate"
+	"auth-service/migrations"
+	"auth-service/models"
+	"auth-service/sqldb"
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fixture is a store plus a way to put transactions and budgets in it, so
+// that the same checks run against Memory and against SQL.
+type fixture interface {
+	UserStore
+	TransactionStore
+	BudgetStore
+	workspace(t *testing.T, uid int64) int64
+	addTransaction(t *testing.T, uid int64, tx Transaction)
+	addAlias(t *testing.T, alias, category string)
+	setBudget(t *testing.T, uid, wid int64, category string, month time.Time, limit float64)
+}
+
+type memoryFixture struct{ *Memory }
+
+func newMemoryFixture(t *testing.T) fixture {
+	m := NewMemory()
+	for _, name := range []string{"Coffee", "Groceries", "Rent"} {
+		m.AddCategory(name)
+	}
+	return memoryFixture{m}
+}
+
+// Memory does not keep workspaces; the user's id stands in for theirs.
+func (f memoryFixture) workspace(t *testing.T, uid int64) int64 { return uid }
+
+func (f memoryFixture) addTransaction(t *testing.T, uid int64, tx Transaction) {
+	f.AddTransaction(tx)
+}
+
+func (f memoryFixture) addAlias(t *testing.T, alias, category string) {
+	f.AddAlias(alias, category)
+}
+
+func (f memoryFixture) setBudget(t *testing.T, uid, wid int64, category string, month time.Time, limit float64) {
+	f.SetBudget(wid, category, month, limit)
+}
+
+type sqlFixture struct {
+	*SQL
+	n int
+}
+
+func newSQLFixture(t *testing.T) fixture {
+	db, err := sqldb.Open("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	r, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &sqlFixture{SQL: NewSQL(db)}
+}
+
+func (f *sqlFixture) workspace(t *testing.T, uid int64) int64 {
+	wid, _, err := models.GetDefaultWorkspace(context.Background(), f.db, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wid
+}
+
+func (f *sqlFixture) addTransaction(t *testing.T, uid int64, tx Transaction) {
+	f.n++
+	_, err := f.db.ExecContext(context.Background(), `
+	INSERT INTO transactions (user_id, workspace_id, date, amount, merchant, category, import_id)
+	VALUES (?, ?, ?, ?, 'Shop', ?, ?)`, uid, tx.WorkspaceID, tx.Date, tx.Amount, tx.Category, f.n)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *sqlFixture) addAlias(t *testing.T, alias, category string) {
+	_, err := f.db.ExecContext(context.Background(), `
+	INSERT INTO category_aliases (alias, category_id)
+	SELECT ?, id FROM categories WHERE name = ?`, alias, category)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *sqlFixture) setBudget(t *testing.T, uid, wid int64, category string, month time.Time, limit float64) {
+	_, err := f.db.ExecContext(context.Background(), `
+	INSERT INTO budgets (user_id, workspace_id, category_id, month, monthly_limit)
+	SELECT ?, ?, id, ?, ? FROM categories WHERE name = ?`, uid, wid, month, limit, category)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var fixtures = map[string]func(*testing.T) fixture{
+	"memory": newMemoryFixture,
+	"sql":    newSQLFixture,
+}
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestUserStore(t *testing.T) {
+	for name, open := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			ctx := context.Background()
+
+			u, err := s.CreateUser(ctx, "ana@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.CreateUser(ctx, "ana@example.com", "hash"); !errors.Is(err, models.ErrEmailExists) {
+				t.Errorf("duplicate CreateUser = %v, want ErrEmailExists", err)
+			}
+			if _, _, _, err := s.UserForLogin(ctx, "nobody@example.com"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("UserForLogin for an unknown email = %v, want sql.ErrNoRows", err)
+			}
+			if _, err := s.Profile(ctx, u.ID+100); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("Profile for an unknown user = %v, want sql.ErrNoRows", err)
+			}
+			p, err := s.Profile(ctx, u.ID)
+			if err != nil || p.Email != u.Email || p.BaseCurrency != "USD" || p.WeekStart != time.Monday {
+				t.Errorf("Profile = %+v, %v", p, err)
+			}
+
+			for i := 1; i <= 2; i++ {
+				until, err := s.RegisterLoginFailure(ctx, u.ID, 2, time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if locked := !until.IsZero(); locked != (i == 2) {
+					t.Errorf("failure %d: locked until %v", i, until)
+				}
+			}
+			if _, err := s.RegisterLoginFailure(ctx, 0, 2, time.Minute); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("RegisterLoginFailure for no user = %v, want sql.ErrNoRows", err)
+			}
+			if _, _, locked, err := s.UserForLogin(ctx, u.Email); err != nil || !locked.After(time.Now()) {
+				t.Errorf("UserForLogin lockout = %v, %v", locked, err)
+			}
+			if err := s.ResetLoginFailures(ctx, u.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetPasswordHash(ctx, u.ID, "new"); err != nil {
+				t.Fatal(err)
+			}
+			if _, hash, locked, err := s.UserForLogin(ctx, u.Email); err != nil || hash != "new" || !locked.IsZero() {
+				t.Errorf("UserForLogin = %q, %v, %v", hash, locked, err)
+			}
+
+			if ok, err := s.ClaimVerificationSend(ctx, u.ID, time.Minute); err != nil || !ok {
+				t.Errorf("first ClaimVerificationSend = %v, %v", ok, err)
+			}
+			if ok, err := s.ClaimVerificationSend(ctx, u.ID, time.Minute); err != nil || ok {
+				t.Errorf("second ClaimVerificationSend = %v, %v", ok, err)
+			}
+			if on, err := s.TOTPEnabled(ctx, u.ID); err != nil || on {
+				t.Errorf("TOTPEnabled = %v, %v", on, err)
+			}
+		})
+	}
+}
+
+func TestTransactionStore(t *testing.T) {
+	for name, open := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			ctx := context.Background()
+			u, err := s.CreateUser(ctx, "ben@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wid := s.workspace(t, u.ID)
+			for _, tx := range []Transaction{
+				{Date: day(2025, 1, 5), Amount: 1000, Category: "Salary"},
+				{Date: day(2025, 1, 9), Amount: -40, Category: "Groceries"},
+				{Date: day(2025, 3, 1), Amount: -15, Category: "Groceries"},
+				{Date: day(2025, 3, 31), Amount: -5, Category: "Coffee"},
+				{Date: day(2025, 4, 1), Amount: -999, Category: "Rent"},
+				{Date: day(2024, 12, 31), Amount: -999, Category: "Rent"},
+			} {
+				tx.WorkspaceID = wid
+				s.addTransaction(t, u.ID, tx)
+			}
+			from, to := day(2025, 1, 1), day(2025, 4, 1)
+
+			totals, err := s.Totals(ctx, wid, from, to)
+			if err != nil || totals != (Totals{Income: 1000, Expenses: -60, Net: 940}) {
+				t.Errorf("Totals = %+v, %v", totals, err)
+			}
+			if totals, err := s.Totals(ctx, wid+100, from, to); err != nil || totals != (Totals{}) {
+				t.Errorf("Totals for another workspace = %+v, %v", totals, err)
+			}
+
+			cats, err := s.CategoryTotals(ctx, wid, from, to)
+			want := []CategoryTotal{{"Salary", 1000}, {"Coffee", -5}, {"Groceries", -55}}
+			if err != nil || !reflect.DeepEqual(cats, want) {
+				t.Errorf("CategoryTotals = %+v, %v; want %+v", cats, err, want)
+			}
+
+			flow, err := s.Cashflow(ctx, wid, day(2025, 1, 1), day(2026, 1, 1))
+			wantFlow := []MonthFlow{{1, 1000, -40}, {3, 0, -20}, {4, 0, -999}}
+			if err != nil || !reflect.DeepEqual(flow, wantFlow) {
+				t.Errorf("Cashflow = %+v, %v; want %+v", flow, err, wantFlow)
+			}
+		})
+	}
+}
+
+func TestBudgetStore(t *testing.T) {
+	for name, open := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			ctx := context.Background()
+			u, err := s.CreateUser(ctx, "cy@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wid := s.workspace(t, u.ID)
+			s.setBudget(t, u.ID, wid, "Groceries", day(2025, 1, 1), 300)
+			s.setBudget(t, u.ID, wid, "Groceries", day(2025, 3, 1), 250)
+			s.setBudget(t, u.ID, wid, "Coffee", day(2025, 2, 1), 40)
+			s.setBudget(t, u.ID, wid, "Rent", day(2025, 6, 1), 1200)
+
+			budgets, err := s.BudgetsForMonth(ctx, wid, day(2025, 4, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]float64{}
+			var names []string
+			for _, b := range budgets {
+				got[b.Category] = b.Limit
+				names = append(names, b.Category)
+			}
+			if !reflect.DeepEqual(got, map[string]float64{"Coffee": 40, "Groceries": 250}) ||
+				!reflect.DeepEqual(names, []string{"Coffee", "Groceries"}) {
+				t.Errorf("BudgetsForMonth(April) = %+v", budgets)
+			}
+			if budgets, err := s.BudgetsForMonth(ctx, wid, day(2024, 12, 1)); err != nil || len(budgets) != 0 {
+				t.Errorf("BudgetsForMonth before any budget = %+v, %v", budgets, err)
+			}
+
+			s.addAlias(t, "Cafe", "Coffee")
+			for _, tx := range []Transaction{
+				{Date: day(2025, 4, 2), Amount: -20, Category: "groceries"},
+				{Date: day(2025, 4, 3), Amount: -4, Category: "Cafe"},
+				{Date: day(2025, 4, 4), Amount: -3, Category: "Coffee"},
+				{Date: day(2025, 4, 5), Amount: -9, Category: "Mystery"},
+				{Date: day(2025, 4, 6), Amount: 50, Category: "Refunds"},
+				{Date: day(2025, 5, 1), Amount: -70, Category: "Groceries"},
+			} {
+				tx.WorkspaceID = wid
+				s.addTransaction(t, u.ID, tx)
+			}
+			spent, err := s.SpentByCategory(ctx, wid, day(2025, 4, 1), day(2025, 5, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotSpent []CategoryTotal
+			for _, cs := range spent {
+				gotSpent = append(gotSpent, CategoryTotal{cs.Category, cs.Spent})
+			}
+			// Mystery spent 9 and Refunds nothing; both are Uncategorized.
+			wantSpent := []CategoryTotal{{"Groceries", 20}, {"Uncategorized", 9}, {"Coffee", 7}}
+			if !reflect.DeepEqual(gotSpent, wantSpent) {
+				t.Errorf("SpentByCategory = %+v, want %+v", gotSpent, wantSpent)
+			}
+		})
+	}
+}
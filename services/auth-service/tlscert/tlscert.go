@@ -0,0 +1,113 @@
+// Package tlscert serves a TLS certificate from a pair of PEM files and
+// picks up a renewed certificate without a restart, so that tools such as
+// cert-manager or certbot can replace the files in place.
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader holds the certificate loaded from CertFile and KeyFile.
+type Reloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// Load reads the certificate chain in certFile and its private key in
+// keyFile.
+func Load(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is for tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload reads the files again. On error the current certificate stays in
+// use.
+func (r *Reloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tlscert: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// Run reloads the certificate every interval, if either file has changed,
+// until ctx is done. It passes the outcome of each check to report.
+func (r *Reloader) Run(ctx context.Context, every time.Duration, report func(error)) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.reloadChanged()
+			if err != nil {
+				log.Print(err)
+			}
+			report(err)
+		}
+	}
+}
+
+// reloadChanged reloads the certificate if either file has changed since
+// it was loaded.
+func (r *Reloader) reloadChanged() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+	if err := r.Reload(); err != nil {
+		return err
+	}
+	log.Printf("tlscert: reloaded %s", r.certFile)
+	return nil
+}
+
+// lastModified is the later of the two files' modification times. A
+// renewal replaces both, but not necessarily in the same instant.
+func (r *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("tlscert: %w", err)
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
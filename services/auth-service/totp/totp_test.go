@@ -0,0 +1,57 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1 vectors truncated to six digits.
+func TestCodeAtRFC6238(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := CodeAt(secret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("CodeAt(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	c := Counter(now)
+
+	for _, step := range []int64{-1, 0, 1} {
+		code, _ := CodeAt(secret, c+step)
+		got, ok := Validate(secret, code, now)
+		if !ok || got != c+step {
+			t.Errorf("step %+d: Validate = (%d, %v), want (%d, true)", step, got, ok, c+step)
+		}
+	}
+	for _, step := range []int64{-2, 2} {
+		code, _ := CodeAt(secret, c+step)
+		if _, ok := Validate(secret, code, now); ok {
+			t.Errorf("step %+d: code accepted outside the skew window", step)
+		}
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+}